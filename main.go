@@ -1,28 +1,54 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/config"
+	"stream-upload-file/pkg/deadline"
+	"stream-upload-file/pkg/events"
 	"stream-upload-file/pkg/filehandler"
+	"stream-upload-file/pkg/health"
+	"stream-upload-file/pkg/metrics"
+	"stream-upload-file/pkg/policy"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/proxy"
+	"stream-upload-file/pkg/quota"
+	"stream-upload-file/pkg/ratelimit"
+	"stream-upload-file/pkg/requestid"
 	"stream-upload-file/pkg/storage"
+	"stream-upload-file/pkg/tenant"
+	"stream-upload-file/pkg/throttle"
+	"stream-upload-file/pkg/tlsconfig"
+	"stream-upload-file/pkg/tracing"
+	"stream-upload-file/pkg/webhook"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	azpolicy "github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/gin-gonic/gin"
-	"github.com/pires/go-proxyproto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
 const (
-	healthzPath = "/healthz"
-	readyzPath  = "/readyz"
-	versionPath = "/version"
+	healthzPath     = "/healthz"
+	readyzPath      = "/readyz"
+	deepHealthzPath = "/healthz/deep"
+	versionPath     = "/version"
 )
 
 var (
@@ -43,11 +69,74 @@ func main() {
 
 	zap.ReplaceGlobals(logger)
 
+	// Settings come from an optional config file, the environment and flags
+	loader, err := config.NewLoader(os.Args[0], os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logger.Fatal("Invalid command line", zap.Error(err))
+	}
+	cfg, err := loader.Load()
+	if err != nil {
+		logger.Fatal("Invalid configuration", zap.String("file", loader.File), zap.Error(err))
+	}
+	if loader.PrintConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			logger.Fatal("Failed to print configuration", zap.Error(err))
+		}
+		os.Stdout.Write(out)
+		return
+	}
+	// Settings that can change at runtime register with the reloader
+	reloader := config.NewReloader(loader, cfg)
+	applyLogLevel := func(cfg *config.Config) error {
+		level, err := zapcore.ParseLevel(cfg.Log.Level)
+		logConfig.Level.SetLevel(level)
+		return err
+	}
+	applyLogLevel(cfg)
+	reloader.OnReload("log level", applyLogLevel, "log.level")
+
+	// Tracing is configured through the standard OTEL_* variables. It is set
+	// up first so that the storage clients pick up the tracer provider.
+	shutdownTracing := func(context.Context) error { return nil }
+	if tracing.Enabled() {
+		if shutdownTracing, err = tracing.Setup(context.Background()); err != nil {
+			logger.Fatal("Failed to set up tracing", zap.Error(err))
+		}
+		logger.Info("Tracing enabled")
+	}
+
+	// Dependencies are probed in the background; readiness drops while a
+	// critical one is down
+	monitor := health.NewMonitor(health.Config{
+		Interval:         cfg.Health.Interval,
+		Timeout:          cfg.Health.Timeout,
+		FailureThreshold: cfg.Health.FailureThreshold,
+		SuccessThreshold: cfg.Health.SuccessThreshold,
+		OnChange: func(healthy bool) {
+			metrics.SetReady(healthy && atomic.LoadInt32(&ready) == 1)
+		},
+	})
+
 	// Set up Gin with zap
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
+	// Forwarded-for headers are only believed from known proxies, so that
+	// c.ClientIP() can't be spoofed for audit and rate limiting
+	if err := r.SetTrustedProxies(cfg.Proxy.TrustedProxies); err != nil {
+		logger.Fatal("Invalid trusted proxies", zap.Error(err))
+	}
 	r.Use(gin.Recovery())
+	r.Use(requestid.Middleware())
+	r.Use(deadline.Middleware(cfg.Server.TransferIdleTimeout, cfg.Server.MaxTransferDuration))
+	if tracing.Enabled() {
+		r.Use(tracing.Middleware(healthzPath, readyzPath, deepHealthzPath, "/metrics"))
+	}
 	r.Use(GinZapMiddleware(logger))
+	r.Use(metrics.Middleware())
 
 	// Liveness probe: always returns 200 if process is running
 	r.GET(healthzPath, func(c *gin.Context) {
@@ -56,13 +145,16 @@ func main() {
 
 	// Readiness probe: returns 200 only if ready to serve traffic
 	r.GET(readyzPath, func(c *gin.Context) {
-		if atomic.LoadInt32(&ready) == 1 {
+		if atomic.LoadInt32(&ready) == 1 && monitor.Ready() {
 			c.Status(http.StatusOK)
 		} else {
 			c.Status(http.StatusServiceUnavailable)
 		}
 	})
 
+	// Per-dependency status, for operators and dashboards
+	r.GET(deepHealthzPath, monitor.Handler())
+
 	// Version endpoint for debugging
 	r.GET(versionPath, func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -71,48 +163,495 @@ func main() {
 		})
 	})
 
-	// Create storage client
-	storageClient, err := storage.NewAzureBlobClient()
+	// Create storage client. The SDK reads a MaxRetries of 0 as its default,
+	// so "no retries" is spelled -1.
+	retry := &azpolicy.RetryOptions{
+		MaxRetries:    int32(cfg.Storage.Retry.MaxRetries),
+		RetryDelay:    cfg.Storage.Retry.RetryDelay,
+		MaxRetryDelay: cfg.Storage.Retry.MaxRetryDelay,
+	}
+	if retry.MaxRetries == 0 {
+		retry.MaxRetries = -1
+	}
+	storageClient, err := storage.NewAzureBlobClientWithConfig(storage.AzureBlobConfig{
+		AccountName:   cfg.Storage.AccountName,
+		ContainerName: cfg.Storage.ContainerName,
+		Retry:         retry,
+	})
 	if err != nil {
 		logger.Fatal("Failed to create Azure storage client", zap.Error(err))
 	}
+	monitor.Add("storage", true, storageClient.Ping)
+
+	// Optionally encrypt objects before they leave the service
+	var keys storage.KeyWrapper
+	if keyFile := cfg.Storage.EncryptionKeyFile; keyFile != "" {
+		localKeys, err := storage.NewLocalKeyWrapperFromFile(keyFile)
+		if err != nil {
+			logger.Fatal("Failed to load storage encryption key", zap.Error(err))
+		}
+		keys = localKeys
+		logger.Info("Storage encryption enabled", zap.String("keyId", localKeys.KeyID()))
+	}
+
+	// Optionally compress objects at rest
+	encoding := cfg.Storage.Compression
+
+	// atRest applies encryption and compression to a store, the default one
+	// and those of tenants alike. Compression wraps encryption so that it
+	// sees plaintext.
+	atRest := func(s storage.BlobStore) (storage.BlobStore, error) {
+		if keys != nil {
+			s = storage.NewEncryptingClient(s, keys)
+		}
+		if encoding != "" {
+			return storage.NewCompressingClient(s, encoding)
+		}
+		return s, nil
+	}
+	blobStore, err := atRest(storageClient)
+	if err != nil {
+		logger.Fatal("Failed to enable storage compression", zap.Error(err))
+	}
+	if encoding != "" {
+		logger.Info("Storage compression enabled", zap.String("encoding", encoding))
+	}
+
+	handlerOpts := []filehandler.Option{
+		filehandler.WithMaxUploadSize(cfg.Uploads.MaxSize),
+		// Deadlines for storage work; 0 leaves an operation bounded only by
+		// the client staying connected
+		filehandler.WithTimeouts(filehandler.Timeouts{
+			Upload:    cfg.Storage.Timeouts.Upload,
+			Download:  cfg.Storage.Timeouts.Download,
+			Operation: cfg.Storage.Timeouts.Operation,
+			Publish:   filehandler.DefaultTimeouts.Publish,
+		}),
+	}
+
+	// Presigned URLs are only issued when a signing secret is configured
+	if secretFile := cfg.Presign.SecretFile; secretFile != "" {
+		signer, err := presign.NewSignerFromFile(secretFile)
+		if err != nil {
+			logger.Fatal("Failed to load presign secret", zap.Error(err))
+		}
+		handlerOpts = append(handlerOpts, filehandler.WithPresigner(signer))
+		logger.Info("Presigned URLs enabled")
+	}
+
+	// Direct uploads bypass the service, so they would skip the encryption and
+	// compression applied above. Only offer them when neither is enabled.
+	directUploads := false
+	if cfg.Uploads.DirectEnabled {
+		if blobStore != storage.BlobStore(storageClient) {
+			logger.Warn("Direct uploads disabled because storage encryption or compression is enabled")
+		} else {
+			handlerOpts = append(handlerOpts, filehandler.WithDirectUploader(storageClient))
+			directUploads = true
+			logger.Info("Direct uploads enabled")
+		}
+	}
+
+	// Background work such as credential reloading stops with the server
+	appCtx, stopApp := context.WithCancel(context.Background())
+	defer stopApp()
+
+	// Multi-tenant mode maps each tenant to its own container, prefix or
+	// account. Tenant storage is created on first use.
+	tenancy := func(c *gin.Context) { c.Next() }
+	usageStores := func() (map[string]quota.Lister, error) {
+		return map[string]quota.Lister{"": blobStore}, nil
+	}
+	if tenantsFile := cfg.Tenants.File; tenantsFile != "" {
+		tenantCfg, err := tenant.LoadConfig(tenantsFile)
+		if err != nil {
+			logger.Fatal("Failed to load tenants", zap.Error(err))
+		}
+		stores := tenant.NewStores(func(t *tenant.Tenant) (storage.BlobStore, error) {
+			var base storage.BlobStore = storageClient
+			if t.Account != "" || t.Container != "" || t.ClientID != "" {
+				client, err := storage.NewAzureBlobClientWithConfig(storage.AzureBlobConfig{
+					AccountName:   cmp.Or(t.Account, cfg.Storage.AccountName),
+					ContainerName: cmp.Or(t.Container, cfg.Storage.ContainerName),
+					ClientID:      t.ClientID,
+					Retry:         retry,
+				})
+				if err != nil {
+					return nil, err
+				}
+				base = client
+			}
+			// The prefixing store wraps encryption, which then sees and
+			// binds objects to their full name, so they can't be moved
+			// between tenants
+			s, err := atRest(base)
+			if err != nil || t.Prefix == "" {
+				return s, err
+			}
+			return storage.NewPrefixedClient(s, t.Prefix), nil
+		})
+		handlerOpts = append(handlerOpts, filehandler.WithTenantStores(stores))
+		resolver := tenant.NewResolver(tenantCfg)
+		tenancy = tenant.Middleware(resolver)
+		usageStores = func() (map[string]quota.Lister, error) {
+			all := map[string]quota.Lister{}
+			var errs []error
+			for _, t := range resolver.Tenants() {
+				s, err := stores.Store(t)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("tenant %s: %w", t.Name, err))
+					continue
+				}
+				all[t.Name] = s
+			}
+			return all, errors.Join(errs...)
+		}
+		logger.Info("Multi-tenant mode enabled",
+			zap.String("source", tenantCfg.Source),
+			zap.Int("tenants", len(tenantCfg.Tenants)),
+		)
+	}
+
+	// Per-prefix access control on top of credential scopes
+	if policyFile := cfg.Policy.File; policyFile != "" {
+		engine, err := policy.Load(policyFile)
+		if err != nil {
+			logger.Fatal("Failed to load RBAC policy", zap.Error(err))
+		}
+		go engine.Watch(appCtx, 30*time.Second)
+		reloader.OnReload("RBAC policy", func(*config.Config) error {
+			_, err := engine.Reload()
+			return err
+		})
+		handlerOpts = append(handlerOpts, filehandler.WithAuthorizer(engine))
+		logger.Info("RBAC policy enabled")
+	}
+
+	// Storage quotas, kept up to date on upload and delete and reconciled
+	// against the storage listing
+	if quotasFile := cfg.Quotas.File; quotasFile != "" {
+		quotaCfg, err := quota.LoadConfig(quotasFile)
+		if err != nil {
+			logger.Fatal("Failed to load quotas", zap.Error(err))
+		}
+		interval := cfg.Quotas.ReconcileInterval
+		tracker := quota.NewTracker(quotaCfg)
+		go tracker.RunReconciler(appCtx, interval, usageStores)
+		handlerOpts = append(handlerOpts, filehandler.WithUsageTracker(tracker))
+		logger.Info("Storage quotas enabled", zap.Duration("reconcileInterval", interval))
+	}
+
+	// Bandwidth limits, so bulk transfers can't starve interactive users
+	if bandwidthFile := cfg.Bandwidth.LimitsFile; bandwidthFile != "" {
+		bandwidthCfg, err := throttle.LoadConfig(bandwidthFile)
+		if err != nil {
+			logger.Fatal("Failed to load bandwidth limits", zap.Error(err))
+		}
+		throttler := throttle.New(bandwidthCfg)
+		reloader.OnReload("bandwidth limits", func(*config.Config) error {
+			bandwidthCfg, err := throttle.LoadConfig(bandwidthFile)
+			if err != nil {
+				return err
+			}
+			throttler.SetConfig(bandwidthCfg)
+			return nil
+		})
+		handlerOpts = append(handlerOpts, filehandler.WithThrottle(throttler))
+		logger.Info("Bandwidth limits enabled",
+			zap.Int64("perConnection", bandwidthCfg.Default.PerConnection),
+			zap.Int64("total", bandwidthCfg.Default.Total),
+			zap.Int("tenantOverrides", len(bandwidthCfg.Tenants)),
+		)
+	}
+
+	// Signed webhooks announcing completed uploads
+	var dispatcher *webhook.Dispatcher
+	var background sync.WaitGroup
+	if webhooksFile := cfg.Webhooks.File; webhooksFile != "" {
+		webhookCfg, err := webhook.LoadConfig(webhooksFile)
+		if err != nil {
+			logger.Fatal("Failed to load webhooks", zap.Error(err))
+		}
+		var deadLetters webhook.DeadLetterStore = webhook.NewMemoryStore()
+		if dir := cfg.Webhooks.DeadLetterDir; dir != "" {
+			if deadLetters, err = webhook.NewDirStore(dir); err != nil {
+				logger.Fatal("Failed to open webhook dead-letter store", zap.Error(err))
+			}
+		}
+		dispatcher = webhook.NewDispatcher(webhookCfg, deadLetters)
+		reloader.OnReload("webhooks", func(*config.Config) error {
+			webhookCfg, err := webhook.LoadConfig(webhooksFile)
+			if err != nil {
+				return err
+			}
+			dispatcher.SetConfig(webhookCfg)
+			return nil
+		})
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			dispatcher.Run(appCtx, 4)
+		}()
+		handlerOpts = append(handlerOpts, filehandler.WithWebhooks(dispatcher))
+		logger.Info("Webhooks enabled", zap.Int("targets", len(webhookCfg.Targets)))
+	}
+
+	// CloudEvents about uploads, downloads and deletes on a message bus
+	var publisher events.Publisher
+	switch cfg.Events.Backend {
+	case config.EventsNATS:
+		natsPublisher, err := events.NewNATS(cfg.Events.NATS.URL, cfg.Events.NATS.SubjectPrefix)
+		if err != nil {
+			logger.Fatal("Failed to connect to NATS", zap.Error(err))
+		}
+		// Events are best effort, so losing NATS doesn't make the pod unready
+		monitor.Add("nats", false, natsPublisher.Ping)
+		publisher = natsPublisher
+	case config.EventsKafka:
+		if publisher, err = events.NewKafka(cfg.Events.Kafka.Brokers, cfg.Events.Kafka.Topic); err != nil {
+			logger.Fatal("Failed to connect to Kafka", zap.Error(err))
+		}
+	case config.EventsMemory:
+		publisher = events.NewMemory()
+	}
+	if publisher != nil {
+		handlerOpts = append(handlerOpts, filehandler.WithEvents(publisher, cfg.Events.Source))
+		logger.Info("Event publishing enabled", zap.String("backend", cfg.Events.Backend))
+	}
 
 	// Create file handler with storage client
-	fileHandler := filehandler.NewAzureFileHandler(storageClient)
+	fileHandler := filehandler.NewAzureFileHandler(blobStore, handlerOpts...)
 	if fileHandler == nil {
 		logger.Fatal("Failed to create file handler")
 	}
+	reloader.OnReload("upload size", func(cfg *config.Config) error {
+		fileHandler.SetMaxUploadSize(cfg.Uploads.MaxSize)
+		return nil
+	}, "uploads.maxSize")
 
-	// Mark as ready after successful initialization
+	// Direct uploads that are never completed are deleted once their token
+	// has expired, from every store they can be staged in
+	if directUploads {
+		go fileHandler.RunDirectUploadSweeper(appCtx, 15*time.Minute, func() ([]filehandler.DirectUploader, error) {
+			stores, err := usageStores()
+			seen := map[filehandler.DirectUploader]bool{}
+			var uploaders []filehandler.DirectUploader
+			for _, s := range stores {
+				if u, ok := s.(filehandler.DirectUploader); ok && !seen[u] {
+					seen[u] = true
+					uploaders = append(uploaders, u)
+				}
+			}
+			return uploaders, err
+		})
+	}
+
+	// Mark as ready after successful initialization; readiness also waits
+	// for the first successful storage probe
+	go monitor.Run(appCtx)
 	atomic.StoreInt32(&ready, 1)
+	metrics.SetReady(monitor.Ready())
 	logger.Info("Application initialized and ready to serve traffic")
 
+	// File routes require authentication once a credential source is configured
+	var authenticators []auth.Authenticator
+	if keysFile := cfg.Auth.APIKeysFile; keysFile != "" {
+		keyStore, err := auth.NewAPIKeyStore(keysFile)
+		if err != nil {
+			logger.Fatal("Failed to load API keys", zap.Error(err))
+		}
+		go keyStore.Watch(appCtx, 30*time.Second)
+		reloader.OnReload("API keys", func(*config.Config) error {
+			_, err := keyStore.Reload()
+			return err
+		})
+		authenticators = append(authenticators, keyStore)
+	}
+	if issuer := cfg.Auth.OIDC.Issuer; issuer != "" {
+		jwks := auth.NewRemoteJWKS(issuer, cfg.Auth.OIDC.JWKSURL)
+		if jwksFile := cfg.Auth.OIDC.JWKSFile; jwksFile != "" {
+			if jwks, err = auth.NewFileJWKS(jwksFile); err != nil {
+				logger.Fatal("Failed to load JWKS file", zap.Error(err))
+			}
+		}
+		jwtAuth, err := auth.NewJWTAuthenticator(auth.JWTConfig{
+			Issuer:       issuer,
+			Audience:     cfg.Auth.OIDC.Audience,
+			SubjectClaim: cfg.Auth.OIDC.SubjectClaim,
+			GroupsClaim:  cfg.Auth.OIDC.GroupsClaim,
+			TenantClaim:  cfg.Auth.OIDC.TenantClaim,
+		}, jwks)
+		if err != nil {
+			logger.Fatal("Failed to configure OIDC authentication", zap.Error(err))
+		}
+		authenticators = append(authenticators, jwtAuth)
+		logger.Info("OIDC bearer tokens enabled", zap.String("issuer", issuer))
+	}
+
+	// Native TLS, optionally verifying client certificates. Without it the
+	// ingress is expected to terminate TLS.
+	var tlsReloader *tlsconfig.Reloader
+	clientAuth := tls.NoClientCert
+	if certFile := cfg.TLS.CertFile; certFile != "" {
+		caFile := cfg.TLS.ClientCAFile
+		tlsReloader, err = tlsconfig.NewReloader(certFile, cfg.TLS.KeyFile, caFile)
+		if err != nil {
+			logger.Fatal("Failed to load TLS certificate", zap.Error(err))
+		}
+		if clientAuth, err = tlsconfig.ParseClientAuth(cfg.TLS.ClientAuth, caFile != ""); err != nil {
+			logger.Fatal("Invalid TLS_CLIENT_AUTH", zap.Error(err))
+		}
+		go tlsReloader.Watch(appCtx, 30*time.Second)
+	}
+	if clientAuth != tls.NoClientCert {
+		var rules []auth.ClientCertRule
+		if rulesFile := cfg.Auth.ClientCertRulesFile; rulesFile != "" {
+			if rules, err = auth.LoadClientCertRules(rulesFile); err != nil {
+				logger.Fatal("Failed to load client certificate rules", zap.Error(err))
+			}
+		}
+		certAuth, err := auth.NewClientCertAuthenticator(rules)
+		if err != nil {
+			logger.Fatal("Invalid client certificate rules", zap.Error(err))
+		}
+		authenticators = append(authenticators, certAuth)
+		logger.Info("Client certificate authentication enabled", zap.Int("rules", len(rules)))
+	}
+
+	// Listing and deleting are never anonymous: without any credential
+	// source they answer 401
+	requireAuthn := auth.Middleware(authenticators...)
+	authn := func(c *gin.Context) { c.Next() }
+	if len(authenticators) > 0 {
+		authn = auth.Middleware(authenticators...)
+		logger.Info("Authentication enabled", zap.Int("authenticators", len(authenticators)))
+	} else {
+		logger.Warn("No credentials configured, file routes other than listing and deleting are anonymous")
+	}
+
+	// Per-client request rate limit, keyed by identity or client IP, and
+	// the same limit on failed authentication by client IP, checked before
+	// credentials are. The limiter always exists, letting everything
+	// through while the rate is zero, so that a reload can turn it on.
+	limiter := ratelimit.NewLimiter(cfg.RateLimit.RPS, cfg.RateLimit.Burst)
+	go limiter.Prune(appCtx, time.Minute)
+	reloader.OnReload("rate limit", func(cfg *config.Config) error {
+		limiter.SetLimit(cfg.RateLimit.RPS, cfg.RateLimit.Burst)
+		return nil
+	}, "rateLimit.rps", "rateLimit.burst")
+	limit := limiter.Middleware()
+	guard := limiter.FailedAuthMiddleware()
+	if rps := cfg.RateLimit.RPS; rps > 0 {
+		logger.Info("Rate limiting enabled", zap.Float64("rps", rps), zap.Int("burst", cfg.RateLimit.Burst))
+	}
+
+	// Global cap on concurrent uploads, so a burst can't exhaust memory
+	admission := ratelimit.NewAdmission(cfg.Uploads.MaxInflight, cfg.Uploads.MaxInflightBytes, cfg.Uploads.MaxSize)
+	admit := admission.Middleware()
+	reloader.OnReload("admission", func(cfg *config.Config) error {
+		admission.SetLimits(cfg.Uploads.MaxInflight, cfg.Uploads.MaxInflightBytes, cfg.Uploads.MaxSize)
+		return nil
+	}, "uploads.maxInflight", "uploads.maxInflightBytes", "uploads.maxSize")
+
+	// Audit trail of file operations and authentication failures, kept
+	// apart from the request log
+	audited := func(string) gin.HandlerFunc { return func(c *gin.Context) { c.Next() } }
+	var auditSinks []audit.Sink
+	if auditFile := cfg.Audit.File; auditFile != "" {
+		fileSink, err := audit.OpenFile(auditFile)
+		if err != nil {
+			logger.Fatal("Failed to open audit log", zap.Error(err))
+		}
+		defer fileSink.Close()
+		auditSinks = append(auditSinks, fileSink)
+	}
+	if cfg.Audit.Stdout {
+		auditSinks = append(auditSinks, audit.NewJSONLines(os.Stdout))
+	}
+	if len(auditSinks) > 0 {
+		audited = audit.New(auditSinks...).Middleware
+		logger.Info("Audit log enabled", zap.Int("sinks", len(auditSinks)))
+	}
+
+	// Apply configuration changes without a restart when the config file
+	// changes or on SIGHUP. An invalid configuration is rejected and the
+	// running one kept.
+	go reloader.Watch(appCtx, 30*time.Second)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-appCtx.Done():
+				return
+			case <-hup:
+				logger.Info("SIGHUP received, reloading configuration")
+				if err := reloader.Reload(); err != nil {
+					logger.Error("Failed to reload configuration, keeping the running one", zap.Error(err))
+				}
+			}
+		}
+	}()
+
 	// Set up routes
-	r.POST("/upload", fileHandler.UploadHandler(""))
-	r.GET("/download/:filename", fileHandler.DownloadHandler(""))
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	r.POST("/upload", audited(audit.ActionUpload), guard, auth.AllowPresigned(authn), limit, admit, tenancy, fileHandler.UploadHandler(""))
+	r.GET("/download/*filename", audited(audit.ActionDownload), guard, auth.AllowPresigned(authn), limit, tenancy, fileHandler.DownloadHandler(""))
+	r.POST("/download/bundle", audited(audit.ActionBundle), guard, authn, limit, tenancy, fileHandler.BundleHandler(""))
+	r.GET("/files", audited(audit.ActionList), guard, requireAuthn, limit, tenancy, fileHandler.ListHandler(""))
+	r.DELETE("/files/*filename", audited(audit.ActionDelete), guard, requireAuthn, limit, tenancy, fileHandler.DeleteHandler(""))
+	r.POST("/presign", audited(audit.ActionPresign), guard, authn, limit, tenancy, fileHandler.PresignHandler(cfg.Presign.BaseURL))
+	r.POST("/uploads/direct", audited(audit.ActionDirectUpload), guard, authn, limit, tenancy, fileHandler.DirectUploadHandler(""))
+	r.POST("/uploads/direct/complete", audited(audit.ActionDirectComplete), guard, authn, limit, tenancy, fileHandler.DirectUploadCompleteHandler(""))
+	r.GET("/usage", audited(audit.ActionUsage), guard, authn, limit, tenancy, fileHandler.UsageHandler(""))
+	if dispatcher != nil {
+		r.GET("/webhooks/deliveries", guard, authn, auth.RequireOperator(), dispatcher.ListHandler())
+		r.GET("/webhooks/deliveries/:id", guard, authn, auth.RequireOperator(), dispatcher.GetHandler())
+		r.POST("/webhooks/deliveries/:id/retry", guard, authn, auth.RequireOperator(), dispatcher.RetryHandler())
+	}
 
-	// Set up HTTP server with graceful shutdown
+	// Set up HTTP server with graceful shutdown. There is no ReadTimeout or
+	// WriteTimeout: a large transfer may take hours, so requests are bounded
+	// by their progress instead (see deadline.Middleware).
 	srv := &http.Server{
-		Addr:         ":8080",
-		Handler:      r,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:              cfg.Server.Addr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
 	}
 
-	// --- PROXY protocol support ---
 	ln, err := net.Listen("tcp", srv.Addr)
 	if err != nil {
 		logger.Fatal("Failed to listen", zap.Error(err))
 	}
-	proxyListener := &proxyproto.Listener{Listener: ln}
-	defer proxyListener.Close()
-	// --- end PROXY protocol support ---
+	// Client addresses from PROXY protocol headers, accepted only from the
+	// trusted load balancers
+	if cfg.Proxy.Protocol != config.ProxyProtocolOff {
+		trusted, _ := proxy.ParsePrefixes(cfg.Proxy.TrustedCIDRs)
+		ln = proxy.Listen(ln, proxy.Options{
+			Trusted:       trusted,
+			Required:      cfg.Proxy.Protocol == config.ProxyProtocolRequired,
+			HeaderTimeout: cfg.Proxy.HeaderTimeout,
+		})
+		logger.Info("PROXY protocol enabled",
+			zap.String("mode", cfg.Proxy.Protocol),
+			zap.Strings("trustedCidrs", cfg.Proxy.TrustedCIDRs),
+		)
+	}
+	defer ln.Close()
 
 	// Start server in a goroutine
 	go func() {
-		logger.Info("Starting server on :8080 with PROXY protocol support")
-		if err := srv.Serve(proxyListener); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsReloader != nil {
+			srv.TLSConfig = tlsReloader.Config(clientAuth)
+			logger.Info("Starting TLS server", zap.String("addr", srv.Addr))
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			logger.Info("Starting server", zap.String("addr", srv.Addr))
+			err = srv.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Fatal("Server failed to start", zap.Error(err))
 		}
 	}()
@@ -126,13 +665,14 @@ func main() {
 
 	// Mark as not ready to stop receiving new traffic
 	atomic.StoreInt32(&ready, 0)
+	metrics.SetReady(false)
 
 	// Give load balancer time to detect we're not ready
 	logger.Info("Waiting for load balancer to detect readiness change...")
-	time.Sleep(15 * time.Second)
+	time.Sleep(cfg.Server.DrainDelay)
 
-	// Create context with timeout for shutdown (should be less than terminationGracePeriodSeconds)
-	shutdownTimeout := 30 * time.Second
+	// Create context with timeout for shutdown (drainDelay plus this should be less than terminationGracePeriodSeconds)
+	shutdownTimeout := cfg.Server.ShutdownTimeout
 	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
@@ -142,6 +682,18 @@ func main() {
 	} else {
 		logger.Info("Server shutdown completed gracefully")
 	}
+
+	// Stop background work; undelivered webhooks go to the dead-letter store
+	stopApp()
+	background.Wait()
+	if publisher != nil {
+		if err := publisher.Close(); err != nil {
+			logger.Error("Failed to flush events", zap.Error(err))
+		}
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush traces", zap.Error(err))
+	}
 }
 
 // GinZapMiddleware returns a gin middleware that logs requests using zap
@@ -162,6 +714,7 @@ func GinZapMiddleware(logger *zap.Logger) gin.HandlerFunc {
 			zap.Duration("latency", latency),
 			zap.String("client_ip", c.ClientIP()),
 			zap.String("user_agent", c.Request.UserAgent()),
+			zap.String("request_id", requestid.Get(c)),
 		)
 	}
 }
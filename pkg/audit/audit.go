@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionUpload         = "upload"
+	ActionDownload       = "download"
+	ActionBundle         = "bundle"
+	ActionDelete         = "delete"
+	ActionList           = "list"
+	ActionPresign        = "presign"
+	ActionDirectUpload   = "direct-upload"
+	ActionDirectComplete = "direct-upload-complete"
+	ActionUsage          = "usage"
+)
+
+// Outcomes of an audited request.
+const (
+	OutcomeSuccess = "success"
+	// OutcomeUnauthenticated is an authentication failure (401).
+	OutcomeUnauthenticated = "unauthenticated"
+	// OutcomeDenied is an authorization failure (403).
+	OutcomeDenied = "denied"
+	// OutcomeRejected covers other client errors, e.g. quota or validation.
+	OutcomeRejected = "rejected"
+	// OutcomeCancelled is a request abandoned by the client (499).
+	OutcomeCancelled = "cancelled"
+	OutcomeFailure   = "failure"
+)
+
+// statusClientClosedRequest is recorded by handlers for requests the
+// client abandoned.
+const statusClientClosedRequest = 499
+
+// Event is one audit record.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Outcome    string    `json:"outcome"`
+	Status     int       `json:"status"`
+	Actor      string    `json:"actor,omitempty"`
+	AuthMethod string    `json:"authMethod,omitempty"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Object     string    `json:"object,omitempty"`
+	Objects    []string  `json:"objects,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Checksum   string    `json:"checksum,omitempty"`
+	// ClientIP is the client's address as given by a trusted PROXY
+	// protocol header or proxy, and otherwise the connection's. ForwardedFor
+	// is the X-Forwarded-For header as received and is not trustworthy on
+	// its own.
+	ClientIP     string `json:"clientIp"`
+	ForwardedFor string `json:"forwardedFor,omitempty"`
+	UserAgent    string `json:"userAgent,omitempty"`
+	RequestID    string `json:"requestId,omitempty"`
+}
+
+// Logger writes audit events to one or more sinks. It is separate from the
+// request log so that it can be shipped and retained on its own terms.
+type Logger struct {
+	sinks  []Sink
+	logger *zap.Logger
+}
+
+// New returns a logger writing every event to all sinks.
+func New(sinks ...Sink) *Logger {
+	return &Logger{sinks: sinks, logger: zap.L().Named("audit")}
+}
+
+// Log writes e to every sink. Sink errors are logged, not returned: the
+// request has already been served by the time it is audited.
+func (l *Logger) Log(e *Event) {
+	for _, s := range l.sinks {
+		if err := s.Write(e); err != nil {
+			l.logger.Error("Failed to write audit event", zap.String("action", e.Action), zap.Error(err))
+		}
+	}
+}
+
+const eventKey = "audit.event"
+
+// Middleware records one event for every request to a route once it has
+// been handled, including requests turned away by authentication. It must
+// run before authentication. Handlers add what they know with Object,
+// Objects and Transfer.
+func (l *Logger) Middleware(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		e := &Event{
+			Action:    action,
+			Object:    strings.TrimPrefix(c.Param("filename"), "/"),
+			ClientIP:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			RequestID: requestid.Get(c),
+		}
+		c.Set(eventKey, e)
+
+		c.Next()
+
+		e.Time = time.Now().UTC()
+		e.Status = c.Writer.Status()
+		e.Outcome = outcome(e.Status)
+		if xff := c.GetHeader("X-Forwarded-For"); xff != "" {
+			e.ForwardedFor = xff
+		}
+		if id, ok := auth.FromContext(c); ok {
+			e.Actor, e.AuthMethod = id.ID, id.Method
+		} else if presign.IsPresigned(c.Request.URL.Query()) {
+			e.AuthMethod = "presigned"
+		}
+		e.Tenant = tenant.Name(c)
+		l.Log(e)
+	}
+}
+
+func outcome(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return OutcomeUnauthenticated
+	case status == http.StatusForbidden:
+		return OutcomeDenied
+	case status == statusClientClosedRequest:
+		return OutcomeCancelled
+	case status >= 500:
+		return OutcomeFailure
+	case status >= 400:
+		return OutcomeRejected
+	default:
+		return OutcomeSuccess
+	}
+}
+
+func event(c *gin.Context) *Event {
+	v, ok := c.Get(eventKey)
+	if !ok {
+		return nil
+	}
+	e, _ := v.(*Event)
+	return e
+}
+
+// Object records the object a request acts on.
+func Object(c *gin.Context, name string) {
+	if e := event(c); e != nil {
+		e.Object = name
+	}
+}
+
+// Objects records the objects of a request that acts on several.
+func Objects(c *gin.Context, names []string) {
+	if e := event(c); e != nil {
+		e.Objects = names
+	}
+}
+
+// Transfer records the size and, if known, checksum of the data moved.
+func Transfer(c *gin.Context, size int64, checksum string) {
+	if e := event(c); e != nil {
+		e.Size, e.Checksum = size, checksum
+	}
+}
@@ -0,0 +1,138 @@
+package audit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memSink keeps events in memory.
+type memSink struct {
+	mu     sync.Mutex
+	events []*audit.Event
+}
+
+func (s *memSink) Write(e *audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestMiddleware_RecordsOutcomes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sink := &memSink{}
+	logger := audit.New(sink)
+
+	router := gin.New()
+	require.NoError(t, router.SetTrustedProxies(nil))
+	router.Use(requestid.Middleware())
+	authn := func(c *gin.Context) {
+		switch c.GetHeader("X-Test-User") {
+		case "":
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		case "mallory":
+			auth.SetIdentity(c, &auth.Identity{ID: "mallory", Method: "apikey"})
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		default:
+			auth.SetIdentity(c, &auth.Identity{ID: c.GetHeader("X-Test-User"), Method: "oidc"})
+		}
+	}
+	router.GET("/download/*filename", logger.Middleware(audit.ActionDownload), authn, func(c *gin.Context) {
+		audit.Object(c, "reports/q1.csv")
+		audit.Transfer(c, 42, "sha256:abc")
+		c.String(http.StatusOK, "ok")
+	})
+
+	for _, user := range []string{"", "mallory", "alice"} {
+		req := httptest.NewRequest("GET", "/download/reports/q1.csv?x=1", nil)
+		req.RemoteAddr = "203.0.113.7:4711"
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		req.Header.Set("X-Request-ID", "req-"+user)
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.Len(t, sink.events, 3)
+	unauth, denied, ok := sink.events[0], sink.events[1], sink.events[2]
+	assert.Equal(t, audit.OutcomeUnauthenticated, unauth.Outcome)
+	assert.Equal(t, "reports/q1.csv", unauth.Object, "object is known even when authentication fails")
+	assert.Empty(t, unauth.Actor)
+	assert.Equal(t, "203.0.113.7", unauth.ClientIP)
+	assert.Equal(t, "10.0.0.1", unauth.ForwardedFor)
+
+	assert.Equal(t, audit.OutcomeDenied, denied.Outcome)
+	assert.Equal(t, "mallory", denied.Actor)
+
+	assert.Equal(t, audit.OutcomeSuccess, ok.Outcome)
+	assert.Equal(t, http.StatusOK, ok.Status)
+	assert.Equal(t, "alice", ok.Actor)
+	assert.Equal(t, "oidc", ok.AuthMethod)
+	assert.Equal(t, audit.ActionDownload, ok.Action)
+	assert.Equal(t, int64(42), ok.Size)
+	assert.Equal(t, "sha256:abc", ok.Checksum)
+	assert.Equal(t, "req-alice", ok.RequestID)
+	assert.False(t, ok.Time.IsZero())
+}
+
+func TestMiddleware_PresignedAndFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sink := &memSink{}
+	router := gin.New()
+	router.POST("/upload", audit.New(sink).Middleware(audit.ActionUpload), func(c *gin.Context) {
+		status, _ := strconv.Atoi(c.DefaultQuery("status", "200"))
+		c.Status(status)
+	})
+
+	for _, target := range []string{"/upload?op=upload&sig=x", "/upload?status=507", "/upload?status=400", "/upload?status=499"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", target, nil))
+	}
+
+	require.Len(t, sink.events, 4)
+	assert.Equal(t, "presigned", sink.events[0].AuthMethod)
+	assert.Equal(t, audit.OutcomeFailure, sink.events[1].Outcome)
+	assert.Equal(t, audit.OutcomeRejected, sink.events[2].Outcome)
+	assert.Equal(t, audit.OutcomeCancelled, sink.events[3].Outcome)
+}
+
+func TestMiddleware_ClientIPFromTrustedProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sink := &memSink{}
+	router := gin.New()
+	require.NoError(t, router.SetTrustedProxies([]string{"10.0.0.0/8"}))
+	router.GET("/download", audit.New(sink).Middleware(audit.ActionDownload), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, remote := range []string{"10.1.2.3:4711", "203.0.113.9:4711"} {
+		req := httptest.NewRequest("GET", "/download", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.Len(t, sink.events, 2)
+	assert.Equal(t, "203.0.113.7", sink.events[0].ClientIP)
+	assert.Equal(t, "203.0.113.9", sink.events[1].ClientIP, "untrusted sources can't claim an address")
+}
+
+func TestAnnotations_WithoutMiddleware(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.NotPanics(t, func() {
+		audit.Object(c, "a")
+		audit.Objects(c, []string{"a"})
+		audit.Transfer(c, 1, "")
+	})
+}
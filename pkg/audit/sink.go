@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sink stores audit events. Implementations must be safe for concurrent
+// use and should not reorder events.
+type Sink interface {
+	Write(e *Event) error
+}
+
+// JSONLines writes one JSON object per line to an io.Writer.
+type JSONLines struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLines returns a sink writing to w, e.g. os.Stdout.
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{w: w}
+}
+
+// Write implements Sink.
+func (s *JSONLines) Write(e *Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A single write per event keeps lines whole in an O_APPEND file.
+	_, err = s.w.Write(line)
+	return err
+}
+
+// FileSink appends JSON lines to a file.
+type FileSink struct {
+	*JSONLines
+	f *os.File
+}
+
+// OpenFile opens path for appending, creating it if needed. Existing
+// content is never truncated.
+func OpenFile(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{JSONLines: NewJSONLines(f), f: f}, nil
+}
+
+// Close flushes the file to disk and closes it.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
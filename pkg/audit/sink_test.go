@@ -0,0 +1,68 @@
+package audit_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"stream-upload-file/pkg/audit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	sink := audit.NewJSONLines(&buf)
+	require.NoError(t, sink.Write(&audit.Event{Action: audit.ActionDelete, Object: "a.txt", Outcome: audit.OutcomeSuccess}))
+	require.NoError(t, sink.Write(&audit.Event{Action: audit.ActionUpload, Object: "b.txt", Outcome: audit.OutcomeDenied}))
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var e map[string]any
+	require.NoError(t, json.Unmarshal(lines[1], &e))
+	assert.Equal(t, "upload", e["action"])
+	assert.Equal(t, "b.txt", e["object"])
+	assert.Equal(t, "denied", e["outcome"])
+	assert.NotContains(t, e, "size")
+}
+
+func TestFileSink_Appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for _, name := range []string{"first", "second"} {
+		sink, err := audit.OpenFile(path)
+		require.NoError(t, err)
+		require.NoError(t, sink.Write(&audit.Event{Action: audit.ActionUpload, Object: name}))
+		require.NoError(t, sink.Close())
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var objects []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e audit.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		objects = append(objects, e.Object)
+	}
+	assert.Equal(t, []string{"first", "second"}, objects)
+}
+
+type failingSink struct{ calls int }
+
+func (s *failingSink) Write(*audit.Event) error {
+	s.calls++
+	return errors.New("disk full")
+}
+
+func TestLogger_KeepsGoingAfterSinkError(t *testing.T) {
+	failing, mem := &failingSink{}, &memSink{}
+	audit.New(failing, mem).Log(&audit.Event{Action: audit.ActionDelete})
+	assert.Equal(t, 1, failing.calls)
+	assert.Len(t, mem.events, 1)
+}
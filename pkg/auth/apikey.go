@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// APIKeyHeader carries an API key. "Authorization: ApiKey <key>" is accepted too.
+	APIKeyHeader = "X-API-Key"
+
+	apiKeyHashPrefix = "sha256:"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey is one entry of the API key file. Only the hash of the key is stored.
+type APIKey struct {
+	ID         string   `json:"id"`
+	Hash       string   `json:"hash"`
+	Scopes     []string `json:"scopes"`
+	PathPrefix string   `json:"pathPrefix,omitempty"`
+	Tenant     string   `json:"tenant,omitempty"`
+	Disabled   bool     `json:"disabled,omitempty"`
+}
+
+type apiKeyFile struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// HashAPIKey returns the at-rest representation of an API key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return apiKeyHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore authenticates requests against a set of hashed API keys loaded
+// from a file. The file is re-read when its content changes, so keys can be
+// added, disabled or rotated without restarting the pod.
+type APIKeyStore struct {
+	path   string
+	logger *zap.Logger
+
+	keys    atomic.Pointer[map[[sha256.Size]byte]APIKey]
+	mu      sync.Mutex
+	lastRaw []byte
+}
+
+// NewAPIKeyStore loads the key file at path. The file is JSON:
+//
+//	{"keys": [{"id": "ci", "hash": "sha256:<hex>", "scopes": ["upload"], "pathPrefix": "ci-"}]}
+func NewAPIKeyStore(path string) (*APIKeyStore, error) {
+	s := &APIKeyStore{
+		path:   path,
+		logger: zap.L().Named("api-keys"),
+	}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the key file. It reports whether the key set changed. On
+// error the previously loaded keys stay in effect.
+func (s *APIKeyStore) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	if s.lastRaw != nil && bytes.Equal(raw, s.lastRaw) {
+		return false, nil
+	}
+	keys, err := parseAPIKeys(raw)
+	if err != nil {
+		return false, fmt.Errorf("api key file %s: %w", s.path, err)
+	}
+	s.keys.Store(&keys)
+	s.lastRaw = raw
+	s.logger.Info("Loaded API keys", zap.Int("count", len(keys)))
+	return true, nil
+}
+
+// Watch polls the key file every interval until ctx is done. Mounted
+// Kubernetes secrets are updated by swapping a symlink, which polling picks
+// up reliably.
+func (s *APIKeyStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Reload(); err != nil {
+				s.logger.Error("Failed to reload API keys, keeping previous set", zap.Error(err))
+			}
+		}
+	}
+}
+
+func parseAPIKeys(raw []byte) (map[[sha256.Size]byte]APIKey, error) {
+	var f apiKeyFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, err
+	}
+	keys := make(map[[sha256.Size]byte]APIKey, len(f.Keys))
+	ids := make(map[string]bool, len(f.Keys))
+	for i, k := range f.Keys {
+		if k.ID == "" {
+			return nil, fmt.Errorf("key %d has no id", i)
+		}
+		if ids[k.ID] {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		ids[k.ID] = true
+		if len(k.Scopes) == 0 {
+			return nil, fmt.Errorf("key %q has no scopes", k.ID)
+		}
+		if err := validateScopes(k.Scopes); err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.ID, err)
+		}
+		hexHash, ok := strings.CutPrefix(k.Hash, apiKeyHashPrefix)
+		if !ok {
+			return nil, fmt.Errorf("key %q hash must start with %q", k.ID, apiKeyHashPrefix)
+		}
+		// Checked first: hex.Decode would write past sum for a longer hash.
+		var sum [sha256.Size]byte
+		if len(hexHash) != hex.EncodedLen(sha256.Size) {
+			return nil, fmt.Errorf("key %q has a malformed hash", k.ID)
+		}
+		if _, err := hex.Decode(sum[:], []byte(hexHash)); err != nil {
+			return nil, fmt.Errorf("key %q has a malformed hash", k.ID)
+		}
+		if k.Disabled {
+			continue
+		}
+		keys[sum] = k
+	}
+	return keys, nil
+}
+
+// Authenticate implements Authenticator.
+func (s *APIKeyStore) Authenticate(r *http.Request) (*Identity, error) {
+	presented := r.Header.Get(APIKeyHeader)
+	if presented == "" {
+		if v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+			presented = strings.TrimSpace(v)
+		}
+	}
+	if presented == "" {
+		return nil, nil
+	}
+
+	sum := sha256.Sum256([]byte(presented))
+	// Keys are looked up by their SHA-256, so the comparison never touches
+	// the plaintext and leaks nothing useful about stored hashes.
+	key, ok := (*s.keys.Load())[sum]
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	return &Identity{
+		ID:         key.ID,
+		Method:     "apikey",
+		Scopes:     key.Scopes,
+		PathPrefix: key.PathPrefix,
+		Tenant:     key.Tenant,
+	}, nil
+}
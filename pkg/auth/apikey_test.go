@@ -0,0 +1,111 @@
+package auth_test
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/auth"
+)
+
+func writeKeyFile(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func keyFile(entries ...string) string {
+	s := `{"keys":[`
+	for i, e := range entries {
+		if i > 0 {
+			s += ","
+		}
+		s += e
+	}
+	return s + "]}"
+}
+
+func TestAPIKeyStore_Authenticate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeyFile(t, path, keyFile(
+		`{"id":"ci","hash":"`+auth.HashAPIKey("secret-1")+`","scopes":["upload","download"],"pathPrefix":"ci-"}`,
+		`{"id":"old","hash":"`+auth.HashAPIKey("secret-2")+`","scopes":["list"],"disabled":true}`,
+	))
+	store, err := auth.NewAPIKeyStore(path)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(auth.APIKeyHeader, "secret-1")
+	id, err := store.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "ci", id.ID)
+	assert.True(t, id.Allows(auth.ScopeUpload, "ci-report.csv"))
+	assert.False(t, id.Allows(auth.ScopeUpload, "other.csv"))
+	assert.False(t, id.Allows(auth.ScopeDelete, "ci-report.csv"))
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "ApiKey secret-1")
+	id, err = store.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "ci", id.ID)
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(auth.APIKeyHeader, "secret-2")
+	_, err = store.Authenticate(req)
+	assert.ErrorIs(t, err, auth.ErrInvalidAPIKey)
+
+	id, err = store.Authenticate(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, id)
+}
+
+func TestAPIKeyStore_Rotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeyFile(t, path, keyFile(`{"id":"a","hash":"`+auth.HashAPIKey("old-key")+`","scopes":["download"]}`))
+	store, err := auth.NewAPIKeyStore(path)
+	require.NoError(t, err)
+
+	writeKeyFile(t, path, keyFile(`{"id":"a","hash":"`+auth.HashAPIKey("new-key")+`","scopes":["download"]}`))
+	changed, err := store.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(auth.APIKeyHeader, "old-key")
+	_, err = store.Authenticate(req)
+	assert.Error(t, err)
+	req.Header.Set(auth.APIKeyHeader, "new-key")
+	_, err = store.Authenticate(req)
+	assert.NoError(t, err)
+
+	// A broken file is rejected and the last good key set stays active.
+	writeKeyFile(t, path, `{"keys":[{"id":"a","hash":"md5:abc","scopes":["download"]}]}`)
+	_, err = store.Reload()
+	assert.Error(t, err)
+	_, err = store.Authenticate(req)
+	assert.NoError(t, err)
+}
+
+func TestNewAPIKeyStore_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"no-scopes":     keyFile(`{"id":"a","hash":"` + auth.HashAPIKey("k") + `","scopes":[]}`),
+		"bad-scope":     keyFile(`{"id":"a","hash":"` + auth.HashAPIKey("k") + `","scopes":["admin"]}`),
+		"duplicate-ids": keyFile(`{"id":"a","hash":"`+auth.HashAPIKey("k")+`","scopes":["list"]}`, `{"id":"a","hash":"`+auth.HashAPIKey("j")+`","scopes":["list"]}`),
+		"not-json":      `keys: []`,
+		"long-hash":     keyFile(`{"id":"a","hash":"sha256:` + strings.Repeat("ab", 40) + `","scopes":["list"]}`),
+		"short-hash":    keyFile(`{"id":"a","hash":"sha256:` + strings.Repeat("ab", 16) + `","scopes":["list"]}`),
+		"non-hex-hash":  keyFile(`{"id":"a","hash":"sha256:` + strings.Repeat("zz", 32) + `","scopes":["list"]}`),
+	} {
+		path := filepath.Join(dir, name)
+		writeKeyFile(t, path, content)
+		_, err := auth.NewAPIKeyStore(path)
+		assert.Error(t, err, name)
+	}
+	_, err := auth.NewAPIKeyStore(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
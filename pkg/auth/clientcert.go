@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+)
+
+var ErrCertificateNotAllowed = errors.New("client certificate is not allowed")
+
+// ClientCertRule grants permissions to client certificates with a matching
+// principal. Principals are the certificate's URI SANs (e.g. SPIFFE IDs),
+// DNS SANs, email SANs and "CN=<common name>".
+type ClientCertRule struct {
+	// Match is a path.Match pattern, e.g. "spiffe://cluster.local/ns/batch/sa/*".
+	Match string `json:"match"`
+	// ID overrides the identity ID. Defaults to the matched principal.
+	ID         string   `json:"id,omitempty"`
+	Scopes     []string `json:"scopes"`
+	PathPrefix string   `json:"pathPrefix,omitempty"`
+	Tenant     string   `json:"tenant,omitempty"`
+}
+
+// ClientCertAuthenticator identifies callers by the client certificate
+// they presented during the TLS handshake. Only certificates verified
+// against the configured client CAs are considered.
+type ClientCertAuthenticator struct {
+	rules []ClientCertRule
+}
+
+// NewClientCertAuthenticator returns an authenticator that applies the
+// first matching rule. Without rules every verified certificate is accepted
+// with unrestricted scopes; with rules, certificates matching none of them
+// are rejected.
+func NewClientCertAuthenticator(rules []ClientCertRule) (*ClientCertAuthenticator, error) {
+	for i, rule := range rules {
+		if _, err := path.Match(rule.Match, ""); err != nil || rule.Match == "" {
+			return nil, fmt.Errorf("client cert rule %d: invalid match pattern %q", i, rule.Match)
+		}
+		if len(rule.Scopes) == 0 {
+			return nil, fmt.Errorf("client cert rule %q has no scopes", rule.Match)
+		}
+		if err := validateScopes(rule.Scopes); err != nil {
+			return nil, fmt.Errorf("client cert rule %q: %w", rule.Match, err)
+		}
+	}
+	return &ClientCertAuthenticator{rules: rules}, nil
+}
+
+// LoadClientCertRules reads rules from a JSON file of the form
+//
+//	{"rules": [{"match": "spiffe://cluster.local/ns/batch/sa/*", "scopes": ["upload"]}]}
+func LoadClientCertRules(file string) ([]ClientCertRule, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Rules []ClientCertRule `json:"rules"`
+	}
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("client cert rules %s: %w", file, err)
+	}
+	return f.Rules, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *ClientCertAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	principals := CertificatePrincipals(r.TLS.VerifiedChains[0][0])
+	if len(principals) == 0 {
+		return nil, ErrCertificateNotAllowed
+	}
+
+	if len(a.rules) == 0 {
+		return &Identity{ID: principals[0], Method: "mtls"}, nil
+	}
+	for _, rule := range a.rules {
+		for _, p := range principals {
+			if ok, _ := path.Match(rule.Match, p); !ok {
+				continue
+			}
+			id := rule.ID
+			if id == "" {
+				id = p
+			}
+			return &Identity{
+				ID:         id,
+				Method:     "mtls",
+				Scopes:     rule.Scopes,
+				PathPrefix: rule.PathPrefix,
+				Tenant:     rule.Tenant,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrCertificateNotAllowed, principals[0])
+}
+
+// CertificatePrincipals lists the names a certificate asserts, most
+// specific first.
+func CertificatePrincipals(cert *x509.Certificate) []string {
+	var out []string
+	for _, u := range cert.URIs {
+		out = append(out, u.String())
+	}
+	out = append(out, cert.DNSNames...)
+	out = append(out, cert.EmailAddresses...)
+	if cert.Subject.CommonName != "" {
+		out = append(out, "CN="+cert.Subject.CommonName)
+	}
+	return out
+}
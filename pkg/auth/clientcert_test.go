@@ -0,0 +1,94 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/auth"
+)
+
+func requestWithCert(cert *x509.Certificate, verified bool) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return req
+}
+
+func workloadCert() *x509.Certificate {
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/batch/sa/exporter")
+	return &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "exporter"},
+		URIs:     []*url.URL{spiffe},
+		DNSNames: []string{"exporter.batch.svc"},
+	}
+}
+
+func TestClientCertAuthenticator_Rules(t *testing.T) {
+	a, err := auth.NewClientCertAuthenticator([]auth.ClientCertRule{
+		{Match: "spiffe://cluster.local/ns/batch/sa/*", Scopes: []string{auth.ScopeUpload}, PathPrefix: "batch-"},
+		{Match: "CN=reporting", ID: "reporting", Scopes: []string{auth.ScopeDownload}},
+	})
+	require.NoError(t, err)
+
+	id, err := a.Authenticate(requestWithCert(workloadCert(), true))
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://cluster.local/ns/batch/sa/exporter", id.ID)
+	assert.Equal(t, "mtls", id.Method)
+	assert.True(t, id.Allows(auth.ScopeUpload, "batch-1.csv"))
+	assert.False(t, id.Allows(auth.ScopeDownload, "batch-1.csv"))
+
+	id, err = a.Authenticate(requestWithCert(&x509.Certificate{Subject: pkix.Name{CommonName: "reporting"}}, true))
+	require.NoError(t, err)
+	assert.Equal(t, "reporting", id.ID)
+
+	_, err = a.Authenticate(requestWithCert(&x509.Certificate{Subject: pkix.Name{CommonName: "someone-else"}}, true))
+	assert.ErrorIs(t, err, auth.ErrCertificateNotAllowed)
+}
+
+func TestClientCertAuthenticator_NoRules(t *testing.T) {
+	a, err := auth.NewClientCertAuthenticator(nil)
+	require.NoError(t, err)
+
+	id, err := a.Authenticate(requestWithCert(workloadCert(), true))
+	require.NoError(t, err)
+	assert.Equal(t, "spiffe://cluster.local/ns/batch/sa/exporter", id.ID)
+	assert.Nil(t, id.Scopes)
+
+	// Unverified certificates and plain HTTP carry no identity.
+	id, err = a.Authenticate(requestWithCert(workloadCert(), false))
+	assert.NoError(t, err)
+	assert.Nil(t, id)
+	id, err = a.Authenticate(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, id)
+}
+
+func TestClientCertRules_Invalid(t *testing.T) {
+	for _, rule := range []auth.ClientCertRule{
+		{Match: "", Scopes: []string{auth.ScopeUpload}},
+		{Match: "CN=[", Scopes: []string{auth.ScopeUpload}},
+		{Match: "CN=a"},
+		{Match: "CN=a", Scopes: []string{"admin"}},
+	} {
+		_, err := auth.NewClientCertAuthenticator([]auth.ClientCertRule{rule})
+		assert.Error(t, err, rule.Match)
+	}
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules":[{"match":"CN=a","scopes":["list"]}]}`), 0o600))
+	rules, err := auth.LoadClientCertRules(path)
+	require.NoError(t, err)
+	assert.Equal(t, []auth.ClientCertRule{{Match: "CN=a", Scopes: []string{"list"}}}, rules)
+}
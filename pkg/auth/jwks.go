@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// jwksMaxAge is how long fetched keys are used before being refreshed.
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh limits refreshes triggered by unknown key IDs, so
+	// tokens with made-up kids can't be used to hammer the issuer.
+	jwksMinRefresh = time.Minute
+
+	maxJWKSSize = 1 << 20
+)
+
+var ErrUnknownSigningKey = errors.New("token signed with an unknown key")
+
+// JWKS caches the public keys an issuer signs tokens with. Keys are fetched
+// from the issuer's JWKS endpoint, or read from a local file, and refreshed
+// once they are older than an hour or a token names a key ID that isn't
+// known yet.
+type JWKS struct {
+	issuer string
+	url    string
+	file   string
+	client *http.Client
+	logger *zap.Logger
+
+	mu          sync.RWMutex
+	keys        map[string]any
+	fetched     time.Time
+	lastAttempt time.Time
+	refreshMu   sync.Mutex
+}
+
+// NewRemoteJWKS returns a key set fetched from jwksURL. When jwksURL is
+// empty it is discovered from the issuer's OpenID configuration on first use.
+func NewRemoteJWKS(issuer, jwksURL string) *JWKS {
+	return &JWKS{
+		issuer: issuer,
+		url:    jwksURL,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: zap.L().Named("jwks"),
+	}
+}
+
+// NewFileJWKS returns a key set read from a local JWKS document, for tests
+// and air-gapped deployments. The file is re-read on the same schedule as
+// a remote key set.
+func NewFileJWKS(path string) (*JWKS, error) {
+	j := &JWKS{file: path, logger: zap.L().Named("jwks")}
+	if err := j.refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Key returns the public key with the given key ID.
+func (j *JWKS) Key(ctx context.Context, kid string) (any, error) {
+	j.mu.RLock()
+	key, ok := j.keys[kid]
+	stale := time.Since(j.fetched) > jwksMaxAge
+	j.mu.RUnlock()
+	if ok && !stale {
+		return key, nil
+	}
+
+	if err := j.refresh(ctx); err != nil {
+		if ok {
+			// The issuer being briefly unreachable shouldn't lock everyone out.
+			j.logger.Warn("Failed to refresh JWKS, using cached keys", zap.Error(err))
+			return key, nil
+		}
+		return nil, err
+	}
+	j.mu.RLock()
+	key, ok = j.keys[kid]
+	j.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: kid %q", ErrUnknownSigningKey, kid)
+	}
+	return key, nil
+}
+
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+
+	j.mu.RLock()
+	recent := time.Since(j.lastAttempt) < jwksMinRefresh
+	j.mu.RUnlock()
+	if recent {
+		return nil
+	}
+	j.mu.Lock()
+	j.lastAttempt = time.Now()
+	j.mu.Unlock()
+
+	raw, err := j.load(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(raw)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.fetched = time.Now()
+	j.mu.Unlock()
+	j.logger.Info("Loaded JWKS", zap.Int("keys", len(keys)))
+	return nil
+}
+
+func (j *JWKS) load(ctx context.Context) ([]byte, error) {
+	if j.file != "" {
+		return os.ReadFile(j.file)
+	}
+	if j.url == "" {
+		u, err := j.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		j.url = u
+	}
+	return j.get(ctx, j.url)
+}
+
+// discover looks up the JWKS endpoint in the issuer's OpenID configuration.
+func (j *JWKS) discover(ctx context.Context) (string, error) {
+	raw, err := j.get(ctx, strings.TrimSuffix(j.issuer, "/")+"/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	var cfg struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return "", fmt.Errorf("openid configuration: %w", err)
+	}
+	if cfg.JWKSURI == "" {
+		return "", errors.New("openid configuration has no jwks_uri")
+	}
+	return cfg.JWKSURI, nil
+}
+
+func (j *JWKS) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes the RSA and EC signing keys of a JWKS document. Keys of
+// other types or meant for encryption are skipped.
+func parseJWKS(raw []byte) (map[string]any, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]any, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var (
+			key any
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k)
+		case "EC":
+			key, err = ecKey(k)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(k jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil || len(n) == 0 {
+		return nil, errors.New("malformed modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("malformed exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func ecKey(k jsonWebKey) (*ecdsa.PublicKey, error) {
+	var (
+		curve elliptic.Curve
+		check ecdh.Curve
+	)
+	switch k.Crv {
+	case "P-256":
+		curve, check = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, check = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, check = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, errX := base64.RawURLEncoding.DecodeString(k.X)
+	y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+	size := (curve.Params().BitSize + 7) / 8
+	if errX != nil || errY != nil || len(x) != size || len(y) != size {
+		return nil, errors.New("malformed point")
+	}
+	// ecdh rejects points that are not on the curve.
+	if _, err := check.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/auth"
+)
+
+func TestRemoteJWKS_Discovery(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var fetches atomic.Int32
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issuer":"` + srv.URL + `","jwks_uri":"` + srv.URL + `/keys"}`))
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write(jwksDocument(t, rsaJWK("k1", &key.PublicKey)))
+	})
+
+	jwks := auth.NewRemoteJWKS(srv.URL, "")
+	got, err := jwks.Key(context.Background(), "k1")
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(got))
+
+	// Cached keys are served without another fetch, and an unknown kid
+	// right after a refresh does not trigger a new one.
+	_, err = jwks.Key(context.Background(), "k1")
+	require.NoError(t, err)
+	_, err = jwks.Key(context.Background(), "k9")
+	assert.ErrorIs(t, err, auth.ErrUnknownSigningKey)
+	assert.Equal(t, int32(1), fetches.Load())
+}
+
+func TestFileJWKS_KeyTypes(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecJWK := map[string]string{
+		"kty": "EC",
+		"kid": "ec",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+	}
+	enc := rsaJWK("enc", &rsaKey.PublicKey)
+	enc["use"] = "enc"
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksDocument(t, ecJWK, enc, map[string]string{"kty": "oct", "kid": "sym"}), 0o600))
+	jwks, err := auth.NewFileJWKS(path)
+	require.NoError(t, err)
+
+	got, err := jwks.Key(context.Background(), "ec")
+	require.NoError(t, err)
+	assert.True(t, ecKey.PublicKey.Equal(got))
+	_, err = jwks.Key(context.Background(), "enc")
+	assert.Error(t, err)
+	_, err = jwks.Key(context.Background(), "sym")
+	assert.Error(t, err)
+}
+
+func TestFileJWKS_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"empty":       `{"keys":[]}`,
+		"off-curve":   `{"keys":[{"kty":"EC","kid":"a","crv":"P-256","x":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `","y":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `"}]}`,
+		"bad-modulus": `{"keys":[{"kty":"RSA","kid":"a","n":"!!","e":"AQAB"}]}`,
+		"not-json":    `keys`,
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := auth.NewFileJWKS(path)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidToken = errors.New("invalid bearer token")
+
+// JWTConfig describes which bearer tokens are accepted.
+type JWTConfig struct {
+	// Issuer must match the token's iss claim exactly.
+	Issuer string
+	// Audience must be one of the token's aud values, usually the client
+	// or application ID this service is registered under.
+	Audience string
+	// SubjectClaim names the claim used as the identity ID. Defaults to
+	// "sub"; Entra ID deployments may prefer the stable "oid".
+	SubjectClaim string
+	// GroupsClaim names the claim holding the caller's groups or roles.
+	// Defaults to "groups".
+	GroupsClaim string
+	// TenantClaim, when set, names the claim binding the caller to a tenant.
+	TenantClaim string
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// JWTAuthenticator validates OIDC bearer tokens issued by Entra ID,
+// Keycloak or any other provider that publishes a JWKS.
+type JWTAuthenticator struct {
+	cfg    JWTConfig
+	keys   *JWKS
+	parser *jwt.Parser
+}
+
+// NewJWTAuthenticator validates tokens against cfg using keys from jwks.
+func NewJWTAuthenticator(cfg JWTConfig, jwks *JWKS) (*JWTAuthenticator, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("jwt: issuer and audience are required")
+	}
+	if cfg.SubjectClaim == "" {
+		cfg.SubjectClaim = "sub"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = time.Minute
+	}
+	return &JWTAuthenticator{
+		cfg:  cfg,
+		keys: jwks,
+		parser: jwt.NewParser(
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(cfg.Leeway),
+			// Pinning asymmetric algorithms rules out "none" and HMAC
+			// tokens signed with a public key as the secret.
+			jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		),
+	}, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	scheme, raw, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(strings.TrimSpace(raw), claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.Key(r.Context(), kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	subject, _ := claims[a.cfg.SubjectClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, a.cfg.SubjectClaim)
+	}
+	id := &Identity{
+		ID:     subject,
+		Method: "jwt",
+		Groups: stringsClaim(claims[a.cfg.GroupsClaim]),
+	}
+	if a.cfg.TenantClaim != "" {
+		id.Tenant, _ = claims[a.cfg.TenantClaim].(string)
+	}
+	return id, nil
+}
+
+// stringsClaim accepts a claim given either as a list or a single string.
+func stringsClaim(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/auth"
+)
+
+const (
+	testIssuer   = "https://login.example.com/tenant"
+	testAudience = "api://file-service"
+)
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func jwksDocument(t *testing.T, keys ...map[string]string) []byte {
+	t.Helper()
+	raw, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	return raw
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = kid
+	s, err := tok.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":    testIssuer,
+		"aud":    []string{testAudience},
+		"sub":    "user-123",
+		"groups": []string{"engineering", "uploaders"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func newTestJWTAuthenticator(t *testing.T, key *rsa.PrivateKey) *auth.JWTAuthenticator {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksDocument(t, rsaJWK("k1", &key.PublicKey)), 0o600))
+	jwks, err := auth.NewFileJWKS(path)
+	require.NoError(t, err)
+	a, err := auth.NewJWTAuthenticator(auth.JWTConfig{Issuer: testIssuer, Audience: testAudience}, jwks)
+	require.NoError(t, err)
+	return a
+}
+
+func TestJWTAuthenticator_Valid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	a := newTestJWTAuthenticator(t, key)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+signToken(t, key, "k1", validClaims()))
+	id, err := a.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "user-123", id.ID)
+	assert.Equal(t, "jwt", id.Method)
+	assert.Equal(t, []string{"engineering", "uploaders"}, id.Groups)
+	assert.True(t, id.Allows(auth.ScopeUpload, "anything"))
+}
+
+func TestJWTAuthenticator_Rejects(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	a := newTestJWTAuthenticator(t, key)
+
+	with := func(k string, v any) jwt.MapClaims {
+		c := validClaims()
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+		return c
+	}
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	cases := map[string]string{
+		"expired":        signToken(t, key, "k1", with("exp", time.Now().Add(-time.Hour).Unix())),
+		"no expiry":      signToken(t, key, "k1", with("exp", nil)),
+		"wrong audience": signToken(t, key, "k1", with("aud", "api://other")),
+		"wrong issuer":   signToken(t, key, "k1", with("iss", "https://evil.example.com")),
+		"no subject":     signToken(t, key, "k1", with("sub", nil)),
+		"unknown key":    signToken(t, other, "k2", validClaims()),
+		"wrong key":      signToken(t, other, "k1", validClaims()),
+		"hmac":           hmacToken,
+		"garbage":        "not-a-jwt",
+	}
+	for name, token := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, err := a.Authenticate(req)
+		assert.ErrorIs(t, err, auth.ErrInvalidToken, name)
+	}
+}
+
+func TestJWTAuthenticator_IgnoresOtherCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	a := newTestJWTAuthenticator(t, key)
+
+	for _, header := range []string{"", "ApiKey abc", "Basic dXNlcjpwYXNz"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		id, err := a.Authenticate(req)
+		assert.NoError(t, err, header)
+		assert.Nil(t, id, header)
+	}
+}
+
+func TestNewJWTAuthenticator_RequiresAudience(t *testing.T) {
+	_, err := auth.NewJWTAuthenticator(auth.JWTConfig{Issuer: testIssuer}, auth.NewRemoteJWKS(testIssuer, ""))
+	assert.Error(t, err)
+}
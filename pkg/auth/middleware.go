@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"net/http"
+
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Authenticator extracts an identity from a request. It returns (nil, nil)
+// when the request carries no credential of the kind it handles, and an
+// error when a credential is present but invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Middleware authenticates every request with the first authenticator that
+// recognises its credentials. Requests without valid credentials are
+// rejected with 401, so with no authenticators every request is.
+func Middleware(authenticators ...Authenticator) gin.HandlerFunc {
+	logger := zap.L().Named("auth")
+	return func(c *gin.Context) {
+		for _, a := range authenticators {
+			id, err := a.Authenticate(c.Request)
+			if err != nil {
+				requestid.Logger(c, logger).Warn("Authentication failed",
+					zap.String("path", c.Request.URL.Path),
+					zap.String("client-ip", c.ClientIP()),
+					zap.Error(err),
+				)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, requestid.Error(c, "Invalid credentials"))
+				return
+			}
+			if id != nil {
+				SetIdentity(c, id)
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, requestid.Error(c, "Authentication required"))
+	}
+}
+
+// AllowPresigned skips authn for requests carrying presigned URL parameters.
+// The handler behind it is then responsible for verifying the signature.
+func AllowPresigned(authn gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if presign.IsPresigned(c.Request.URL.Query()) {
+			c.Next()
+			return
+		}
+		authn(c)
+	}
+}
+
+// RequireOperator only lets through callers granted ScopeOperator. It runs
+// after Middleware; requests without an identity, e.g. when authentication
+// is off, are refused.
+func RequireOperator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id, ok := FromContext(c); ok && id.IsOperator() {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, requestid.Error(c, "Permission denied"))
+	}
+}
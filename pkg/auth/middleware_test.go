@@ -0,0 +1,125 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"stream-upload-file/pkg/auth"
+)
+
+// headerAuthenticator accepts requests whose X-User header names a known user.
+type headerAuthenticator map[string]*auth.Identity
+
+func (h headerAuthenticator) Authenticate(r *http.Request) (*auth.Identity, error) {
+	user := r.Header.Get("X-User")
+	if user == "" {
+		return nil, nil
+	}
+	if id, ok := h[user]; ok {
+		return id, nil
+	}
+	return nil, errors.New("unknown user")
+}
+
+func newAuthRouter(mw gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/whoami", mw, func(c *gin.Context) {
+		id, ok := auth.FromContext(c)
+		if !ok {
+			c.String(http.StatusOK, "anonymous")
+			return
+		}
+		c.String(http.StatusOK, id.ID)
+	})
+	return r
+}
+
+func TestMiddleware(t *testing.T) {
+	router := newAuthRouter(auth.Middleware(headerAuthenticator{"alice": {ID: "alice"}}))
+
+	cases := []struct {
+		user   string
+		status int
+		body   string
+	}{
+		{"alice", http.StatusOK, "alice"},
+		{"mallory", http.StatusUnauthorized, ""},
+		{"", http.StatusUnauthorized, ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/whoami", nil)
+		if tc.user != "" {
+			req.Header.Set("X-User", tc.user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tc.status, w.Code, tc.user)
+		if tc.body != "" {
+			assert.Equal(t, tc.body, w.Body.String())
+		}
+	}
+}
+
+func TestMiddleware_NoAuthenticatorsRejectsAll(t *testing.T) {
+	router := newAuthRouter(auth.Middleware())
+
+	req := httptest.NewRequest("GET", "/whoami", nil)
+	req.Header.Set("X-User", "alice")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAllowPresigned(t *testing.T) {
+	router := newAuthRouter(auth.AllowPresigned(auth.Middleware(headerAuthenticator{})))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/whoami?sig=abc", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "anonymous", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/whoami", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestIdentity_NilScopesAreUnrestricted(t *testing.T) {
+	id := &auth.Identity{ID: "svc"}
+	assert.True(t, id.Allows(auth.ScopeDelete, "anything"))
+
+	id = &auth.Identity{ID: "ro", Scopes: []string{}}
+	assert.False(t, id.HasScope(auth.ScopeDownload))
+}
+
+func TestRequireOperator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := headerAuthenticator{
+		"ops":  {ID: "ops", Scopes: []string{auth.ScopeOperator}},
+		"root": {ID: "root"},
+	}
+	r := gin.New()
+	r.GET("/ops", auth.Middleware(users), auth.RequireOperator(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/open", auth.RequireOperator(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, tc := range []struct {
+		path, user string
+		status     int
+	}{
+		{"/ops", "ops", http.StatusOK},
+		// Unrestricted credentials don't imply operator.
+		{"/ops", "root", http.StatusForbidden},
+		{"/open", "", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("X-User", tc.user)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.status, w.Code, tc.user)
+	}
+}
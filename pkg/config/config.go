@@ -0,0 +1,334 @@
+// Package config holds the service's settings. They are read from an
+// optional YAML or JSON file, then overridden by environment variables and
+// finally by command-line flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"stream-upload-file/pkg/proxy"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Config is the service's configuration. Every leaf field has an `env` tag
+// naming the environment variable that overrides it; the matching flag is
+// its dotted YAML path, e.g. -server.addr.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Proxy     Proxy     `yaml:"proxy"`
+	Log       Log       `yaml:"log"`
+	Storage   Storage   `yaml:"storage"`
+	Uploads   Uploads   `yaml:"uploads"`
+	Presign   Presign   `yaml:"presign"`
+	Tenants   Tenants   `yaml:"tenants"`
+	Auth      Auth      `yaml:"auth"`
+	TLS       TLS       `yaml:"tls"`
+	Policy    Policy    `yaml:"policy"`
+	Quotas    Quotas    `yaml:"quotas"`
+	RateLimit RateLimit `yaml:"rateLimit"`
+	Bandwidth Bandwidth `yaml:"bandwidth"`
+	Webhooks  Webhooks  `yaml:"webhooks"`
+	Events    Events    `yaml:"events"`
+	Audit     Audit     `yaml:"audit"`
+	Health    Health    `yaml:"health"`
+}
+
+// Server configures the HTTP server and its shutdown.
+type Server struct {
+	Addr              string        `yaml:"addr" env:"SERVER_ADDR"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout" env:"SERVER_IDLE_TIMEOUT"`
+	// TransferIdleTimeout aborts a request once no body bytes have moved
+	// in either direction for this long.
+	TransferIdleTimeout time.Duration `yaml:"transferIdleTimeout" env:"SERVER_TRANSFER_IDLE_TIMEOUT"`
+	// MaxTransferDuration bounds a request however steadily it progresses.
+	MaxTransferDuration time.Duration `yaml:"maxTransferDuration" env:"SERVER_MAX_TRANSFER_DURATION"`
+	// DrainDelay is how long the server keeps serving after it reports
+	// unready on shutdown, so load balancers stop sending new requests.
+	DrainDelay time.Duration `yaml:"drainDelay" env:"SERVER_DRAIN_DELAY"`
+	// ShutdownTimeout bounds waiting for in-flight requests on shutdown.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SERVER_SHUTDOWN_TIMEOUT"`
+}
+
+// Proxy configures how client addresses are learned from the load
+// balancers and proxies in front of the service.
+type Proxy struct {
+	// Protocol is off, optional or required.
+	Protocol string `yaml:"protocol" env:"PROXY_PROTOCOL"`
+	// TrustedCIDRs are the load balancers allowed to send a PROXY header.
+	TrustedCIDRs  []string      `yaml:"trustedCidrs,omitempty" env:"PROXY_PROTOCOL_TRUSTED_CIDRS"`
+	HeaderTimeout time.Duration `yaml:"headerTimeout" env:"PROXY_PROTOCOL_HEADER_TIMEOUT"`
+	// TrustedProxies are the proxies whose X-Forwarded-For and X-Real-IP
+	// headers are believed.
+	TrustedProxies []string `yaml:"trustedProxies,omitempty" env:"TRUSTED_PROXIES"`
+}
+
+// PROXY protocol modes.
+const (
+	ProxyProtocolOff      = "off"
+	ProxyProtocolOptional = "optional"
+	ProxyProtocolRequired = "required"
+)
+
+// Log configures the application log.
+type Log struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+}
+
+// Storage selects the storage account and how it is called.
+type Storage struct {
+	AccountName       string         `yaml:"accountName" env:"STORAGE_ACCOUNT_NAME"`
+	ContainerName     string         `yaml:"containerName" env:"STORAGE_CONTAINER_NAME"`
+	Compression       string         `yaml:"compression" env:"STORAGE_COMPRESSION"`
+	EncryptionKeyFile string         `yaml:"encryptionKeyFile" env:"STORAGE_ENCRYPTION_KEY_FILE"`
+	Retry             Retry          `yaml:"retry"`
+	Timeouts          StorageTimeout `yaml:"timeouts"`
+}
+
+// Retry is the Azure SDK's retry policy for storage calls.
+type Retry struct {
+	MaxRetries    int           `yaml:"maxRetries" env:"STORAGE_MAX_RETRIES"`
+	RetryDelay    time.Duration `yaml:"retryDelay" env:"STORAGE_RETRY_DELAY"`
+	MaxRetryDelay time.Duration `yaml:"maxRetryDelay" env:"STORAGE_MAX_RETRY_DELAY"`
+}
+
+// StorageTimeout bounds the storage work of one request; zero disables a
+// deadline.
+type StorageTimeout struct {
+	Upload    time.Duration `yaml:"upload" env:"STORAGE_UPLOAD_TIMEOUT"`
+	Download  time.Duration `yaml:"download" env:"STORAGE_DOWNLOAD_TIMEOUT"`
+	Operation time.Duration `yaml:"operation" env:"STORAGE_OPERATION_TIMEOUT"`
+}
+
+// Uploads limits uploads through the service.
+type Uploads struct {
+	MaxSize          int64 `yaml:"maxSize" env:"UPLOAD_MAX_SIZE"`
+	MaxInflight      int   `yaml:"maxInflight" env:"MAX_INFLIGHT_UPLOADS"`
+	MaxInflightBytes int64 `yaml:"maxInflightBytes" env:"MAX_INFLIGHT_UPLOAD_BYTES"`
+	DirectEnabled    bool  `yaml:"directEnabled" env:"DIRECT_UPLOADS_ENABLED"`
+}
+
+// Presign configures presigned URLs.
+type Presign struct {
+	SecretFile string `yaml:"secretFile" env:"PRESIGN_SECRET_FILE"`
+	BaseURL    string `yaml:"baseUrl" env:"PRESIGN_BASE_URL"`
+}
+
+// Tenants enables multi-tenant mode.
+type Tenants struct {
+	File string `yaml:"file" env:"TENANTS_FILE"`
+}
+
+// Auth configures the credential sources. Authentication is off when none
+// is set.
+type Auth struct {
+	APIKeysFile         string `yaml:"apiKeysFile" env:"API_KEYS_FILE"`
+	ClientCertRulesFile string `yaml:"clientCertRulesFile" env:"CLIENT_CERT_RULES_FILE"`
+	OIDC                OIDC   `yaml:"oidc"`
+}
+
+// OIDC configures bearer token authentication.
+type OIDC struct {
+	Issuer       string `yaml:"issuer" env:"OIDC_ISSUER"`
+	Audience     string `yaml:"audience" env:"OIDC_AUDIENCE"`
+	JWKSURL      string `yaml:"jwksUrl" env:"OIDC_JWKS_URL"`
+	JWKSFile     string `yaml:"jwksFile" env:"OIDC_JWKS_FILE"`
+	SubjectClaim string `yaml:"subjectClaim" env:"OIDC_SUBJECT_CLAIM"`
+	GroupsClaim  string `yaml:"groupsClaim" env:"OIDC_GROUPS_CLAIM"`
+	TenantClaim  string `yaml:"tenantClaim" env:"OIDC_TENANT_CLAIM"`
+}
+
+// TLS enables native TLS.
+type TLS struct {
+	CertFile     string `yaml:"certFile" env:"TLS_CERT_FILE"`
+	KeyFile      string `yaml:"keyFile" env:"TLS_KEY_FILE"`
+	ClientCAFile string `yaml:"clientCaFile" env:"TLS_CLIENT_CA_FILE"`
+	ClientAuth   string `yaml:"clientAuth" env:"TLS_CLIENT_AUTH"`
+}
+
+// Policy enables per-prefix access control.
+type Policy struct {
+	File string `yaml:"file" env:"RBAC_POLICY_FILE"`
+}
+
+// Quotas enables storage quotas.
+type Quotas struct {
+	File              string        `yaml:"file" env:"QUOTAS_FILE"`
+	ReconcileInterval time.Duration `yaml:"reconcileInterval" env:"QUOTA_RECONCILE_INTERVAL"`
+}
+
+// RateLimit limits requests per client; zero RPS disables it.
+type RateLimit struct {
+	RPS   float64 `yaml:"rps" env:"RATE_LIMIT_RPS"`
+	Burst int     `yaml:"burst" env:"RATE_LIMIT_BURST"`
+}
+
+// Bandwidth enables bandwidth limits.
+type Bandwidth struct {
+	LimitsFile string `yaml:"limitsFile" env:"BANDWIDTH_LIMITS_FILE"`
+}
+
+// Webhooks enables upload webhooks.
+type Webhooks struct {
+	File          string `yaml:"file" env:"WEBHOOKS_FILE"`
+	DeadLetterDir string `yaml:"deadLetterDir" env:"WEBHOOK_DEAD_LETTER_DIR"`
+}
+
+// Events configures CloudEvents publishing; an empty backend disables it.
+type Events struct {
+	Backend string `yaml:"backend" env:"EVENTS_BACKEND"`
+	Source  string `yaml:"source" env:"EVENTS_SOURCE"`
+	NATS    NATS   `yaml:"nats"`
+	Kafka   Kafka  `yaml:"kafka"`
+}
+
+// NATS configures the NATS events backend.
+type NATS struct {
+	URL           string `yaml:"url" env:"NATS_URL"`
+	SubjectPrefix string `yaml:"subjectPrefix" env:"EVENTS_NATS_SUBJECT_PREFIX"`
+}
+
+// Kafka configures the Kafka events backend.
+type Kafka struct {
+	Brokers []string `yaml:"brokers,omitempty" env:"KAFKA_BROKERS"`
+	Topic   string   `yaml:"topic" env:"EVENTS_KAFKA_TOPIC"`
+}
+
+// Audit configures the audit log sinks.
+type Audit struct {
+	File   string `yaml:"file" env:"AUDIT_LOG_FILE"`
+	Stdout bool   `yaml:"stdout" env:"AUDIT_LOG_STDOUT"`
+}
+
+// Health configures dependency probing.
+type Health struct {
+	Interval         time.Duration `yaml:"interval" env:"HEALTH_CHECK_INTERVAL"`
+	Timeout          time.Duration `yaml:"timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	FailureThreshold int           `yaml:"failureThreshold" env:"HEALTH_FAILURE_THRESHOLD"`
+	SuccessThreshold int           `yaml:"successThreshold" env:"HEALTH_SUCCESS_THRESHOLD"`
+}
+
+// Events backends.
+const (
+	EventsNATS   = "nats"
+	EventsKafka  = "kafka"
+	EventsMemory = "memory"
+)
+
+// Defaults returns the configuration used for anything not set.
+func Defaults() Config {
+	return Config{
+		Server: Server{
+			Addr:                ":8080",
+			ReadHeaderTimeout:   10 * time.Second,
+			IdleTimeout:         120 * time.Second,
+			TransferIdleTimeout: time.Minute,
+			DrainDelay:          15 * time.Second,
+			ShutdownTimeout:     30 * time.Second,
+		},
+		Proxy: Proxy{
+			Protocol:      ProxyProtocolOff,
+			HeaderTimeout: 10 * time.Second,
+		},
+		Log: Log{Level: "info"},
+		Storage: Storage{
+			Retry: Retry{
+				MaxRetries:    3,
+				RetryDelay:    time.Second,
+				MaxRetryDelay: 30 * time.Second,
+			},
+			Timeouts: StorageTimeout{
+				Upload:    15 * time.Minute,
+				Download:  time.Hour,
+				Operation: 30 * time.Second,
+			},
+		},
+		Uploads: Uploads{MaxSize: 100 * 1024 * 1024},
+		Quotas:  Quotas{ReconcileInterval: 15 * time.Minute},
+		Events: Events{
+			Source: "stream-upload-file",
+			NATS:   NATS{URL: "nats://127.0.0.1:4222", SubjectPrefix: "files"},
+			Kafka:  Kafka{Topic: "file-events"},
+		},
+		Health: Health{
+			Interval:         15 * time.Second,
+			Timeout:          5 * time.Second,
+			FailureThreshold: 3,
+			SuccessThreshold: 2,
+		},
+	}
+}
+
+// Validate reports every setting that is missing or out of range.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(slices.Contains([]string{ProxyProtocolOff, ProxyProtocolOptional, ProxyProtocolRequired}, c.Proxy.Protocol), "proxy.protocol must be off, optional or required")
+	check(c.Proxy.Protocol == ProxyProtocolOff || len(c.Proxy.TrustedCIDRs) > 0, "proxy.trustedCidrs is required when the PROXY protocol is enabled")
+	check(c.Proxy.HeaderTimeout > 0, "proxy.headerTimeout must be positive")
+	if _, err := proxy.ParsePrefixes(c.Proxy.TrustedCIDRs); err != nil {
+		errs = append(errs, fmt.Errorf("proxy.trustedCidrs: %w", err))
+	}
+	if _, err := proxy.ParsePrefixes(c.Proxy.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("proxy.trustedProxies: %w", err))
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.readHeaderTimeout", c.Server.ReadHeaderTimeout},
+		{"server.idleTimeout", c.Server.IdleTimeout},
+		{"server.transferIdleTimeout", c.Server.TransferIdleTimeout},
+		{"server.maxTransferDuration", c.Server.MaxTransferDuration},
+		{"server.drainDelay", c.Server.DrainDelay},
+		{"server.shutdownTimeout", c.Server.ShutdownTimeout},
+		{"storage.timeouts.upload", c.Storage.Timeouts.Upload},
+		{"storage.timeouts.download", c.Storage.Timeouts.Download},
+		{"storage.timeouts.operation", c.Storage.Timeouts.Operation},
+	} {
+		check(d.value >= 0, "%s must not be negative", d.name)
+	}
+	if _, err := zapcore.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+
+	check(c.Storage.AccountName != "", "storage.accountName is required")
+	check(c.Storage.ContainerName != "", "storage.containerName is required")
+	check(slices.Contains([]string{"", "gzip", "zstd"}, c.Storage.Compression), "storage.compression must be gzip or zstd")
+	check(c.Storage.Retry.MaxRetries >= 0, "storage.retry.maxRetries must not be negative")
+	check(c.Storage.Retry.RetryDelay > 0, "storage.retry.retryDelay must be positive")
+	check(c.Storage.Retry.MaxRetryDelay >= c.Storage.Retry.RetryDelay, "storage.retry.maxRetryDelay must be at least retryDelay")
+
+	check(c.Uploads.MaxSize > 0, "uploads.maxSize must be positive")
+	check(c.Uploads.MaxInflight >= 0, "uploads.maxInflight must not be negative")
+	check(c.Uploads.MaxInflightBytes >= 0, "uploads.maxInflightBytes must not be negative")
+	check(!c.Uploads.DirectEnabled || c.Presign.SecretFile != "", "uploads.directEnabled requires presign.secretFile, which signs the upload tokens")
+
+	check(c.TLS.CertFile == "" || c.TLS.KeyFile != "", "tls.keyFile is required with tls.certFile")
+	check(c.TLS.CertFile != "" || c.TLS.ClientCAFile == "", "tls.clientCaFile requires tls.certFile")
+
+	check(c.Quotas.ReconcileInterval > 0, "quotas.reconcileInterval must be positive")
+	check(c.RateLimit.RPS >= 0, "rateLimit.rps must not be negative")
+	check(c.RateLimit.Burst >= 0, "rateLimit.burst must not be negative")
+
+	check(slices.Contains([]string{"", EventsNATS, EventsKafka, EventsMemory}, c.Events.Backend), "events.backend must be nats, kafka or memory")
+	check(c.Events.Backend != EventsKafka || len(c.Events.Kafka.Brokers) > 0, "events.kafka.brokers is required for the kafka backend")
+
+	check(c.Health.Interval > 0, "health.interval must be positive")
+	check(c.Health.Timeout > 0, "health.timeout must be positive")
+	check(c.Health.FailureThreshold > 0, "health.failureThreshold must be positive")
+	check(c.Health.SuccessThreshold > 0, "health.successThreshold must be positive")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the config file when -config isn't given.
+const FileEnv = "CONFIG_FILE"
+
+// Loader builds the configuration from its sources. Flags are parsed once;
+// the file and environment are read again on every Load.
+type Loader struct {
+	// File is the config file, or "" for none.
+	File string
+	// PrintConfig is set by -print-config.
+	PrintConfig bool
+
+	lookupEnv func(string) (string, bool)
+	flags     []setting
+}
+
+// setting is one value given on the command line.
+type setting struct {
+	path, value string
+}
+
+// field is one leaf of Config.
+type field struct {
+	path  string
+	env   string
+	value reflect.Value
+}
+
+// NewLoader parses args, typically os.Args[1:]. Every setting has a flag
+// named after its path in the file, e.g. -storage.retry.maxRetries.
+// lookupEnv is usually os.LookupEnv.
+func NewLoader(name string, args []string, lookupEnv func(string) (string, bool)) (*Loader, error) {
+	l := &Loader{lookupEnv: lookupEnv}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&l.File, "config", "", "YAML or JSON config file (env "+FileEnv+")")
+	fs.BoolVar(&l.PrintConfig, "print-config", false, "print the effective configuration and exit")
+	defaults := Defaults()
+	for _, f := range fields(reflect.ValueOf(&defaults).Elem(), "") {
+		fs.Var(&flagValue{loader: l, field: f}, f.path, "env "+f.env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if l.File == "" {
+		l.File, _ = lookupEnv(FileEnv)
+	}
+	return l, nil
+}
+
+// Load returns the validated configuration: the defaults, overridden by the
+// file, then by environment variables, then by flags.
+func (l *Loader) Load() (*Config, error) {
+	cfg := Defaults()
+	if l.File != "" {
+		if err := decodeFile(l.File, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	byPath := map[string]field{}
+	for _, f := range fields(reflect.ValueOf(&cfg).Elem(), "") {
+		byPath[f.path] = f
+		if v, ok := l.lookupEnv(f.env); ok && v != "" {
+			if err := set(f.value, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+	for _, s := range l.flags {
+		if err := set(byPath[s.path].value, s.value); err != nil {
+			return nil, fmt.Errorf("-%s: %w", s.path, err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// YAML renders c in the config file format.
+func (c *Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+// decodeFile reads a YAML file into cfg; JSON is valid YAML. Unknown keys
+// are rejected so that typos don't go unnoticed.
+func decodeFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fields lists the leaves of the struct v, with their dotted YAML paths.
+func fields(v reflect.Value, prefix string) []field {
+	var out []field
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		path := prefix + strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if sf.Type.Kind() == reflect.Struct {
+			out = append(out, fields(v.Field(i), path+".")...)
+			continue
+		}
+		out = append(out, field{path: path, env: sf.Tag.Get("env"), value: v.Field(i)})
+	}
+	return out
+}
+
+// set parses raw into v. Lists are comma-separated.
+func set(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int, v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for item := range strings.SplitSeq(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// flagValue records a flag for Load to apply after the file and
+// environment.
+type flagValue struct {
+	loader *Loader
+	field  field
+}
+
+func (f *flagValue) String() string {
+	if f == nil || !f.field.value.IsValid() {
+		return ""
+	}
+	v := f.field.value
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func (f *flagValue) Set(raw string) error {
+	// Parse now so that bad values are reported with the flag.
+	if err := set(reflect.New(f.field.value.Type()).Elem(), raw); err != nil {
+		return err
+	}
+	f.loader.flags = append(f.loader.flags, setting{path: f.field.path, value: raw})
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.field.value.Kind() == reflect.Bool
+}
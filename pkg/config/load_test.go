@@ -0,0 +1,143 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// env is a fake environment for Loader.
+type env map[string]string
+
+func (e env) lookup(name string) (string, bool) {
+	v, ok := e[name]
+	return v, ok
+}
+
+// required are the settings without defaults.
+var required = env{"STORAGE_ACCOUNT_NAME": "acct", "STORAGE_CONTAINER_NAME": "files"}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func load(t *testing.T, args []string, e env) (*config.Config, error) {
+	t.Helper()
+	l, err := config.NewLoader("test", args, e.lookup)
+	require.NoError(t, err)
+	return l.Load()
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(t, nil, required)
+	require.NoError(t, err)
+	assert.Equal(t, ":8080", cfg.Server.Addr)
+	assert.Equal(t, 15*time.Second, cfg.Server.DrainDelay)
+	assert.Equal(t, int64(100*1024*1024), cfg.Uploads.MaxSize)
+	assert.Equal(t, 3, cfg.Storage.Retry.MaxRetries)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+server:
+  addr: ":9000"
+  transferIdleTimeout: 0s
+  idleTimeout: 5m
+storage:
+  accountName: fromfile
+  containerName: files
+  retry:
+    maxRetries: 5
+events:
+  backend: kafka
+  kafka:
+    brokers: [a:9092, b:9092]
+`)
+	cfg, err := load(t,
+		[]string{"-config", file, "-server.addr", ":9100", "-audit.stdout"},
+		env{"SERVER_ADDR": ":9050", "SERVER_IDLE_TIMEOUT": "1m", "STORAGE_ACCOUNT_NAME": "fromenv"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, ":9100", cfg.Server.Addr, "flags override env")
+	assert.Equal(t, time.Minute, cfg.Server.IdleTimeout, "env overrides the file")
+	assert.Equal(t, "fromenv", cfg.Storage.AccountName)
+	assert.Zero(t, cfg.Server.TransferIdleTimeout, "the file overrides defaults")
+	assert.Equal(t, 5, cfg.Storage.Retry.MaxRetries)
+	assert.Equal(t, 10*time.Second, cfg.Server.ReadHeaderTimeout, "unset keys keep their defaults")
+	assert.Equal(t, []string{"a:9092", "b:9092"}, cfg.Events.Kafka.Brokers)
+	assert.True(t, cfg.Audit.Stdout)
+}
+
+func TestLoad_JSONFileFromEnv(t *testing.T) {
+	file := writeFile(t, "config.json", `{"uploads": {"maxSize": 1048576}, "rateLimit": {"rps": 2.5}}`)
+	e := env{"CONFIG_FILE": file, "KAFKA_BROKERS": "a:9092, b:9092"}
+	for k, v := range required {
+		e[k] = v
+	}
+	cfg, err := load(t, nil, e)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1048576), cfg.Uploads.MaxSize)
+	assert.Equal(t, 2.5, cfg.RateLimit.RPS)
+	assert.Equal(t, []string{"a:9092", "b:9092"}, cfg.Events.Kafka.Brokers)
+}
+
+func TestLoad_RejectsUnknownKeys(t *testing.T) {
+	file := writeFile(t, "config.yaml", "server:\n  adress: \":9000\"\n")
+	_, err := load(t, []string{"-config", file}, required)
+	assert.ErrorContains(t, err, "adress")
+}
+
+func TestLoad_InvalidValues(t *testing.T) {
+	_, err := load(t, nil, env{"SERVER_DRAIN_DELAY": "soon"})
+	assert.ErrorContains(t, err, "SERVER_DRAIN_DELAY")
+
+	_, err = config.NewLoader("test", []string{"-uploads.maxSize", "big"}, required.lookup)
+	assert.Error(t, err)
+
+	_, err = config.NewLoader("test", []string{"extra"}, required.lookup)
+	assert.Error(t, err)
+}
+
+func TestValidate_ReportsEveryProblem(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Server.ShutdownTimeout = -time.Second
+	cfg.Log.Level = "loud"
+	cfg.Events.Backend = "kafka"
+	cfg.Proxy.Protocol = "required"
+	cfg.Proxy.TrustedProxies = []string{"10.0.0.0/33"}
+	cfg.Uploads.DirectEnabled = true
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, want := range []string{
+		"server.shutdownTimeout must not be negative",
+		"log.level",
+		"storage.accountName is required",
+		"events.kafka.brokers is required",
+		"proxy.trustedCidrs is required",
+		"proxy.trustedProxies: invalid address or CIDR",
+		"uploads.directEnabled requires presign.secretFile",
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestYAML_RoundTrips(t *testing.T) {
+	cfg, err := load(t, nil, required)
+	require.NoError(t, err)
+	out, err := cfg.YAML()
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "drainDelay: 15s")
+
+	again, err := load(t, []string{"-config", writeFile(t, "printed.yaml", string(out))}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, again)
+}
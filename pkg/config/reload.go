@@ -0,0 +1,188 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	reloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "config_reloads_total",
+		Help: "Configuration reloads, by result.",
+	}, []string{"result"})
+	lastReload = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "config_last_reload_success_timestamp_seconds",
+		Help: "Time of the last successful configuration reload.",
+	})
+)
+
+// Reloader loads the configuration again at runtime and hands it to the
+// parts of the service that can apply it without a restart. An invalid
+// configuration is rejected as a whole and the running one stays in effect.
+type Reloader struct {
+	loader *Loader
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	current  *Config
+	appliers []applier
+	lastRaw  []byte
+}
+
+type applier struct {
+	name  string
+	keys  []string
+	apply func(*Config) error
+}
+
+// NewReloader returns a reloader for the configuration current, which
+// loader produced.
+func NewReloader(loader *Loader, current *Config) *Reloader {
+	r := &Reloader{
+		loader:  loader,
+		logger:  zap.L().Named("config"),
+		current: current,
+	}
+	if loader.File != "" {
+		r.lastRaw, _ = os.ReadFile(loader.File)
+	}
+	return r
+}
+
+// OnReload registers apply, which is called with every configuration
+// loaded from now on. keys are the settings it puts into effect; changes
+// to settings no applier claims are reported as needing a restart and are
+// not passed on. apply is called even when none of its keys changed, so
+// that it can re-read the files it refers to.
+func (r *Reloader) OnReload(name string, apply func(*Config) error, keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appliers = append(r.appliers, applier{name: name, keys: keys, apply: apply})
+}
+
+// Current returns the configuration in effect.
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads the configuration and applies it. If it is invalid nothing
+// is applied. Otherwise every applier runs, and the errors of those that
+// failed are returned together.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.loader.Load()
+	if err != nil {
+		reloadsTotal.WithLabelValues("invalid").Inc()
+		return err
+	}
+	claimed := map[string]bool{}
+	for _, a := range r.appliers {
+		for _, key := range a.keys {
+			claimed[key] = true
+		}
+	}
+	var changed, restart []string
+	for _, key := range diff(r.current, next) {
+		if claimed[key] {
+			changed = append(changed, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+	// Keep what can't be applied as it is running, so that Current stays
+	// true and the warning repeats until the pod is restarted.
+	keep(next, r.current, restart)
+	if len(restart) > 0 {
+		r.logger.Warn("Configuration changes need a restart to take effect", zap.Strings("settings", restart))
+	}
+
+	var errs []error
+	for _, a := range r.appliers {
+		if err := a.apply(next); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.name, err))
+		}
+	}
+	r.current = next
+	if err := errors.Join(errs...); err != nil {
+		reloadsTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+	reloadsTotal.WithLabelValues("success").Inc()
+	lastReload.SetToCurrentTime()
+	r.logger.Info("Configuration reloaded", zap.Strings("changed", changed))
+	return nil
+}
+
+// Watch polls the config file every interval until ctx is done and
+// reloads when its content changes. Without a config file it returns
+// immediately.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if r.loader.File == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			raw, err := os.ReadFile(r.loader.File)
+			if err != nil {
+				r.logger.Error("Failed to read configuration, keeping the running one", zap.Error(err))
+				continue
+			}
+			if bytes.Equal(raw, r.lastRaw) {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.logger.Error("Failed to reload configuration, keeping the running one", zap.Error(err))
+				continue
+			}
+			r.lastRaw = raw
+		}
+	}
+}
+
+// diff returns the paths of the settings that differ between a and b.
+func diff(a, b *Config) []string {
+	bFields := fields(reflect.ValueOf(b).Elem(), "")
+	var out []string
+	for i, f := range fields(reflect.ValueOf(a).Elem(), "") {
+		if !reflect.DeepEqual(f.value.Interface(), bFields[i].value.Interface()) {
+			out = append(out, f.path)
+		}
+	}
+	return out
+}
+
+// keep copies the settings at paths from src into dst.
+func keep(dst, src *Config, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	want := map[string]bool{}
+	for _, p := range paths {
+		want[p] = true
+	}
+	srcFields := fields(reflect.ValueOf(src).Elem(), "")
+	for i, f := range fields(reflect.ValueOf(dst).Elem(), "") {
+		if want[f.path] {
+			f.value.Set(srcFields[i].value)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const baseFile = `
+storage:
+  accountName: acct
+  containerName: files
+rateLimit:
+`
+
+func newReloader(t *testing.T, content string) (*config.Reloader, string) {
+	t.Helper()
+	file := writeFile(t, "config.yaml", content)
+	l, err := config.NewLoader("test", []string{"-config", file}, env{}.lookup)
+	require.NoError(t, err)
+	cfg, err := l.Load()
+	require.NoError(t, err)
+	return config.NewReloader(l, cfg), file
+}
+
+func TestReloader_AppliesClaimedSettings(t *testing.T) {
+	r, file := newReloader(t, baseFile+"  rps: 1\n")
+	var applied *config.Config
+	r.OnReload("rate limit", func(cfg *config.Config) error {
+		applied = cfg
+		return nil
+	}, "rateLimit.rps", "rateLimit.burst")
+
+	require.NoError(t, os.WriteFile(file, []byte(baseFile+"  rps: 1\n  burst: 4\nserver:\n  addr: \":9000\"\n"), 0o600))
+	require.NoError(t, r.Reload())
+	require.NotNil(t, applied)
+	assert.Equal(t, 4, applied.RateLimit.Burst)
+	assert.Equal(t, ":8080", applied.Server.Addr, "unclaimed settings keep their running value")
+	assert.Same(t, applied, r.Current())
+}
+
+func TestReloader_KeepsRunningConfigWhenInvalid(t *testing.T) {
+	r, file := newReloader(t, baseFile+"  rps: 1\n")
+	before := r.Current()
+	calls := 0
+	r.OnReload("rate limit", func(*config.Config) error {
+		calls++
+		return nil
+	}, "rateLimit.rps")
+
+	require.NoError(t, os.WriteFile(file, []byte(baseFile+"  rps: -1\n"), 0o600))
+	assert.Error(t, r.Reload())
+	require.NoError(t, os.WriteFile(file, []byte("rateLimit: [\n"), 0o600))
+	assert.Error(t, r.Reload())
+	assert.Zero(t, calls)
+	assert.Same(t, before, r.Current())
+}
+
+func TestReloader_ReportsApplierErrors(t *testing.T) {
+	r, _ := newReloader(t, baseFile+"  rps: 1\n")
+	var other bool
+	r.OnReload("webhooks", func(*config.Config) error { return errors.New("bad target") })
+	r.OnReload("log level", func(*config.Config) error {
+		other = true
+		return nil
+	}, "log.level")
+
+	err := r.Reload()
+	assert.ErrorContains(t, err, "webhooks: bad target")
+	assert.True(t, other, "one failing applier doesn't stop the others")
+}
+
+func TestReloader_WatchesFile(t *testing.T) {
+	r, file := newReloader(t, baseFile+"  rps: 1\n")
+	var rps atomic.Value
+	r.OnReload("rate limit", func(cfg *config.Config) error {
+		rps.Store(cfg.RateLimit.RPS)
+		return nil
+	}, "rateLimit.rps")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 5*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(file, []byte(baseFile+"  rps: 7\n"), 0o600))
+	assert.Eventually(t, func() bool { return rps.Load() == 7.0 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,189 @@
+// Package deadline bounds requests by progress rather than by total
+// duration, so that a large transfer over a slow link can run for as long
+// as bytes keep moving while a stalled one is cut off.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"stream-upload-file/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Middleware aborts a request once a read of its body or a write of its
+// response has made no progress for idle, and in any case once it has run
+// for maxDuration. Only time spent waiting on the client counts towards
+// idle: a handler busy with slow storage between reads or writes is not
+// stalled. The request context ends at maxDuration too, so that storage
+// calls stop with it. Zero disables either limit.
+//
+// The limits are enforced with connection deadlines through
+// http.ResponseController, so they replace the server's ReadTimeout and
+// WriteTimeout, which must be left unset.
+func Middleware(idle, maxDuration time.Duration) gin.HandlerFunc {
+	logger := zap.L().Named("deadline")
+	return func(c *gin.Context) {
+		if idle <= 0 && maxDuration <= 0 {
+			c.Next()
+			return
+		}
+		now := time.Now()
+		p := &progress{
+			rc:     http.NewResponseController(c.Writer),
+			idle:   idle,
+			logger: requestid.Logger(c, logger),
+		}
+		if maxDuration > 0 {
+			p.limit = now.Add(maxDuration)
+			ctx, cancel := context.WithDeadline(c.Request.Context(), p.limit)
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+		}
+		// Extending the deadlines on every read would cost a timer reset
+		// per buffer; a fraction of idle is precise enough.
+		p.every = min(time.Second, idle/10)
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			p.reading = true
+			c.Request.Body = &body{ReadCloser: c.Request.Body, p: p}
+		}
+		p.extend(now)
+
+		c.Writer = &writer{ResponseWriter: c.Writer, p: p}
+		c.Next()
+	}
+}
+
+// progress moves a request's deadlines forward as bytes move.
+type progress struct {
+	rc     *http.ResponseController
+	idle   time.Duration
+	every  time.Duration
+	limit  time.Time
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	extended time.Time
+	reading  bool
+	stalled  bool
+}
+
+// touch moves the deadlines forward before and after each read or write,
+// so that each one gets idle to make progress however long the handler
+// spent since the last.
+func (p *progress) touch() {
+	if p.idle <= 0 {
+		return
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.extended) >= p.every {
+		p.extend(now)
+	}
+}
+
+// bodyRead records that the request body has been read to the end. From
+// then on the server is waiting on the connection for the next request
+// and cancels the request context if that read fails, so only the write
+// deadline may be moved.
+func (p *progress) bodyRead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reading = false
+}
+
+// extend sets the deadlines to idle from now, but not past the limit. The
+// read deadline only applies while the request body is being read.
+func (p *progress) extend(now time.Time) {
+	p.extended = now
+	d := p.limit
+	if p.idle > 0 {
+		if next := now.Add(p.idle); d.IsZero() || next.Before(d) {
+			d = next
+		}
+	}
+	// Unsupported only for writers that aren't connections, as in tests.
+	if p.reading {
+		_ = p.rc.SetReadDeadline(d)
+	}
+	_ = p.rc.SetWriteDeadline(d)
+}
+
+// failed logs the first error caused by a deadline.
+func (p *progress) failed(err error) {
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stalled {
+		return
+	}
+	p.stalled = true
+	if !p.limit.IsZero() && !time.Now().Before(p.limit) {
+		p.logger.Warn("Transfer exceeded its maximum duration", zap.Time("deadline", p.limit))
+		return
+	}
+	p.logger.Warn("Transfer stalled", zap.Duration("idle", p.idle))
+}
+
+type body struct {
+	io.ReadCloser
+	p *progress
+}
+
+func (b *body) Read(buf []byte) (int, error) {
+	b.p.touch()
+	n, err := b.ReadCloser.Read(buf)
+	if n > 0 {
+		b.p.touch()
+	}
+	if err == io.EOF {
+		b.p.bodyRead()
+	} else if err != nil {
+		b.p.failed(err)
+	}
+	return n, err
+}
+
+type writer struct {
+	gin.ResponseWriter
+	p *progress
+}
+
+func (w *writer) Write(buf []byte) (int, error) {
+	w.p.touch()
+	n, err := w.ResponseWriter.Write(buf)
+	if n > 0 {
+		w.p.touch()
+	}
+	if err != nil {
+		w.p.failed(err)
+	}
+	return n, err
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	w.p.touch()
+	n, err := w.ResponseWriter.WriteString(s)
+	if n > 0 {
+		w.p.touch()
+	}
+	if err != nil {
+		w.p.failed(err)
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
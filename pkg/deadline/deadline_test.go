@@ -0,0 +1,141 @@
+package deadline_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/deadline"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upload records what the handler saw when reading the request body.
+type upload struct {
+	n   int
+	err error
+	ctx error
+}
+
+func newServer(t *testing.T, idle, maxDuration time.Duration) (*httptest.Server, chan upload) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	results := make(chan upload, 1)
+	router := gin.New()
+	router.Use(deadline.Middleware(idle, maxDuration))
+	router.POST("/upload", func(c *gin.Context) {
+		data, err := io.ReadAll(c.Request.Body)
+		results <- upload{n: len(data), err: err, ctx: c.Request.Context().Err()}
+		c.Status(http.StatusOK)
+	})
+	router.GET("/download", func(c *gin.Context) {
+		for range 10 {
+			c.Writer.Write(bytes.Repeat([]byte("x"), 1024))
+			c.Writer.Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+	})
+	// Slow storage between taking the body and answering, with a response
+	// too big to sit in the server's buffer.
+	store := func(c *gin.Context) {
+		data, err := io.ReadAll(c.Request.Body)
+		time.Sleep(300 * time.Millisecond)
+		results <- upload{n: len(data), err: err, ctx: c.Request.Context().Err()}
+		c.Writer.Write(bytes.Repeat([]byte("x"), 64*1024))
+	}
+	router.POST("/store", store)
+	router.GET("/store", store)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, results
+}
+
+// send posts a body written in chunks, pausing before each one.
+func send(srv *httptest.Server, pauses ...time.Duration) {
+	pr, pw := io.Pipe()
+	go func() {
+		for _, pause := range pauses {
+			time.Sleep(pause)
+			if _, err := pw.Write([]byte("chunk")); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/upload", pr)
+	if resp, err := srv.Client().Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func repeat(d time.Duration, n int) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = d
+	}
+	return out
+}
+
+func TestMiddleware_SlowTransferKeepsGoing(t *testing.T) {
+	srv, results := newServer(t, 150*time.Millisecond, 0)
+
+	send(srv, repeat(50*time.Millisecond, 10)...)
+	got := <-results
+	require.NoError(t, got.err, "500ms in total, but never idle for 150ms")
+	assert.Equal(t, 50, got.n)
+}
+
+func TestMiddleware_StalledUploadIsAborted(t *testing.T) {
+	srv, results := newServer(t, 100*time.Millisecond, 0)
+
+	go send(srv, 0, 0, 500*time.Millisecond, 0)
+	got := <-results
+	assert.True(t, errors.Is(got.err, os.ErrDeadlineExceeded), "got %v", got.err)
+	assert.Equal(t, 10, got.n)
+}
+
+func TestMiddleware_MaxDuration(t *testing.T) {
+	srv, results := newServer(t, time.Second, 150*time.Millisecond)
+
+	go send(srv, repeat(50*time.Millisecond, 10)...)
+	got := <-results
+	assert.True(t, errors.Is(got.err, os.ErrDeadlineExceeded), "got %v", got.err)
+	assert.Error(t, got.ctx, "the request context ends too")
+	assert.Less(t, got.n, 50)
+}
+
+func TestMiddleware_SlowDownloadCompletes(t *testing.T) {
+	srv, _ := newServer(t, 100*time.Millisecond, 0)
+
+	resp, err := srv.Client().Get(srv.URL + "/download")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Len(t, data, 10*1024, "400ms in total, written every 40ms")
+}
+
+func TestMiddleware_SlowStorageIsNotAStall(t *testing.T) {
+	srv, results := newServer(t, 100*time.Millisecond, 0)
+
+	for method, body := range map[string]io.Reader{http.MethodPost: strings.NewReader("chunk"), http.MethodGet: nil} {
+		req, _ := http.NewRequest(method, srv.URL+"/store", body)
+		resp, err := srv.Client().Do(req)
+		require.NoError(t, err, method)
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err, method)
+		assert.Len(t, data, 64*1024, "%s: 300ms without client I/O, but the client never stalled", method)
+		got := <-results
+		require.NoError(t, got.err, method)
+		assert.NoError(t, got.ctx, "%s: the request context outlives the idle timeout", method)
+	}
+}
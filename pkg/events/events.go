@@ -0,0 +1,87 @@
+// Package events publishes file lifecycle events to a message bus as
+// CloudEvents 1.0, in the structured JSON content mode.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// SpecVersion is the CloudEvents version of every event.
+	SpecVersion = "1.0"
+	// ContentType marks a message body as a structured-mode CloudEvent.
+	ContentType = "application/cloudevents+json"
+)
+
+// Event types.
+const (
+	TypeFileUploaded   = "file.uploaded"
+	TypeFileDownloaded = "file.downloaded"
+	TypeFileDeleted    = "file.deleted"
+)
+
+// Event is a CloudEvent about one file. Tenant is carried as the "tenant"
+// extension attribute.
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype,omitempty"`
+	Tenant          string    `json:"tenant,omitempty"`
+	Data            File      `json:"data"`
+}
+
+// File is the data of an event.
+type File struct {
+	Name        string            `json:"name"`
+	Size        int64             `json:"size,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Checksum    string            `json:"checksum,omitempty"`
+	Actor       string            `json:"actor,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// Range is the byte range served by a partial download.
+	Range string `json:"range,omitempty"`
+	// Bundle is set when a file was downloaded as part of a bundle.
+	Bundle bool `json:"bundle,omitempty"`
+}
+
+// New returns an event of type typ about f, with a fresh ID. The file name
+// is used as the subject.
+func New(source, typ, tenant string, f File) Event {
+	return Event{
+		SpecVersion:     SpecVersion,
+		ID:              newID(),
+		Source:          source,
+		Type:            typ,
+		Subject:         f.Name,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Tenant:          tenant,
+		Data:            f,
+	}
+}
+
+// Encode returns the structured-mode JSON form of e.
+func (e Event) Encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Publisher sends events to a message bus. Publish must not wait for the
+// bus to acknowledge the event; delivery errors are the publisher's to log.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+	Close() error
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,48 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"stream-upload-file/pkg/events"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvent_Encode(t *testing.T) {
+	e := events.New("/files", events.TypeFileUploaded, "finance", events.File{Name: "q3.csv", Size: 12, Checksum: "sha256:ab"})
+	body, err := e.Encode()
+	require.NoError(t, err)
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, "1.0", got["specversion"])
+	assert.Equal(t, "file.uploaded", got["type"])
+	assert.Equal(t, "/files", got["source"])
+	assert.Equal(t, "q3.csv", got["subject"])
+	assert.Equal(t, "finance", got["tenant"])
+	assert.Len(t, got["id"], 32)
+	assert.NotEmpty(t, got["time"])
+	assert.Equal(t, map[string]any{"name": "q3.csv", "size": float64(12), "checksum": "sha256:ab"}, got["data"])
+
+	assert.NotEqual(t, e.ID, events.New("/files", events.TypeFileUploaded, "", events.File{}).ID)
+}
+
+func TestMemory(t *testing.T) {
+	m := events.NewMemory()
+	sub := m.Subscribe(1)
+
+	ctx := context.Background()
+	require.NoError(t, m.Publish(ctx, events.New("/files", events.TypeFileDeleted, "", events.File{Name: "a"})))
+	require.NoError(t, m.Publish(ctx, events.New("/files", events.TypeFileDeleted, "", events.File{Name: "b"})))
+
+	assert.Len(t, m.Events(), 2)
+	// The second event is dropped for the full subscription.
+	assert.Equal(t, "a", (<-sub).Subject)
+
+	require.NoError(t, m.Close())
+	_, open := <-sub
+	assert.False(t, open)
+}
@@ -0,0 +1,107 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"go.uber.org/zap"
+)
+
+var ErrClosed = errors.New("publisher is closed")
+
+// Kafka publishes events to one topic. Messages are keyed by tenant and file
+// name, so the events of a file stay in order on one partition.
+type Kafka struct {
+	producer sarama.AsyncProducer
+	topic    string
+	logger   *zap.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewKafka creates a producer for the given brokers. Events are sent
+// asynchronously and acknowledged by all in-sync replicas; failures after
+// the producer's retries are logged.
+func NewKafka(brokers []string, topic string) (*Kafka, error) {
+	cfg := sarama.NewConfig()
+	cfg.ClientID = "stream-upload-file"
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Return.Errors = true
+	producer, err := sarama.NewAsyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewKafkaProducer(producer, topic), nil
+}
+
+// NewKafkaProducer publishes through an existing producer, which must
+// return errors. The Kafka publisher takes ownership of it.
+func NewKafkaProducer(producer sarama.AsyncProducer, topic string) *Kafka {
+	k := &Kafka{
+		producer: producer,
+		topic:    topic,
+		logger:   zap.L().Named("events-kafka"),
+		done:     make(chan struct{}),
+	}
+	go k.logErrors()
+	return k
+}
+
+func (k *Kafka) logErrors() {
+	defer close(k.done)
+	for err := range k.producer.Errors() {
+		k.logger.Error("Failed to publish event",
+			zap.String("topic", err.Msg.Topic),
+			zap.Any("key", err.Msg.Key),
+			zap.Error(err.Err),
+		)
+	}
+}
+
+// Publish implements Publisher. It only blocks while the producer's input
+// buffer is full.
+func (k *Kafka) Publish(ctx context.Context, e Event) error {
+	body, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: k.topic,
+		Key:   sarama.StringEncoder(e.Tenant + "/" + e.Subject),
+		Value: sarama.ByteEncoder(body),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("content-type"), Value: []byte(ContentType)},
+		},
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return ErrClosed
+	}
+	select {
+	case k.producer.Input() <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close implements Publisher. Buffered events are sent first.
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return nil
+	}
+	k.closed = true
+	k.mu.Unlock()
+
+	k.producer.AsyncClose()
+	<-k.done
+	return nil
+}
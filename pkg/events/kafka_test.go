@@ -0,0 +1,71 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/events"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafka_Publish(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, nil)
+	e := events.New("/files", events.TypeFileDownloaded, "finance", events.File{Name: "q3.csv"})
+	producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		key, _ := msg.Key.Encode()
+		if msg.Topic != "file-events" || string(key) != "finance/q3.csv" {
+			return errors.New("unexpected topic or key")
+		}
+		if len(msg.Headers) != 1 || string(msg.Headers[0].Value) != events.ContentType {
+			return errors.New("missing content-type header")
+		}
+		value, _ := msg.Value.Encode()
+		var got events.Event
+		if err := json.Unmarshal(value, &got); err != nil || got.ID != e.ID {
+			return errors.New("unexpected value")
+		}
+		return nil
+	})
+	producer.ExpectInputAndFail(sarama.ErrNotLeaderForPartition)
+
+	k := events.NewKafkaProducer(producer, "file-events")
+	require.NoError(t, k.Publish(context.Background(), e))
+	// Delivery failures are logged, not returned.
+	require.NoError(t, k.Publish(context.Background(), e))
+	require.NoError(t, k.Close())
+
+	assert.ErrorIs(t, k.Publish(context.Background(), e), events.ErrClosed)
+	assert.NoError(t, k.Close())
+}
+
+func TestKafka_Broker(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("file-events", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+
+	k, err := events.NewKafka([]string{broker.Addr()}, "file-events")
+	require.NoError(t, err)
+	require.NoError(t, k.Publish(context.Background(), events.New("/files", events.TypeFileDeleted, "", events.File{Name: "a"})))
+	require.NoError(t, k.Close())
+
+	assert.Eventually(t, func() bool {
+		for _, rr := range broker.History() {
+			if _, ok := rr.Request.(*sarama.ProduceRequest); ok {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+}
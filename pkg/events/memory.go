@@ -0,0 +1,61 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps published events in memory. It is meant for tests and local
+// development.
+type Memory struct {
+	mu     sync.Mutex
+	events []Event
+	subs   []chan Event
+}
+
+// NewMemory creates an empty in-memory publisher.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Publish implements Publisher.
+func (m *Memory) Publish(_ context.Context, e Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, e)
+	for _, ch := range m.subs {
+		// Slow subscribers miss events rather than block publishers.
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	return nil
+}
+
+// Events returns the events published so far.
+func (m *Memory) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Event(nil), m.events...)
+}
+
+// Subscribe returns a channel receiving events published from now on.
+func (m *Memory) Subscribe(buffer int) <-chan Event {
+	ch := make(chan Event, buffer)
+	m.mu.Lock()
+	m.subs = append(m.subs, ch)
+	m.mu.Unlock()
+	return ch
+}
+
+// Close implements Publisher and closes every subscription.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.subs {
+		close(ch)
+	}
+	m.subs = nil
+	return nil
+}
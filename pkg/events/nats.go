@@ -0,0 +1,75 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// natsFlushTimeout bounds how long Close waits for buffered events.
+const natsFlushTimeout = 5 * time.Second
+
+// NATS publishes events on subjects named "<prefix>.<type>", e.g.
+// "files.file.uploaded". Capture the subjects in a JetStream stream when
+// consumers must not miss events while they are offline.
+type NATS struct {
+	conn   *nats.Conn
+	prefix string
+	logger *zap.Logger
+}
+
+// NewNATS connects to the NATS server at url. The connection reconnects
+// indefinitely; events published while disconnected are buffered by the
+// client up to its reconnect buffer size.
+func NewNATS(url, prefix string, opts ...nats.Option) (*NATS, error) {
+	logger := zap.L().Named("events-nats")
+	opts = append([]nats.Option{
+		nats.Name("stream-upload-file"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			logger.Warn("Disconnected from NATS", zap.Error(err))
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Info("Reconnected to NATS", zap.String("url", nc.ConnectedUrlRedacted()))
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			logger.Error("NATS error", zap.Error(err))
+		}),
+	}, opts...)
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{conn: conn, prefix: prefix, logger: logger}, nil
+}
+
+// Subject returns the subject events of type typ are published on.
+func (n *NATS) Subject(typ string) string {
+	return n.prefix + "." + typ
+}
+
+// Publish implements Publisher.
+func (n *NATS) Publish(_ context.Context, e Event) error {
+	body, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(n.Subject(e.Type))
+	msg.Header.Set("Content-Type", ContentType)
+	msg.Data = body
+	return n.conn.PublishMsg(msg)
+}
+
+// Ping round-trips to the server. ctx must have a deadline.
+func (n *NATS) Ping(ctx context.Context) error {
+	return n.conn.FlushWithContext(ctx)
+}
+
+// Close implements Publisher. Buffered events are flushed first.
+func (n *NATS) Close() error {
+	err := n.conn.FlushTimeout(natsFlushTimeout)
+	n.conn.Close()
+	return err
+}
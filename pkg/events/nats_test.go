@@ -0,0 +1,72 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/events"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runNATSServer(t *testing.T) string {
+	t.Helper()
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	require.NoError(t, err)
+	go s.Start()
+	require.True(t, s.ReadyForConnections(5*time.Second))
+	t.Cleanup(s.Shutdown)
+	return s.ClientURL()
+}
+
+func TestNATS_Publish(t *testing.T) {
+	url := runNATSServer(t)
+
+	sub, err := nats.Connect(url)
+	require.NoError(t, err)
+	defer sub.Close()
+	msgs := make(chan *nats.Msg, 4)
+	_, err = sub.ChanSubscribe("files.>", msgs)
+	require.NoError(t, err)
+	require.NoError(t, sub.Flush())
+
+	p, err := events.NewNATS(url, "files")
+	require.NoError(t, err)
+	e := events.New("/files", events.TypeFileUploaded, "finance", events.File{Name: "q3.csv", Size: 12})
+	require.NoError(t, p.Publish(context.Background(), e))
+	require.NoError(t, p.Close())
+
+	select {
+	case msg := <-msgs:
+		assert.Equal(t, "files.file.uploaded", msg.Subject)
+		assert.Equal(t, events.ContentType, msg.Header.Get("Content-Type"))
+		var got events.Event
+		require.NoError(t, json.Unmarshal(msg.Data, &got))
+		assert.Equal(t, e.ID, got.ID)
+		assert.Equal(t, "finance", got.Tenant)
+		assert.Equal(t, int64(12), got.Data.Size)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not received")
+	}
+}
+
+func TestNATS_ConnectError(t *testing.T) {
+	_, err := events.NewNATS("nats://127.0.0.1:1", "files", nats.Timeout(100*time.Millisecond))
+	assert.Error(t, err)
+}
+
+func TestNATS_Ping(t *testing.T) {
+	p, err := events.NewNATS(runNATSServer(t), "files")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, p.Ping(ctx))
+	require.NoError(t, p.Close())
+	assert.Error(t, p.Ping(ctx))
+}
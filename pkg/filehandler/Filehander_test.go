@@ -1,17 +1,46 @@
 package filehandler_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+	"unsafe"
 
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/events"
 	"stream-upload-file/pkg/filehandler"
+	"stream-upload-file/pkg/policy"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+	"stream-upload-file/pkg/tenant"
+	"stream-upload-file/pkg/webhook"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MockStorageClient implements the StorageClient interface for testing
@@ -21,6 +50,9 @@ type MockStorageClient struct {
 	uploadErr      error
 	downloadResp   *azblob.DownloadStreamResponse
 	downloadErr    error
+	listItems      []*container.BlobItem
+	listErr        error
+	deleteErr      error
 }
 
 func (m *MockStorageClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
@@ -33,6 +65,23 @@ func (m *MockStorageClient) DownloadBlob(ctx context.Context, blobName string) (
 	return m.downloadResp, m.downloadErr
 }
 
+func (m *MockStorageClient) DownloadBlobRange(ctx context.Context, blobName string, offset, count int64) (*azblob.DownloadStreamResponse, error) {
+	m.downloadCalled = true
+	return m.downloadResp, m.downloadErr
+}
+
+func (m *MockStorageClient) ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error) {
+	return m.listItems, m.listErr
+}
+
+func (m *MockStorageClient) DeleteBlob(ctx context.Context, blobName string) error {
+	return m.deleteErr
+}
+
+func (m *MockStorageClient) GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error) {
+	return nil, storage.ErrBlobNotFound
+}
+
 func TestNewAzureFileHandler_InitializesFields(t *testing.T) {
 	mockClient := &MockStorageClient{}
 	handler := filehandler.NewAzureFileHandler(mockClient)
@@ -45,7 +94,7 @@ func TestNewAzureFileHandler_InitializesFields(t *testing.T) {
 // Helper function to access unexported fields using reflection
 func getUnexportedField(obj interface{}, field string) interface{} {
 	val := reflect.ValueOf(obj).Elem().FieldByName(field)
-	return val.Interface()
+	return reflect.NewAt(val.Type(), unsafe.Pointer(val.UnsafeAddr())).Elem().Interface()
 }
 
 func TestStorageClient_UploadBlob_Called(t *testing.T) {
@@ -81,3 +130,534 @@ func TestStorageClient_DownloadBlob_Error(t *testing.T) {
 	assert.Nil(t, resp)
 	assert.EqualError(t, err, "not found")
 }
+
+func TestAuthorizer_TeamPrefixes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	policyFile := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(policyFile, []byte(`{
+		"roles": {"team-x": {"operations": ["upload", "download", "list", "delete"], "paths": ["team-x/**"]}},
+		"bindings": [{"role": "team-x", "subjects": ["group:team-x"]}]
+	}`), 0o600))
+	engine, err := policy.Load(policyFile)
+	require.NoError(t, err)
+
+	client := newMemStorageClient(map[string]string{"team-x/a.txt": "a", "team-y/b.txt": "b"})
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithAuthorizer(engine))
+	router := gin.New()
+	router.Use(withIdentity(&auth.Identity{ID: "alice", Groups: []string{"team-x"}}))
+	router.POST("/upload", h.UploadHandler(""))
+	router.GET("/download/*filename", h.DownloadHandler(""))
+	router.GET("/files", h.ListHandler(""))
+	router.DELETE("/files/*filename", h.DeleteHandler(""))
+
+	upload := func(dir string) int {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		require.NoError(t, mw.WriteField("path", dir))
+		part, err := mw.CreateFormFile("file", "new file.txt")
+		require.NoError(t, err)
+		part.Write([]byte("new"))
+		mw.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, upload("team-x/reports"))
+	assert.Contains(t, client.blobs, "team-x/reports/new_file.txt")
+	assert.Equal(t, http.StatusForbidden, upload("team-y"))
+	// Dot segments are dropped rather than resolved, so this stays in team-x/.
+	assert.Equal(t, http.StatusOK, upload("team-x/../team-y"))
+	assert.Contains(t, client.blobs, "team-x/team-y/new_file.txt")
+
+	for target, want := range map[string]int{
+		"/download/team-x/a.txt":    http.StatusOK,
+		"/download/team-y/b.txt":    http.StatusForbidden,
+		"/download/../team-y/b.txt": http.StatusForbidden,
+		"/files?prefix=team-y/":     http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, want, w.Code, target)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var listing struct {
+		Files []filehandler.FileInfo `json:"files"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listing))
+	names := make([]string, 0, len(listing.Files))
+	for _, f := range listing.Files {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"team-x/a.txt", "team-x/reports/new_file.txt", "team-x/team-y/new_file.txt"}, names)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/files/team-y/b.txt", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, client.blobs, "team-y/b.txt")
+}
+
+// tenantStores hands out one in-memory store per tenant.
+type tenantStores map[string]*memStorageClient
+
+func (s tenantStores) Store(t *tenant.Tenant) (storage.BlobStore, error) {
+	store, ok := s[t.Name]
+	if !ok {
+		return nil, errors.New("tenant storage unavailable")
+	}
+	return store, nil
+}
+
+func withTenant(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant.SetTenant(c, &tenant.Tenant{Name: name})
+	}
+}
+
+func TestTenantStores_IsolateTenants(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	stores := tenantStores{
+		"finance": newMemStorageClient(map[string]string{"report.csv": "finance numbers"}),
+		"hr":      newMemStorageClient(map[string]string{"report.csv": "hr numbers"}),
+	}
+	h := filehandler.NewAzureFileHandler(newMemStorageClient(nil), filehandler.WithTenantStores(stores))
+	router := gin.New()
+	for _, name := range []string{"finance", "hr", "broken"} {
+		router.GET("/"+name+"/download/*filename", withTenant(name), h.DownloadHandler(""))
+	}
+
+	for target, want := range map[string]string{
+		"/finance/download/report.csv": "finance numbers",
+		"/hr/download/report.csv":      "hr numbers",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, http.StatusOK, w.Code, target)
+		assert.Equal(t, want, w.Body.String(), target)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/broken/download/report.csv", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// countingThrottle records which tenants' transfers went through it.
+type countingThrottle struct {
+	readers, writers []string
+}
+
+func (t *countingThrottle) Reader(ctx context.Context, tenant string, r io.Reader) io.Reader {
+	t.readers = append(t.readers, tenant)
+	return r
+}
+
+func (t *countingThrottle) Writer(ctx context.Context, tenant string, w io.Writer) io.Writer {
+	t.writers = append(t.writers, tenant)
+	return w
+}
+
+func TestThrottle_AppliedToTransfers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	throttle := &countingThrottle{}
+	client := newMemStorageClient(map[string]string{"a.txt": "alpha"})
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithThrottle(throttle))
+	router := gin.New()
+	router.Use(withTenant("batch"))
+	router.POST("/upload", h.UploadHandler(""))
+	router.GET("/download/*filename", h.DownloadHandler(""))
+	router.POST("/download/bundle", h.BundleHandler(""))
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	part, err := mw.CreateFormFile("file", "b.txt")
+	require.NoError(t, err)
+	part.Write([]byte("bravo"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/download/a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alpha", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/download/bundle", strings.NewReader(`{"files":["a.txt","b.txt"]}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, []string{"batch", "batch"}, throttle.readers)
+	assert.Equal(t, []string{"batch"}, throttle.writers)
+}
+
+// auditSink keeps audit events in memory.
+type auditSink []*audit.Event
+
+func (s *auditSink) Write(e *audit.Event) error {
+	*s = append(*s, e)
+	return nil
+}
+
+func TestAudit_RecordsTransfers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sink := &auditSink{}
+	log := audit.New(sink)
+	h := filehandler.NewAzureFileHandler(newMemStorageClient(nil))
+	router := gin.New()
+	router.POST("/upload", log.Middleware(audit.ActionUpload), h.UploadHandler(""))
+	router.GET("/download/*filename", log.Middleware(audit.ActionDownload), h.DownloadHandler(""))
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	require.NoError(t, mw.WriteField("path", "docs"))
+	part, err := mw.CreateFormFile("file", "a b.txt")
+	require.NoError(t, err)
+	part.Write([]byte("audited"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/download/docs/a_b.txt", nil))
+
+	sum := sha256.Sum256([]byte("audited"))
+	require.Len(t, *sink, 2)
+	for _, e := range *sink {
+		assert.Equal(t, audit.OutcomeSuccess, e.Outcome, e.Action)
+		assert.Equal(t, "docs/a_b.txt", e.Object, e.Action)
+		assert.Equal(t, int64(7), e.Size, e.Action)
+		assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), e.Checksum, e.Action)
+	}
+}
+
+// webhookRecorder collects published webhook events.
+type webhookRecorder []webhook.Event
+
+func (r *webhookRecorder) Publish(e webhook.Event) {
+	*r = append(*r, e)
+}
+
+func TestWebhooks_PublishedOnUpload(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	events := &webhookRecorder{}
+	client := newMemStorageClient(nil)
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithWebhooks(events))
+	router := gin.New()
+	router.Use(withTenant("finance"), withIdentity(&auth.Identity{ID: "etl"}))
+	router.POST("/upload", h.UploadHandler(""))
+
+	req, _ := createMultipartRequest(t, "file", "q3.csv", "a,b\n1,2\n")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	client.uploadErr = errors.New("storage down")
+	req, _ = createMultipartRequest(t, "file", "q4.csv", "x")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, *events, 1, "failed uploads are not announced")
+	e := (*events)[0]
+	sum := sha256.Sum256([]byte("a,b\n1,2\n"))
+	assert.Equal(t, webhook.EventFileUploaded, e.Type)
+	assert.Equal(t, "finance", e.Tenant)
+	assert.Equal(t, "q3.csv", e.File.Name)
+	assert.Equal(t, int64(8), e.File.Size)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), e.File.Checksum)
+	assert.Equal(t, "etl", e.File.Metadata["uploadedBy"])
+}
+
+func TestEvents_PublishedForFileLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bus := events.NewMemory()
+	h := filehandler.NewAzureFileHandler(newMemStorageClient(nil), filehandler.WithEvents(bus, "/files"))
+	router := gin.New()
+	router.Use(withTenant("finance"), withIdentity(&auth.Identity{ID: "etl"}))
+	router.POST("/upload", h.UploadHandler(""))
+	router.GET("/download/*filename", h.DownloadHandler(""))
+	router.DELETE("/files/*filename", h.DeleteHandler(""))
+
+	req, _ := createMultipartRequest(t, "file", "q3.csv", "a,b\n1,2\n")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/download/q3.csv", nil))
+	ranged := httptest.NewRequest("GET", "/download/q3.csv", nil)
+	ranged.Header.Set("Range", "bytes=0-2")
+	router.ServeHTTP(httptest.NewRecorder(), ranged)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/files/q3.csv", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/download/q3.csv", nil))
+
+	published := bus.Events()
+	require.Len(t, published, 4, "failed downloads are not announced")
+	var types []string
+	for _, e := range published {
+		types = append(types, e.Type)
+		assert.Equal(t, "/files", e.Source)
+		assert.Equal(t, "finance", e.Tenant)
+		assert.Equal(t, "q3.csv", e.Subject)
+		assert.Equal(t, "etl", e.Data.Actor)
+	}
+	assert.Equal(t, []string{events.TypeFileUploaded, events.TypeFileDownloaded, events.TypeFileDownloaded, events.TypeFileDeleted}, types)
+	assert.Equal(t, int64(8), published[1].Data.Size)
+	assert.Equal(t, published[0].Data.Checksum, published[1].Data.Checksum)
+	assert.Equal(t, "bytes 0-2/8", published[2].Data.Range)
+}
+
+// blockedBus never accepts an event, like a bus whose buffer is full.
+type blockedBus struct{}
+
+func (blockedBus) Publish(ctx context.Context, e events.Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestEvents_BlockedBusDoesNotHoldUpResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	timeouts := filehandler.DefaultTimeouts
+	timeouts.Publish = 20 * time.Millisecond
+	h := filehandler.NewAzureFileHandler(newMemStorageClient(nil), filehandler.WithEvents(blockedBus{}, "/files"), filehandler.WithTimeouts(timeouts))
+	router := gin.New()
+	router.POST("/upload", h.UploadHandler(""))
+
+	req, _ := createMultipartRequest(t, "file", "q3.csv", "a,b\n1,2\n")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(w, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+		assert.Equal(t, http.StatusOK, w.Code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload is still waiting on the event bus")
+	}
+}
+
+// spanCapturingClient records the span active in the context of each upload.
+type spanCapturingClient struct {
+	*memStorageClient
+	spans []trace.SpanContext
+}
+
+func (s *spanCapturingClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
+	s.spans = append(s.spans, trace.SpanFromContext(ctx).SpanContext())
+	return s.memStorageClient.UploadBlob(ctx, blobName, data, options)
+}
+
+func TestTracing_HandlerSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(prev)
+
+	gin.SetMode(gin.TestMode)
+	client := &spanCapturingClient{memStorageClient: newMemStorageClient(nil)}
+	h := filehandler.NewAzureFileHandler(client)
+	router := gin.New()
+	router.POST("/upload", h.UploadHandler(""))
+	router.GET("/download/*filename", h.DownloadHandler(""))
+
+	req, _ := createMultipartRequest(t, "file", "q3.csv", "a,b\n1,2\n")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	client.uploadErr = errors.New("storage down")
+	req, _ = createMultipartRequest(t, "file", "q4.csv", "x")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/download/q3.csv", nil))
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 3)
+	upload, failed, download := ended[0], ended[1], ended[2]
+	assert.Equal(t, "UploadHandler", upload.Name())
+	assert.Equal(t, codes.Unset, upload.Status().Code)
+	assert.Contains(t, upload.Attributes(), attribute.String("file.name", "q3.csv"))
+	assert.Contains(t, upload.Attributes(), attribute.Int64("file.size", 8))
+	assert.Equal(t, codes.Error, failed.Status().Code)
+	assert.Equal(t, "DownloadHandler", download.Name())
+	assert.Contains(t, download.Attributes(), attribute.Int64("file.bytes_sent", 8))
+
+	// Storage calls run inside the handler span.
+	require.Len(t, client.spans, 2)
+	assert.Equal(t, upload.SpanContext().SpanID(), client.spans[0].SpanID())
+}
+
+// requestIDCapturingClient records the request ID in the context of each upload.
+type requestIDCapturingClient struct {
+	*memStorageClient
+	ids []string
+}
+
+func (r *requestIDCapturingClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
+	r.ids = append(r.ids, requestid.FromContext(ctx))
+	return r.memStorageClient.UploadBlob(ctx, blobName, data, options)
+}
+
+func TestRequestID_PropagatedToStorageAndErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := &requestIDCapturingClient{memStorageClient: newMemStorageClient(nil)}
+	h := filehandler.NewAzureFileHandler(client)
+	router := gin.New()
+	router.Use(requestid.Middleware())
+	router.POST("/upload", h.UploadHandler(""))
+	router.GET("/download/*filename", h.DownloadHandler(""))
+
+	req, _ := createMultipartRequest(t, "file", "q3.csv", "a,b\n1,2\n")
+	req.Header.Set(requestid.Header, "req-upload")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, []string{"req-upload"}, client.ids)
+
+	req = httptest.NewRequest("GET", "/download/missing.csv", nil)
+	req.Header.Set(requestid.Header, "req-missing")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "req-missing", body["requestId"])
+	assert.NotEmpty(t, body["error"])
+}
+
+// stagingClient behaves like Blob Storage: uploads are staged block by
+// block and only become visible once the last block is committed, and
+// downloads stream block by block. afterFirstBlock runs once the first
+// block has been staged or sent.
+type stagingClient struct {
+	*memStorageClient
+	afterFirstBlock func(ctx context.Context)
+}
+
+const stagingBlockSize = 4
+
+func (s *stagingClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
+	var staged []byte
+	block := make([]byte, stagingBlockSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(data, block)
+		staged = append(staged, block[:n]...)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(staged) == stagingBlockSize {
+			s.afterFirstBlock(ctx)
+		}
+	}
+	return s.memStorageClient.UploadBlob(ctx, blobName, bytes.NewReader(staged), options)
+}
+
+func (s *stagingClient) DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error) {
+	resp, err := s.memStorageClient.DownloadBlob(ctx, blobName)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(&blockReader{ctx: ctx, r: resp.Body, afterFirstBlock: s.afterFirstBlock})
+	return resp, nil
+}
+
+type blockReader struct {
+	ctx             context.Context
+	r               io.Reader
+	blocks          int
+	afterFirstBlock func(ctx context.Context)
+}
+
+func (b *blockReader) Read(p []byte) (int, error) {
+	if b.blocks == 1 {
+		b.afterFirstBlock(b.ctx)
+	}
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+	b.blocks++
+	return b.r.Read(p[:min(len(p), stagingBlockSize)])
+}
+
+func TestUpload_ClientCancelLeavesNoObject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bus := events.NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &stagingClient{
+		memStorageClient: newMemStorageClient(nil),
+		afterFirstBlock:  func(context.Context) { cancel() },
+	}
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithEvents(bus, "/files"))
+	router := gin.New()
+	router.POST("/upload", h.UploadHandler(""))
+
+	req, _ := createMultipartRequest(t, "file", "big.bin", "0123456789abcdef")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req.WithContext(ctx))
+
+	assert.Equal(t, 499, w.Code)
+	assert.Empty(t, client.blobs, "a cancelled upload must not be committed")
+	assert.Empty(t, bus.Events())
+}
+
+func TestUpload_DeadlineLeavesNoObject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := &stagingClient{
+		memStorageClient: newMemStorageClient(nil),
+		afterFirstBlock:  func(ctx context.Context) { <-ctx.Done() },
+	}
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithTimeouts(filehandler.Timeouts{Upload: 20 * time.Millisecond}))
+	router := gin.New()
+	router.POST("/upload", h.UploadHandler(""))
+
+	req, _ := createMultipartRequest(t, "file", "big.bin", "0123456789abcdef")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "timed out")
+	assert.Empty(t, client.blobs)
+}
+
+func TestDownload_ClientCancelStopsStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bus := events.NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &stagingClient{
+		memStorageClient: newMemStorageClient(map[string]string{"big.bin": "0123456789abcdef"}),
+		afterFirstBlock:  func(context.Context) { cancel() },
+	}
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithEvents(bus, "/files"))
+	router := gin.New()
+	router.GET("/download/*filename", h.DownloadHandler(""))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/download/big.bin", nil).WithContext(ctx))
+
+	assert.Equal(t, "0123", w.Body.String(), "the storage stream stops with the request")
+	assert.Empty(t, bus.Events(), "an unfinished download is not announced")
+}
+
+func TestList_DeadlineReturnsGatewayTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := filehandler.NewAzureFileHandler(&blockingListClient{MockStorageClient: &MockStorageClient{}},
+		filehandler.WithTimeouts(filehandler.Timeouts{Operation: 20 * time.Millisecond}))
+	router := gin.New()
+	router.GET("/files", h.ListHandler(""))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+// blockingListClient lists only once ctx is done.
+type blockingListClient struct {
+	*MockStorageClient
+}
+
+func (b *blockingListClient) ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
@@ -2,25 +2,391 @@ package filehandler
 
 import (
 	"context"
+	"errors"
 	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/events"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+	"stream-upload-file/pkg/tenant"
+	"stream-upload-file/pkg/tracing"
+	"stream-upload-file/pkg/webhook"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 type StorageClient interface {
 	UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error
 	DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error)
+	DownloadBlobRange(ctx context.Context, blobName string, offset, count int64) (*azblob.DownloadStreamResponse, error)
+	ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error)
+	DeleteBlob(ctx context.Context, blobName string) error
+	GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error)
 }
 
 type azureFileHandler struct {
-	logger        *zap.Logger
-	storageClient StorageClient
+	logger         *zap.Logger
+	storageClient  StorageClient
+	presigner      *presign.Signer
+	directUploader DirectUploader
+	authorizer     Authorizer
+	tenantStores   TenantStores
+	usage          UsageTracker
+	throttle       Throttler
+	webhooks       WebhookPublisher
+	events         EventPublisher
+	eventSource    string
+	timeouts       Timeouts
+	maxUploadSize  atomic.Int64
+}
+
+// Option configures optional behaviour of the file handler.
+type Option func(*azureFileHandler)
+
+// WithPresigner enables presigned upload and download URLs signed by signer.
+func WithPresigner(signer *presign.Signer) Option {
+	return func(a *azureFileHandler) {
+		a.presigner = signer
+	}
+}
+
+// Authorizer makes per-object access decisions on top of the scopes carried
+// by the caller's credential. policy.Engine implements it.
+type Authorizer interface {
+	// Authorize decides an operation on one blob and records the decision.
+	Authorize(id *auth.Identity, operation, name string) bool
+	// AuthorizePrefix decides an operation over the blobs under a prefix.
+	AuthorizePrefix(id *auth.Identity, operation, prefix string) bool
+	// Allows is Authorize without recording the decision, used to filter
+	// listings.
+	Allows(id *auth.Identity, operation, name string) bool
+}
+
+// WithAuthorizer checks every upload, download, list and delete against authz.
+func WithAuthorizer(authz Authorizer) Option {
+	return func(a *azureFileHandler) {
+		a.authorizer = authz
+	}
+}
+
+// TenantStores provides the storage of each tenant. tenant.Stores implements it.
+type TenantStores interface {
+	Store(t *tenant.Tenant) (storage.BlobStore, error)
+}
+
+// WithTenantStores serves each request from the storage of the tenant
+// resolved by tenant.Middleware. Requests without a tenant use the
+// handler's default client.
+func WithTenantStores(stores TenantStores) Option {
+	return func(a *azureFileHandler) {
+		a.tenantStores = stores
+	}
+}
+
+// Throttler limits the bandwidth of transfers. throttle.Throttle implements it.
+type Throttler interface {
+	Reader(ctx context.Context, tenant string, r io.Reader) io.Reader
+	Writer(ctx context.Context, tenant string, w io.Writer) io.Writer
+}
+
+// WithThrottle limits the bandwidth of uploads, downloads and bundles.
+func WithThrottle(t Throttler) Option {
+	return func(a *azureFileHandler) {
+		a.throttle = t
+	}
+}
+
+// WebhookPublisher sends notifications about stored files.
+// webhook.Dispatcher implements it.
+type WebhookPublisher interface {
+	Publish(e webhook.Event)
+}
+
+// WithWebhooks announces every completed upload through p.
+func WithWebhooks(p WebhookPublisher) Option {
+	return func(a *azureFileHandler) {
+		a.webhooks = p
+	}
+}
+
+// EventPublisher puts file lifecycle events on a message bus. The
+// publishers in package events implement it.
+type EventPublisher interface {
+	Publish(ctx context.Context, e events.Event) error
+}
+
+// WithEvents publishes a CloudEvent for every upload, download and delete.
+// source is the CloudEvents source attribute identifying this service.
+func WithEvents(p EventPublisher, source string) Option {
+	return func(a *azureFileHandler) {
+		a.events = p
+		a.eventSource = source
+	}
+}
+
+// Timeouts bound the storage and event bus work done for one request. Zero
+// means no deadline; storage work still stops when the client goes away.
+type Timeouts struct {
+	// Upload covers checking quota for and storing an upload.
+	Upload time.Duration
+	// Download covers a download or bundle, including streaming it to the
+	// client.
+	Download time.Duration
+	// Operation covers every other storage call, such as listing, deleting
+	// and reading or writing properties.
+	Operation time.Duration
+	// Publish covers handing an event to the bus, which blocks while the
+	// bus is backed up. Events that can't be handed over in time are
+	// dropped and logged rather than holding up the response.
+	Publish time.Duration
 }
 
-func NewAzureFileHandler(client StorageClient) *azureFileHandler {
-	return &azureFileHandler{
+// DefaultTimeouts leave room for a 100MB upload or a throttled download of
+// a large file over a slow link.
+var DefaultTimeouts = Timeouts{
+	Upload:    15 * time.Minute,
+	Download:  time.Hour,
+	Operation: 30 * time.Second,
+	Publish:   5 * time.Second,
+}
+
+// WithTimeouts replaces DefaultTimeouts.
+func WithTimeouts(t Timeouts) Option {
+	return func(a *azureFileHandler) {
+		a.timeouts = t
+	}
+}
+
+func NewAzureFileHandler(client StorageClient, opts ...Option) *azureFileHandler {
+	a := &azureFileHandler{
 		logger:        zap.L().Named("azure-file-handler"),
 		storageClient: client,
+		timeouts:      DefaultTimeouts,
+	}
+	a.maxUploadSize.Store(MaxUploadSize)
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// log returns the handler's logger with the request's ID attached.
+func (a *azureFileHandler) log(c *gin.Context) *zap.Logger {
+	return requestid.Logger(c, a.logger)
+}
+
+// authorize checks that the caller may perform scope on name and responds
+// with 403 if not. Without an authorizer, requests without an identity are
+// let through: either authentication is disabled or the request is
+// presigned, which the handler verifies itself. Staged direct uploads are
+// never accessible by name.
+func (a *azureFileHandler) authorize(c *gin.Context, scope, name string) bool {
+	return a.check(c, scope, name, func(id *auth.Identity) bool {
+		return a.authorizer.Authorize(id, scope, name)
+	})
+}
+
+// authorizePrefix is authorize for operations over every blob under prefix.
+// Results must still be filtered with visible.
+func (a *azureFileHandler) authorizePrefix(c *gin.Context, scope, prefix string) bool {
+	return a.check(c, scope, prefix, func(id *auth.Identity) bool {
+		return a.authorizer.AuthorizePrefix(id, scope, prefix)
+	})
+}
+
+func (a *azureFileHandler) check(c *gin.Context, scope, name string, policy func(*auth.Identity) bool) bool {
+	id, ok := auth.FromContext(c)
+	allowed := !storage.IsDirectUploadName(name) && (!ok || id.Allows(scope, name))
+	if allowed && a.authorizer != nil {
+		allowed = policy(id)
+	}
+	if allowed {
+		return true
+	}
+	subject := "anonymous"
+	if ok {
+		subject = id.ID
+	}
+	a.log(c).Warn("Permission denied",
+		zap.String("identity", subject),
+		zap.String("scope", scope),
+		zap.String("name", name),
+	)
+	c.JSON(http.StatusForbidden, requestid.Error(c, "Permission denied"))
+	return false
+}
+
+// visible reports whether a blob found by a prefix operation may be shown
+// to the caller.
+func (a *azureFileHandler) visible(c *gin.Context, scope, name string) bool {
+	if storage.IsDirectUploadName(name) {
+		return false
+	}
+	if a.authorizer == nil {
+		return true
+	}
+	id, _ := auth.FromContext(c)
+	return a.authorizer.Allows(id, scope, name)
+}
+
+// store returns the storage for the request's tenant and responds with 503
+// if it can't be created.
+func (a *azureFileHandler) store(c *gin.Context) (StorageClient, bool) {
+	t, ok := tenant.FromContext(c)
+	if !ok || a.tenantStores == nil {
+		return a.storageClient, true
+	}
+	s, err := a.tenantStores.Store(t)
+	if err != nil {
+		a.log(c).Error("Failed to open tenant storage", zap.String("tenant", t.Name), zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, requestid.Error(c, "Storage unavailable"))
+		return nil, false
+	}
+	return s, true
+}
+
+// storageContext is the context for storage calls made on behalf of c. It
+// carries the request's trace and ID, and is cancelled when the client goes
+// away or timeout passes.
+func storageContext(c *gin.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return withTimeout(c.Request.Context(), timeout)
+}
+
+// cleanupContext is for storage calls that must finish even if the client
+// has gone away, such as deleting a rejected upload.
+func (a *azureFileHandler) cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(context.WithoutCancel(ctx), a.timeouts.Operation)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// statusClientClosedRequest is recorded for requests abandoned by the
+// client, as nginx does. The client never sees it.
+const statusClientClosedRequest = 499
+
+// aborted handles a storage call that failed because the client went away
+// or ctx's deadline passed, and reports whether it did. Other failures are
+// left to the caller.
+func (a *azureFileHandler) aborted(c *gin.Context, ctx context.Context, filename string) bool {
+	switch {
+	case c.Request.Context().Err() != nil:
+		a.log(c).Info("Request cancelled by client", zap.String("filename", filename))
+		c.AbortWithStatus(statusClientClosedRequest)
+		return true
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		a.log(c).Warn("Storage operation timed out", zap.String("filename", filename))
+		c.JSON(http.StatusGatewayTimeout, requestid.Error(c, "Storage operation timed out"))
+		return true
+	}
+	return false
+}
+
+// startSpan starts a span for a handler's work on a file. The returned
+// function ends it, marking it failed if the handler responded with a
+// server error.
+func startSpan(c *gin.Context, ctx context.Context, name, filename string, attrs ...attribute.KeyValue) (context.Context, trace.Span, func()) {
+	attrs = append(attrs, attribute.String("file.name", filename))
+	if t := tenant.Name(c); t != "" {
+		attrs = append(attrs, attribute.String("tenant", t))
+	}
+	ctx, span := tracing.Start(ctx, name, attrs...)
+	return ctx, span, func() {
+		if status := c.Writer.Status(); status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		span.End()
+	}
+}
+
+// throttledReader applies the request's bandwidth limits to r.
+func (a *azureFileHandler) throttledReader(c *gin.Context, r io.Reader) io.Reader {
+	if a.throttle == nil {
+		return r
+	}
+	return a.throttle.Reader(c.Request.Context(), tenant.Name(c), r)
+}
+
+// notifyUploaded announces a stored file through webhooks and the event bus.
+func (a *azureFileHandler) notifyUploaded(c *gin.Context, name string, size int64, contentType, checksum string, metadata map[string]*string) {
+	if a.webhooks == nil && a.events == nil {
+		return
+	}
+	meta := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		if v != nil {
+			meta[k] = *v
+		}
+	}
+	a.publishEvent(c, events.TypeFileUploaded, events.File{
+		Name:        name,
+		Size:        size,
+		ContentType: contentType,
+		Checksum:    checksum,
+		Metadata:    meta,
+	})
+	if a.webhooks == nil {
+		return
+	}
+	a.webhooks.Publish(webhook.Event{
+		Type:   webhook.EventFileUploaded,
+		Tenant: tenant.Name(c),
+		File: webhook.File{
+			Name:        name,
+			Size:        size,
+			ContentType: contentType,
+			Checksum:    checksum,
+			Metadata:    meta,
+		},
+	})
+}
+
+// publishEvent puts an event about f on the bus. Failures are logged; the
+// request has already succeeded.
+func (a *azureFileHandler) publishEvent(c *gin.Context, typ string, f events.File) {
+	if a.events == nil {
+		return
+	}
+	if id, ok := auth.FromContext(c); ok {
+		f.Actor = id.ID
+	}
+	// The event outlives the request when the client has already gone away.
+	ctx, cancel := withTimeout(context.WithoutCancel(c.Request.Context()), a.timeouts.Publish)
+	defer cancel()
+	if err := a.events.Publish(ctx, events.New(a.eventSource, typ, tenant.Name(c), f)); err != nil {
+		a.log(c).Error("Failed to publish event", zap.String("type", typ), zap.String("filename", f.Name), zap.Error(err))
+	}
+}
+
+// uploadedBy returns what to record as the uploader of a blob: the
+// authenticated identity, or the User-Agent for anonymous requests.
+func uploadedBy(c *gin.Context) string {
+	if id, ok := auth.FromContext(c); ok {
+		return id.ID
+	}
+	return c.GetHeader("User-Agent")
+}
+
+// identityField logs the authenticated caller, if there is one.
+func identityField(c *gin.Context) zap.Field {
+	if id, ok := auth.FromContext(c); ok {
+		return zap.String("identity", id.ID)
 	}
+	return zap.Skip()
 }
@@ -0,0 +1,309 @@
+package filehandler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/events"
+	"stream-upload-file/pkg/metrics"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+	"stream-upload-file/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	bundleFormatZip   = "zip"
+	bundleFormatTarGz = "tar.gz"
+
+	// maxBundleEntries caps how many blobs a single bundle request may include.
+	maxBundleEntries = 1000
+)
+
+// BundleRequest is the JSON body accepted by BundleHandler. Either Files or
+// Prefix must be set; when both are given the explicit file list wins.
+type BundleRequest struct {
+	Files  []string `json:"files"`
+	Prefix string   `json:"prefix"`
+	Format string   `json:"format"`
+}
+
+// errUnknownSize is returned by bundleWriter.Add, before anything is
+// written, for an entry whose format needs its size up front.
+var errUnknownSize = errors.New("unknown size")
+
+// bundleWriter abstracts over the zip and tar.gz archive formats.
+type bundleWriter interface {
+	// Add writes one entry. size is -1 when the blob length is unknown.
+	Add(name string, size int64, modified time.Time, body io.Reader) error
+	Close() error
+}
+
+func (a *azureFileHandler) BundleHandler(_ string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req BundleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			a.log(c).Warn("Invalid bundle request", zap.Error(err))
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Invalid bundle request"))
+			return
+		}
+		if req.Format == "" {
+			req.Format = bundleFormatZip
+		}
+		if req.Format != bundleFormatZip && req.Format != bundleFormatTarGz {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Unsupported bundle format"))
+			return
+		}
+
+		if len(req.Files) == 0 && req.Prefix != "" {
+			if !a.authorizePrefix(c, auth.ScopeList, req.Prefix) || !a.authorizePrefix(c, auth.ScopeDownload, req.Prefix) {
+				return
+			}
+		}
+
+		store, ok := a.store(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := storageContext(c, a.timeouts.Download)
+		defer cancel()
+		names, err := resolveBundleNames(ctx, store, req)
+		if err != nil {
+			if a.aborted(c, ctx, req.Prefix) {
+				return
+			}
+			a.log(c).Error("Failed to list blobs for bundle", zap.String("prefix", req.Prefix), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to list files"))
+			return
+		}
+		if len(req.Files) == 0 {
+			// Prefix selections skip what the caller may not see rather than
+			// failing the whole bundle.
+			visible := names[:0]
+			for _, name := range names {
+				if a.visible(c, auth.ScopeDownload, name) {
+					visible = append(visible, name)
+				}
+			}
+			names = visible
+		}
+		audit.Objects(c, names)
+		if len(names) == 0 {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "No files selected"))
+			return
+		}
+		if len(names) > maxBundleEntries {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, fmt.Sprintf("Too many files (max %d)", maxBundleEntries)))
+			return
+		}
+
+		for _, name := range names {
+			if !a.authorize(c, auth.ScopeDownload, name) {
+				return
+			}
+		}
+
+		a.log(c).Info("Streaming bundle download",
+			zap.Int("files", len(names)),
+			zap.String("format", req.Format),
+			zap.String("client-ip", c.ClientIP()),
+		)
+
+		transfer := metrics.StartTransfer(metrics.Download)
+		defer transfer.Done()
+		var out io.Writer = transfer.Writer(c.Writer)
+		if a.throttle != nil {
+			out = a.throttle.Writer(ctx, tenant.Name(c), out)
+		}
+		var bw bundleWriter
+		if req.Format == bundleFormatTarGz {
+			c.Header("Content-Type", "application/gzip")
+			c.Header("Content-Disposition", `attachment; filename="bundle.tar.gz"`)
+			bw = newTarGzBundleWriter(out)
+		} else {
+			c.Header("Content-Type", "application/zip")
+			c.Header("Content-Disposition", `attachment; filename="bundle.zip"`)
+			bw = newZipBundleWriter(out)
+		}
+		c.Status(http.StatusOK)
+
+		// Once the first byte is written the status code is committed, so
+		// from here on failures can only be logged and the stream truncated.
+		for _, name := range names {
+			if err := ctx.Err(); err != nil {
+				a.log(c).Info("Bundle download cut short", zap.Error(err))
+				return
+			}
+			if err := a.addBundleEntry(c, ctx, store, bw, name); err != nil {
+				if ctx.Err() != nil {
+					a.log(c).Info("Bundle download cut short", zap.String("filename", name), zap.Error(ctx.Err()))
+				} else {
+					a.log(c).Error("Failed to write bundle entry", zap.String("filename", name), zap.Error(err))
+				}
+				return
+			}
+		}
+		closeErr := bw.Close()
+		if closeErr != nil {
+			a.log(c).Error("Failed to finish bundle", zap.Error(closeErr))
+		}
+		audit.Transfer(c, max(int64(c.Writer.Size()), 0), "")
+		if closeErr == nil {
+			for _, name := range names {
+				a.publishEvent(c, events.TypeFileDownloaded, events.File{Name: name, Bundle: true})
+			}
+		}
+	}
+}
+
+// resolveBundleNames turns a bundle request into the list of blob names to archive.
+func resolveBundleNames(ctx context.Context, store StorageClient, req BundleRequest) ([]string, error) {
+	if len(req.Files) > 0 {
+		seen := make(map[string]bool, len(req.Files))
+		names := make([]string, 0, len(req.Files))
+		for _, f := range req.Files {
+			name := sanitizeBlobName(f)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+		return names, nil
+	}
+	if req.Prefix == "" {
+		return nil, nil
+	}
+
+	items, err := store.ListBlobs(ctx, req.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Name != nil {
+			names = append(names, *item.Name)
+		}
+	}
+	return names, nil
+}
+
+// addBundleEntry streams a single blob into the archive. Missing blobs, and
+// blobs of unknown size in a tar.gz, are skipped so that one entry does not
+// break a download whose status has already been sent.
+func (a *azureFileHandler) addBundleEntry(c *gin.Context, ctx context.Context, store StorageClient, bw bundleWriter, name string) error {
+	resp, err := store.DownloadBlob(ctx, name)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		a.log(c).Warn("Skipping missing file in bundle", zap.String("filename", name), zap.Error(err))
+		return nil
+	}
+	defer func() { resp.Body.Close() }()
+
+	if err := storage.DecodeBody(resp); err != nil {
+		return err
+	}
+
+	var size int64 = -1
+	if resp.ContentLength != nil {
+		size = *resp.ContentLength
+	}
+	modified := time.Now()
+	if resp.LastModified != nil {
+		modified = *resp.LastModified
+	}
+	err = bw.Add(name, size, modified, &contextReader{ctx: ctx, r: resp.Body})
+	if errors.Is(err, errUnknownSize) {
+		a.log(c).Warn("Skipping file of unknown size in bundle", zap.String("filename", name))
+		return nil
+	}
+	return err
+}
+
+// contextReader stops a copy as soon as the request context is done, even
+// if the underlying body would keep producing data.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+type zipBundleWriter struct {
+	zw *zip.Writer
+}
+
+func newZipBundleWriter(w io.Writer) *zipBundleWriter {
+	return &zipBundleWriter{zw: zip.NewWriter(w)}
+}
+
+func (z *zipBundleWriter) Add(name string, _ int64, modified time.Time, body io.Reader) error {
+	fw, err := z.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, body)
+	return err
+}
+
+func (z *zipBundleWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarGzBundleWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzBundleWriter(w io.Writer) *tarGzBundleWriter {
+	gw := gzip.NewWriter(w)
+	return &tarGzBundleWriter{gw: gw, tw: tar.NewWriter(gw)}
+}
+
+func (t *tarGzBundleWriter) Add(name string, size int64, modified time.Time, body io.Reader) error {
+	if size < 0 {
+		// tar headers carry the entry size up front, so an unknown length
+		// can't be streamed without staging the blob first.
+		return fmt.Errorf("%q: %w", name, errUnknownSize)
+	}
+	if err := t.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    size,
+		ModTime: modified,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.tw, body)
+	return err
+}
+
+func (t *tarGzBundleWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gw.Close()
+}
@@ -0,0 +1,271 @@
+package filehandler_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"stream-upload-file/pkg/filehandler"
+	"stream-upload-file/pkg/storage"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memStorageClient is an in-memory StorageClient backed by a map.
+type memStorageClient struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	uploadErr error
+	lists     int
+}
+
+func newMemStorageClient(blobs map[string]string) *memStorageClient {
+	m := &memStorageClient{blobs: make(map[string][]byte)}
+	for k, v := range blobs {
+		m.blobs[k] = []byte(v)
+	}
+	return m
+}
+
+func (m *memStorageClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
+	if m.uploadErr != nil {
+		return m.uploadErr
+	}
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[blobName] = b
+	return nil
+}
+
+func (m *memStorageClient) DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[blobName]
+	if !ok {
+		return nil, errors.New("blob not found")
+	}
+	size := int64(len(b))
+	resp := &azblob.DownloadStreamResponse{}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = &size
+	return resp, nil
+}
+
+func (m *memStorageClient) DownloadBlobRange(ctx context.Context, blobName string, offset, count int64) (*azblob.DownloadStreamResponse, error) {
+	resp, err := m.DownloadBlob(ctx, blobName)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := io.ReadAll(resp.Body)
+	total := int64(len(b))
+	if offset >= total {
+		return nil, storage.ErrInvalidRange
+	}
+	end := total
+	if count > 0 && offset+count < total {
+		end = offset + count
+	}
+	size := end - offset
+	contentRange := fmt.Sprintf("bytes %d-%d/%d", offset, end-1, total)
+	resp.Body = io.NopCloser(bytes.NewReader(b[offset:end]))
+	resp.ContentLength = &size
+	resp.ContentRange = &contentRange
+	return resp, nil
+}
+
+func (m *memStorageClient) DeleteBlob(ctx context.Context, blobName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[blobName]; !ok {
+		return errors.New("blob not found")
+	}
+	delete(m.blobs, blobName)
+	return nil
+}
+
+func (m *memStorageClient) GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[blobName]
+	if !ok {
+		return nil, storage.ErrBlobNotFound
+	}
+	size := int64(len(b))
+	return &blob.GetPropertiesResponse{ContentLength: &size}, nil
+}
+
+func (m *memStorageClient) ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lists++
+	var names []string
+	for name := range m.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	items := make([]*container.BlobItem, 0, len(names))
+	for _, name := range names {
+		size := int64(len(m.blobs[name]))
+		items = append(items, &container.BlobItem{
+			Name:       &name,
+			Properties: &container.BlobProperties{ContentLength: &size},
+		})
+	}
+	return items, nil
+}
+
+func newBundleRouter(client filehandler.StorageClient) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/download/bundle", filehandler.NewAzureFileHandler(client).BundleHandler(""))
+	return router
+}
+
+func TestBundleHandler_ZipByNames(t *testing.T) {
+	client := newMemStorageClient(map[string]string{"a.txt": "alpha", "b.txt": "bravo", "c.txt": "charlie"})
+	router := newBundleRouter(client)
+
+	req := httptest.NewRequest("POST", "/download/bundle", strings.NewReader(`{"files":["a.txt","../c.txt","missing.txt"]}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(b)
+	}
+	assert.Equal(t, map[string]string{"a.txt": "alpha", "c.txt": "charlie"}, got)
+}
+
+func TestBundleHandler_TarGzByPrefix(t *testing.T) {
+	client := newMemStorageClient(map[string]string{"log-1": "one", "log-2": "two", "other": "x"})
+	router := newBundleRouter(client)
+
+	req := httptest.NewRequest("POST", "/download/bundle", strings.NewReader(`{"prefix":"log-","format":"tar.gz"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	gz, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"log-1", "log-2"}, names)
+}
+
+// unknownSizeClient serves some blobs without a length, as for blobs
+// compressed at rest without their original size.
+type unknownSizeClient struct {
+	*memStorageClient
+	unknown string
+}
+
+func (u *unknownSizeClient) DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error) {
+	resp, err := u.memStorageClient.DownloadBlob(ctx, blobName)
+	if err == nil && blobName == u.unknown {
+		resp.ContentLength = nil
+	}
+	return resp, err
+}
+
+func TestBundleHandler_TarGzSkipsUnknownSize(t *testing.T) {
+	client := &unknownSizeClient{
+		memStorageClient: newMemStorageClient(map[string]string{"log-1": "one", "log-2": "two", "log-3": "three"}),
+		unknown:          "log-2",
+	}
+	router := newBundleRouter(client)
+
+	req := httptest.NewRequest("POST", "/download/bundle", strings.NewReader(`{"prefix":"log-","format":"tar.gz"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	gz, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	got := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err, "the archive is complete")
+		b, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(b)
+	}
+	assert.Equal(t, map[string]string{"log-1": "one", "log-3": "three"}, got)
+}
+
+func TestBundleHandler_BadRequests(t *testing.T) {
+	router := newBundleRouter(newMemStorageClient(nil))
+
+	for _, body := range []string{`not json`, `{}`, `{"files":["a"],"format":"rar"}`, `{"prefix":"nothing-"}`} {
+		req := httptest.NewRequest("POST", "/download/bundle", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestBundleHandler_ListError(t *testing.T) {
+	router := newBundleRouter(&MockStorageClient{listErr: errors.New("boom")})
+
+	req := httptest.NewRequest("POST", "/download/bundle", strings.NewReader(`{"prefix":"x"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestBundleHandler_ClientCancel(t *testing.T) {
+	client := newMemStorageClient(map[string]string{"a.txt": "alpha", "b.txt": "bravo"})
+	router := newBundleRouter(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("POST", "/download/bundle", strings.NewReader(`{"files":["a.txt","b.txt"]}`)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// The archive is abandoned before any entry is written.
+	_, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.Error(t, err)
+}
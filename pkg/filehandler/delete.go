@@ -0,0 +1,64 @@
+package filehandler
+
+import (
+	"net/http"
+
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/events"
+	"stream-upload-file/pkg/quota"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func (a *azureFileHandler) DeleteHandler(_ string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filename := sanitizeBlobName(c.Param("filename"))
+		audit.Object(c, filename)
+		if !a.authorize(c, auth.ScopeDelete, filename) {
+			return
+		}
+
+		a.log(c).Info("File delete request",
+			zap.String("filename", filename),
+			zap.String("client-ip", c.ClientIP()),
+		)
+
+		store, ok := a.store(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := storageContext(c, a.timeouts.Operation)
+		defer cancel()
+		var deleted *quota.Object
+		if a.usage != nil {
+			var err error
+			if deleted, err = existingObject(ctx, store, filename); err != nil {
+				a.log(c).Warn("Failed to look up blob for quota accounting", zap.String("filename", filename), zap.Error(err))
+			}
+		}
+		if err := store.DeleteBlob(ctx, filename); err != nil {
+			if a.aborted(c, ctx, filename) {
+				return
+			}
+			a.log(c).Warn("Blob not found or failed to delete", zap.String("filename", filename), zap.Error(err))
+			c.JSON(http.StatusNotFound, requestid.Error(c, "File not found"))
+			return
+		}
+		if deleted != nil {
+			a.usage.Remove(tenant.Name(c), *deleted)
+		}
+
+		a.publishEvent(c, events.TypeFileDeleted, events.File{Name: filename})
+
+		a.log(c).Info("File deleted successfully", zap.String("filename", filename))
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "File deleted successfully",
+			"filename": filename,
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package filehandler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/filehandler"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := newMemStorageClient(map[string]string{"team-a.txt": "x", "team-b.txt": "y"})
+	router := gin.New()
+	router.DELETE("/files/:filename",
+		withIdentity(&auth.Identity{ID: "k", Scopes: []string{auth.ScopeDelete}, PathPrefix: "team-a"}),
+		filehandler.NewAzureFileHandler(client).DeleteHandler(""))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/files/team-a.txt", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, client.blobs, "team-a.txt")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/files/team-a.txt", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/files/team-b.txt", nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, client.blobs, "team-b.txt")
+}
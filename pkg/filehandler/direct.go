@@ -0,0 +1,408 @@
+package filehandler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+	"stream-upload-file/pkg/tenant"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// directUploadTTL is how long a client has to upload straight to storage.
+	directUploadTTL = time.Hour
+	// directCompleteGrace gives clients time to call the completion endpoint
+	// after the upload URL itself has expired.
+	directCompleteGrace = time.Hour
+	// directUploadRetention is how long staged uploads and completion
+	// markers are kept: until no token for them can still be used, allowing
+	// for clock skew.
+	directUploadRetention = directUploadTTL + directCompleteGrace + 5*time.Minute
+)
+
+// DirectUploader issues upload URLs that point straight at the storage
+// service, taking this service out of the data path. AzureBlobClient
+// implements it with user delegation SAS.
+//
+// Clients upload to a staging blob under storage.DirectUploadPrefix. Only
+// a completed upload that matches what was declared is copied to its real
+// name, so the upload URL never grants access to a visible file.
+type DirectUploader interface {
+	DelegatedUploadURL(ctx context.Context, blobName string, ttl time.Duration) (string, time.Time, error)
+	GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error)
+	CopyBlob(ctx context.Context, src, dst string, etag azcore.ETag, metadata map[string]*string) error
+	CreateMarker(ctx context.Context, name string) (bool, error)
+	DeleteBlob(ctx context.Context, blobName string) error
+	ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error)
+}
+
+// stagedName is where the direct upload id is written by the client.
+func stagedName(id string) string {
+	return storage.DirectUploadPrefix + id
+}
+
+// completedMarker records that the direct upload id has been completed. The
+// upload URL only covers the staged blob, so clients can't create it.
+func completedMarker(id string) string {
+	return storage.DirectUploadPrefix + id + ".done"
+}
+
+// WithDirectUploader enables brokered uploads straight to storage. It also
+// requires WithPresigner, whose signer protects the completion token.
+func WithDirectUploader(uploader DirectUploader) Option {
+	return func(a *azureFileHandler) {
+		a.directUploader = uploader
+	}
+}
+
+// uploaderFor returns the direct uploader for the request's tenant. Tenants
+// whose storage is prefixed, encrypted or compressed have none, because
+// those are applied inside the service.
+func (a *azureFileHandler) uploaderFor(c *gin.Context) DirectUploader {
+	t, ok := tenant.FromContext(c)
+	if a.directUploader == nil || !ok || a.tenantStores == nil {
+		return a.directUploader
+	}
+	s, err := a.tenantStores.Store(t)
+	if err != nil {
+		return nil
+	}
+	u, _ := s.(DirectUploader)
+	return u
+}
+
+// DirectUploadRequest is the JSON body accepted by DirectUploadHandler.
+type DirectUploadRequest struct {
+	Filename    string `json:"filename" binding:"required"`
+	Size        int64  `json:"size" binding:"required"`
+	ContentType string `json:"contentType"`
+	MD5         string `json:"md5"` // base64, as in the Content-MD5 header
+}
+
+// DirectUploadCompleteRequest is the JSON body accepted by DirectUploadCompleteHandler.
+type DirectUploadCompleteRequest struct {
+	UploadToken string `json:"uploadToken" binding:"required"`
+}
+
+// DirectUploadHandler returns a short-lived URL the client can PUT the file
+// to directly, plus a token to confirm the upload once it has finished.
+func (a *azureFileHandler) DirectUploadHandler(_ string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploader := a.uploaderFor(c)
+		if uploader == nil || a.presigner == nil {
+			c.JSON(http.StatusNotFound, requestid.Error(c, "Direct uploads are not enabled"))
+			return
+		}
+
+		var req DirectUploadRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Size <= 0 {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Invalid direct upload request"))
+			return
+		}
+		if req.MD5 != "" {
+			if sum, err := base64.StdEncoding.DecodeString(req.MD5); err != nil || len(sum) != 16 {
+				c.JSON(http.StatusBadRequest, requestid.Error(c, "md5 must be a base64 encoded MD5 digest"))
+				return
+			}
+		}
+		filename := sanitizeBlobName(req.Filename)
+		audit.Object(c, filename)
+		if !a.authorize(c, auth.ScopeUpload, filename) {
+			return
+		}
+		if a.usage != nil {
+			if err := a.usage.Check(tenant.Name(c), quotaUser(c), req.Size); err != nil {
+				a.quotaExceeded(c, filename, req.Size, err)
+				return
+			}
+		}
+		contentType := req.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		var id [16]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			a.log(c).Error("Failed to generate direct upload ID", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to issue upload URL"))
+			return
+		}
+		uploadID := hex.EncodeToString(id[:])
+
+		ctx, cancel := storageContext(c, a.timeouts.Operation)
+		defer cancel()
+		uploadURL, expires, err := uploader.DelegatedUploadURL(ctx, stagedName(uploadID), directUploadTTL)
+		if err != nil {
+			if a.aborted(c, ctx, filename) {
+				return
+			}
+			a.log(c).Error("Failed to issue direct upload URL", zap.String("filename", filename), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to issue upload URL"))
+			return
+		}
+
+		token := a.presigner.Sign(presign.Params{
+			Operation:   presign.OperationDirectUpload,
+			Filename:    filename,
+			Expires:     expires.Add(directCompleteGrace),
+			MaxSize:     req.Size,
+			ContentType: contentType,
+			Checksum:    req.MD5,
+			Tenant:      tenant.Name(c),
+			ID:          uploadID,
+		})
+
+		headers := gin.H{
+			"x-ms-blob-type":         "BlockBlob",
+			"x-ms-blob-content-type": contentType,
+		}
+		if req.MD5 != "" {
+			headers["x-ms-blob-content-md5"] = req.MD5
+		}
+
+		a.log(c).Info("Issued direct upload URL",
+			zap.String("filename", filename),
+			zap.Int64("size", req.Size),
+			zap.Time("expires", expires),
+			zap.String("client-ip", c.ClientIP()),
+		)
+		c.JSON(http.StatusOK, gin.H{
+			"filename":    filename,
+			"uploadUrl":   uploadURL,
+			"method":      http.MethodPut,
+			"headers":     headers,
+			"expiresAt":   expires.UTC().Format(time.RFC3339),
+			"uploadToken": token.Encode(),
+		})
+	}
+}
+
+// DirectUploadCompleteHandler validates a direct upload against what was
+// declared when the URL was issued and publishes it under its name with the
+// service metadata. Uploads that don't match are deleted. Completing an
+// upload again reports it as completed without counting it twice.
+func (a *azureFileHandler) DirectUploadCompleteHandler(_ string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploader := a.uploaderFor(c)
+		if uploader == nil || a.presigner == nil {
+			c.JSON(http.StatusNotFound, requestid.Error(c, "Direct uploads are not enabled"))
+			return
+		}
+
+		var req DirectUploadCompleteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Invalid completion request"))
+			return
+		}
+		q, err := url.ParseQuery(req.UploadToken)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Invalid upload token"))
+			return
+		}
+		declared, err := a.presigner.Verify(q)
+		if err == nil && (declared.Operation != presign.OperationDirectUpload || declared.ID == "" || declared.Tenant != tenant.Name(c)) {
+			err = errPresignMismatch
+		}
+		if err != nil {
+			a.log(c).Warn("Rejected direct upload completion", zap.Error(err))
+			c.JSON(http.StatusForbidden, requestid.Error(c, "Invalid or expired upload token"))
+			return
+		}
+		filename := declared.Filename
+		audit.Object(c, filename)
+		if !a.authorize(c, auth.ScopeUpload, filename) {
+			return
+		}
+		staged, marker := stagedName(declared.ID), completedMarker(declared.ID)
+
+		ctx, cancel := storageContext(c, a.timeouts.Operation)
+		defer cancel()
+		if _, err := uploader.GetBlobProperties(ctx, marker); err == nil {
+			a.log(c).Info("Direct upload already completed", zap.String("filename", filename))
+			directUploadCompleted(c, filename, declared.MaxSize)
+			return
+		}
+		props, err := uploader.GetBlobProperties(ctx, staged)
+		if err != nil {
+			if a.aborted(c, ctx, filename) {
+				return
+			}
+			a.log(c).Warn("Direct upload not found", zap.String("filename", filename), zap.Error(err))
+			c.JSON(http.StatusNotFound, requestid.Error(c, "Upload not found"))
+			return
+		}
+
+		var size int64 = -1
+		if props.ContentLength != nil {
+			size = *props.ContentLength
+		}
+		reason := ""
+		switch {
+		case size != declared.MaxSize:
+			reason = "Uploaded size does not match the declared size"
+		case declared.Checksum != "" && base64.StdEncoding.EncodeToString(props.ContentMD5) != declared.Checksum:
+			reason = "Uploaded checksum does not match the declared checksum"
+		case props.ETag == nil:
+			reason = "Upload has no ETag"
+		}
+		// Rejected uploads are deleted even if the client has gone away.
+		cleanup, cancelCleanup := a.cleanupContext(ctx)
+		defer cancelCleanup()
+		if reason != "" {
+			a.log(c).Warn("Direct upload failed validation",
+				zap.String("filename", filename),
+				zap.Int64("declaredSize", declared.MaxSize),
+				zap.Int64("size", size),
+				zap.String("reason", reason),
+			)
+			if err := uploader.DeleteBlob(cleanup, staged); err != nil {
+				a.log(c).Error("Failed to delete invalid direct upload", zap.String("filename", filename), zap.Error(err))
+			}
+			c.JSON(http.StatusUnprocessableEntity, requestid.Error(c, reason))
+			return
+		}
+
+		reservation, ok := a.reserveQuota(c, ctx, uploader, filename, size)
+		if !ok {
+			if err := uploader.DeleteBlob(cleanup, staged); err != nil {
+				a.log(c).Error("Failed to delete direct upload over quota", zap.String("filename", filename), zap.Error(err))
+			}
+			return
+		}
+		// Only the request that creates the marker publishes the upload, so
+		// that concurrent or replayed completions count it once.
+		created, err := uploader.CreateMarker(ctx, marker)
+		if err != nil || !created {
+			reservation.Release()
+			if err == nil {
+				a.log(c).Info("Direct upload already completed", zap.String("filename", filename))
+				directUploadCompleted(c, filename, size)
+				return
+			}
+			if a.aborted(c, ctx, filename) {
+				return
+			}
+			a.log(c).Error("Failed to mark direct upload completed", zap.String("filename", filename), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to record upload"))
+			return
+		}
+
+		metadata := make(map[string]*string, len(props.Metadata)+4)
+		for k, v := range props.Metadata {
+			metadata[k] = v
+		}
+		metadata["originalName"] = stringPtr(filename)
+		metadata["uploadedBy"] = stringPtr(uploadedBy(c))
+		metadata["uploadMode"] = stringPtr("direct")
+		metadata[storage.MetadataOriginalSize] = stringPtr(strconv.FormatInt(size, 10))
+		// The copy fails if the staged blob changed after it was checked.
+		if err := uploader.CopyBlob(ctx, staged, filename, *props.ETag, metadata); err != nil {
+			reservation.Release()
+			// Without the marker the client can complete the upload again.
+			if err := uploader.DeleteBlob(cleanup, marker); err != nil {
+				a.log(c).Error("Failed to delete direct upload marker", zap.String("filename", filename), zap.Error(err))
+			}
+			if a.aborted(c, ctx, filename) {
+				return
+			}
+			a.log(c).Error("Failed to publish direct upload", zap.String("filename", filename), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to record upload"))
+			return
+		}
+		reservation.Commit()
+		if err := uploader.DeleteBlob(cleanup, staged); err != nil {
+			// The sweeper removes it once the token has expired.
+			a.log(c).Warn("Failed to delete staged direct upload", zap.String("filename", filename), zap.Error(err))
+		}
+
+		checksum := ""
+		if len(props.ContentMD5) > 0 {
+			checksum = "md5:" + base64.StdEncoding.EncodeToString(props.ContentMD5)
+		}
+		audit.Transfer(c, size, checksum)
+		contentType := ""
+		if props.ContentType != nil {
+			contentType = *props.ContentType
+		}
+		a.notifyUploaded(c, filename, size, contentType, checksum, metadata)
+		a.log(c).Info("Direct upload completed", zap.String("filename", filename), zap.Int64("size", size))
+		directUploadCompleted(c, filename, size)
+	}
+}
+
+func directUploadCompleted(c *gin.Context, filename string, size int64) {
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Direct upload completed",
+		"filename": filename,
+		"size":     size,
+	})
+}
+
+// RunDirectUploadSweeper deletes staged direct uploads that were never
+// completed, and the markers of completed ones, once their tokens have
+// expired. It sweeps the stores returned by uploaders immediately and then
+// every interval until ctx is done.
+func (a *azureFileHandler) RunDirectUploadSweeper(ctx context.Context, interval time.Duration, uploaders func() ([]DirectUploader, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		all, err := uploaders()
+		if err != nil {
+			a.logger.Error("Failed to open storage for direct upload sweep", zap.Error(err))
+		}
+		for _, u := range all {
+			n, err := sweepDirectUploads(ctx, u, time.Now().Add(-directUploadRetention))
+			if err != nil {
+				a.logger.Error("Failed to sweep direct uploads", zap.Error(err))
+			}
+			if n > 0 {
+				a.logger.Info("Swept expired direct uploads", zap.Int("deleted", n))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepDirectUploads deletes the staging blobs last written before cutoff
+// and returns how many it deleted.
+func sweepDirectUploads(ctx context.Context, u DirectUploader, cutoff time.Time) (int, error) {
+	items, err := u.ListBlobs(ctx, storage.DirectUploadPrefix)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	var errs []error
+	for _, item := range items {
+		if item.Name == nil || item.Properties == nil || item.Properties.LastModified == nil ||
+			item.Properties.LastModified.After(cutoff) {
+			continue
+		}
+		if err := u.DeleteBlob(ctx, *item.Name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, errors.Join(errs...)
+}
@@ -0,0 +1,303 @@
+package filehandler_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/filehandler"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/quota"
+	"stream-upload-file/pkg/storage"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDirectUploader stands in for the storage service: tests "upload" by
+// writing into blobs directly, as a client holding the SAS would.
+type fakeDirectUploader struct {
+	mu       sync.Mutex
+	blobs    map[string][]byte
+	metadata map[string]map[string]*string
+	modified map[string]time.Time
+	versions map[string]int
+	deleted  []string
+	copyErr  error
+}
+
+func newFakeDirectUploader() *fakeDirectUploader {
+	return &fakeDirectUploader{
+		blobs:    map[string][]byte{},
+		metadata: map[string]map[string]*string{},
+		modified: map[string]time.Time{},
+		versions: map[string]int{},
+	}
+}
+
+// put writes a blob as a client would, giving it a new ETag.
+func (f *fakeDirectUploader) put(name string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blobs[name] = content
+	f.modified[name] = time.Now()
+	f.versions[name]++
+}
+
+func (f *fakeDirectUploader) etag(name string) azcore.ETag {
+	return azcore.ETag(fmt.Sprintf("%q", fmt.Sprint(f.versions[name])))
+}
+
+func (f *fakeDirectUploader) DelegatedUploadURL(ctx context.Context, blobName string, ttl time.Duration) (string, time.Time, error) {
+	return "https://acct.blob.core.windows.net/c/" + blobName + "?sig=x", time.Now().Add(ttl), nil
+}
+
+func (f *fakeDirectUploader) GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.blobs[blobName]
+	if !ok {
+		return nil, storage.ErrBlobNotFound
+	}
+	size := int64(len(b))
+	sum := md5.Sum(b)
+	etag := f.etag(blobName)
+	return &blob.GetPropertiesResponse{ContentLength: &size, ContentMD5: sum[:], ETag: &etag, Metadata: f.metadata[blobName]}, nil
+}
+
+func (f *fakeDirectUploader) CopyBlob(ctx context.Context, src, dst string, etag azcore.ETag, metadata map[string]*string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.copyErr != nil {
+		return f.copyErr
+	}
+	b, ok := f.blobs[src]
+	if !ok || f.etag(src) != etag {
+		return errors.New("source condition not met")
+	}
+	f.blobs[dst] = b
+	f.metadata[dst] = metadata
+	f.modified[dst] = time.Now()
+	f.versions[dst]++
+	return nil
+}
+
+func (f *fakeDirectUploader) CreateMarker(ctx context.Context, name string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.blobs[name]; ok {
+		return false, nil
+	}
+	f.blobs[name] = nil
+	f.modified[name] = time.Now()
+	return true, nil
+}
+
+func (f *fakeDirectUploader) DeleteBlob(ctx context.Context, blobName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.blobs, blobName)
+	f.deleted = append(f.deleted, blobName)
+	return nil
+}
+
+func (f *fakeDirectUploader) ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var items []*container.BlobItem
+	for name, b := range f.blobs {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		size := int64(len(b))
+		modified := f.modified[name]
+		items = append(items, &container.BlobItem{
+			Name:       &name,
+			Properties: &container.BlobProperties{ContentLength: &size, LastModified: &modified},
+		})
+	}
+	return items, nil
+}
+
+func (f *fakeDirectUploader) has(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.blobs[name]
+	return ok
+}
+
+func newDirectRouter(t *testing.T, uploader *fakeDirectUploader, opts ...filehandler.Option) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	signer, err := presign.NewSigner([]byte(strings.Repeat("d", 32)))
+	require.NoError(t, err)
+	opts = append(opts, filehandler.WithPresigner(signer), filehandler.WithDirectUploader(uploader))
+	h := filehandler.NewAzureFileHandler(newMemStorageClient(nil), opts...)
+
+	router := gin.New()
+	router.POST("/uploads/direct", h.DirectUploadHandler(""))
+	router.POST("/uploads/direct/complete", h.DirectUploadCompleteHandler(""))
+	router.GET("/download/*filename", h.DownloadHandler(""))
+	router.GET("/usage", h.UsageHandler(""))
+	return router
+}
+
+func startDirectUpload(t *testing.T, router *gin.Engine, body string) (int, map[string]any) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/uploads/direct", strings.NewReader(body)))
+	var resp map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+// stagedBlob is the blob the upload URL in resp lets the client write.
+func stagedBlob(t *testing.T, resp map[string]any) string {
+	t.Helper()
+	u, ok := resp["uploadUrl"].(string)
+	require.True(t, ok)
+	name, _, _ := strings.Cut(strings.TrimPrefix(u, "https://acct.blob.core.windows.net/c/"), "?")
+	return name
+}
+
+func completeDirectUpload(router *gin.Engine, token string) int {
+	body, _ := json.Marshal(map[string]string{"uploadToken": token})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/uploads/direct/complete", strings.NewReader(string(body))))
+	return w.Code
+}
+
+func TestDirectUpload_Success(t *testing.T) {
+	uploader := newFakeDirectUploader()
+	router := newDirectRouter(t, uploader)
+	content := []byte("large file contents")
+	sum := md5.Sum(content)
+
+	code, resp := startDirectUpload(t, router, `{"filename":"big.bin","size":19,"md5":"`+base64.StdEncoding.EncodeToString(sum[:])+`"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "PUT", resp["method"])
+	assert.Equal(t, "big.bin", resp["filename"])
+	staged := stagedBlob(t, resp)
+	assert.True(t, strings.HasPrefix(staged, ".direct-uploads/"), "the URL only covers a staging blob")
+
+	uploader.put(staged, content)
+	assert.False(t, uploader.has("big.bin"), "nothing is visible before completion")
+	assert.Equal(t, http.StatusOK, completeDirectUpload(router, resp["uploadToken"].(string)))
+	assert.Equal(t, content, uploader.blobs["big.bin"])
+	assert.Equal(t, "direct", *uploader.metadata["big.bin"]["uploadMode"])
+	assert.Equal(t, "19", *uploader.metadata["big.bin"]["originalSize"])
+	assert.False(t, uploader.has(staged))
+}
+
+func TestDirectUpload_SizeOrChecksumMismatch(t *testing.T) {
+	uploader := newFakeDirectUploader()
+	router := newDirectRouter(t, uploader)
+
+	_, resp := startDirectUpload(t, router, `{"filename":"a.bin","size":5}`)
+	staged := stagedBlob(t, resp)
+	uploader.put(staged, []byte("123456"))
+	assert.Equal(t, http.StatusUnprocessableEntity, completeDirectUpload(router, resp["uploadToken"].(string)))
+	assert.Equal(t, []string{staged}, uploader.deleted)
+	assert.False(t, uploader.has("a.bin"))
+
+	wrong := md5.Sum([]byte("other"))
+	_, resp = startDirectUpload(t, router, `{"filename":"b.bin","size":5,"md5":"`+base64.StdEncoding.EncodeToString(wrong[:])+`"}`)
+	uploader.put(stagedBlob(t, resp), []byte("12345"))
+	assert.Equal(t, http.StatusUnprocessableEntity, completeDirectUpload(router, resp["uploadToken"].(string)))
+	assert.False(t, uploader.has("b.bin"))
+}
+
+func TestDirectUpload_InvalidTokenAndMissingBlob(t *testing.T) {
+	uploader := newFakeDirectUploader()
+	router := newDirectRouter(t, uploader)
+
+	assert.Equal(t, http.StatusForbidden, completeDirectUpload(router, "op=direct-upload&file=x&exp=9999999999&sig=bogus"))
+
+	_, resp := startDirectUpload(t, router, `{"filename":"never.bin","size":5}`)
+	assert.Equal(t, http.StatusNotFound, completeDirectUpload(router, resp["uploadToken"].(string)))
+}
+
+func TestDirectUpload_ReplayCountsOnce(t *testing.T) {
+	uploader := newFakeDirectUploader()
+	tracker := quota.NewTracker(&quota.Config{})
+	router := newDirectRouter(t, uploader, filehandler.WithUsageTracker(tracker))
+
+	_, resp := startDirectUpload(t, router, `{"filename":"once.bin","size":4}`)
+	staged := stagedBlob(t, resp)
+	uploader.put(staged, []byte("1234"))
+	token := resp["uploadToken"].(string)
+	assert.Equal(t, http.StatusOK, completeDirectUpload(router, token))
+
+	// The SAS is still valid, but what it writes is never published.
+	uploader.put(staged, []byte("evil"))
+	assert.Equal(t, http.StatusOK, completeDirectUpload(router, token))
+	assert.Equal(t, []byte("1234"), uploader.blobs["once.bin"])
+	assert.Equal(t, quota.Usage{Bytes: 4, Objects: 1}, tracker.Report("", "").Usage)
+}
+
+func TestDirectUpload_ChangedDuringCompletion(t *testing.T) {
+	uploader := newFakeDirectUploader()
+	router := newDirectRouter(t, uploader)
+
+	_, resp := startDirectUpload(t, router, `{"filename":"c.bin","size":4}`)
+	uploader.put(stagedBlob(t, resp), []byte("1234"))
+	uploader.copyErr = errors.New("source condition not met")
+	assert.Equal(t, http.StatusInternalServerError, completeDirectUpload(router, resp["uploadToken"].(string)))
+	assert.False(t, uploader.has("c.bin"))
+
+	uploader.copyErr = nil
+	assert.Equal(t, http.StatusOK, completeDirectUpload(router, resp["uploadToken"].(string)), "a failed completion can be retried")
+	assert.True(t, uploader.has("c.bin"))
+}
+
+func TestDirectUpload_StagedBlobsAreHidden(t *testing.T) {
+	uploader := newFakeDirectUploader()
+	router := newDirectRouter(t, uploader)
+
+	_, resp := startDirectUpload(t, router, `{"filename":"h.bin","size":4}`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/download/"+stagedBlob(t, resp), nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	code, _ := startDirectUpload(t, router, `{"filename":".direct-uploads/x","size":4}`)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestDirectUpload_SweepsExpired(t *testing.T) {
+	uploader := newFakeDirectUploader()
+	uploader.put(".direct-uploads/stale", []byte("x"))
+	uploader.put(".direct-uploads/fresh", []byte("x"))
+	uploader.put("kept.bin", []byte("x"))
+	uploader.modified[".direct-uploads/stale"] = time.Now().Add(-3 * time.Hour)
+	uploader.modified["kept.bin"] = time.Now().Add(-3 * time.Hour)
+
+	h := filehandler.NewAzureFileHandler(newMemStorageClient(nil), filehandler.WithDirectUploader(uploader))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.RunDirectUploadSweeper(ctx, time.Hour, func() ([]filehandler.DirectUploader, error) {
+		return []filehandler.DirectUploader{uploader}, nil
+	})
+	assert.Equal(t, []string{".direct-uploads/stale"}, uploader.deleted)
+}
+
+func TestDirectUpload_BadRequest(t *testing.T) {
+	router := newDirectRouter(t, newFakeDirectUploader())
+
+	for _, body := range []string{`{"filename":"a"}`, `{"filename":"a","size":-1}`, `{"filename":"a","size":1,"md5":"nope"}`} {
+		code, _ := startDirectUpload(t, router, body)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+}
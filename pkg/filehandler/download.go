@@ -2,30 +2,105 @@ package filehandler
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
 
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/events"
+	"stream-upload-file/pkg/metrics"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
 func (a *azureFileHandler) DownloadHandler(_ string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		filename := c.Param("filename")
-		filename = sanitizeFilename(filename)
+		filename := sanitizeBlobName(c.Param("filename"))
+		audit.Object(c, filename)
 
-		a.logger.Info("File download request",
+		a.log(c).Info("File download request",
 			zap.String("filename", filename),
 			zap.String("client-ip", c.ClientIP()),
+			identityField(c),
 		)
 
-		ctx := context.Background()
-		resp, err := a.storageClient.DownloadBlob(ctx, filename)
+		signed, err := a.verifyPresigned(c, presign.OperationDownload, filename)
 		if err != nil {
-			a.logger.Warn("Blob not found or failed to download", zap.String("filename", filename), zap.Error(err))
-			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			a.log(c).Warn("Rejected presigned download", zap.String("filename", filename), zap.Error(err))
+			c.JSON(http.StatusForbidden, requestid.Error(c, "Invalid or expired presigned URL"))
+			return
+		}
+		if signed == nil && !a.authorize(c, auth.ScopeDownload, filename) {
+			return
+		}
+
+		store, ok := a.store(c)
+		if !ok {
+			return
+		}
+
+		offset, count, ranged := parseRange(c.GetHeader("Range"))
+		ctx, cancel := storageContext(c, a.timeouts.Download)
+		defer cancel()
+		ctx, span, endSpan := startSpan(c, ctx, "DownloadHandler", filename, attribute.Bool("file.ranged", ranged))
+		defer endSpan()
+
+		resp, err := openDownload(ctx, store, filename, offset, count, ranged)
+		if errors.Is(err, storage.ErrInvalidRange) {
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, requestid.Error(c, "Requested range not satisfiable"))
 			return
 		}
+		if err != nil {
+			if a.aborted(c, ctx, filename) {
+				return
+			}
+			a.log(c).Warn("Blob not found or failed to download", zap.String("filename", filename), zap.Error(err))
+			c.JSON(http.StatusNotFound, requestid.Error(c, "File not found"))
+			return
+		}
+
+		// Blobs compressed at rest are forwarded as-is when the client can
+		// decode them, otherwise they are decompressed on the fly.
+		if encoding := storage.ContentEncoding(resp); encoding != "" {
+			c.Header("Vary", "Accept-Encoding")
+			if acceptsEncoding(c.GetHeader("Accept-Encoding"), encoding) {
+				c.Header("Content-Encoding", encoding)
+			} else {
+				if ranged {
+					// A range over the compressed bytes is meaningless to a client
+					// that can't decode them, so fall back to the whole file.
+					resp.Body.Close()
+					ranged = false
+					if resp, err = store.DownloadBlob(ctx, filename); err != nil {
+						if a.aborted(c, ctx, filename) {
+							return
+						}
+						a.log(c).Warn("Blob not found or failed to download", zap.String("filename", filename), zap.Error(err))
+						c.JSON(http.StatusNotFound, requestid.Error(c, "File not found"))
+						return
+					}
+				}
+				if err := storage.DecodeBody(resp); err != nil {
+					resp.Body.Close()
+					a.log(c).Error("Failed to decode stored file", zap.String("filename", filename), zap.Error(err))
+					c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to read file"))
+					return
+				}
+			}
+		}
 		defer resp.Body.Close()
 
 		contentType := "application/octet-stream"
@@ -37,13 +112,100 @@ func (a *azureFileHandler) DownloadHandler(_ string) gin.HandlerFunc {
 			contentLength = *resp.ContentLength
 		}
 
-		a.logger.Info("Streaming file download",
+		a.log(c).Info("Streaming file download",
 			zap.String("filename", filename),
 			zap.String("contentType", contentType),
 			zap.Int64("size", contentLength),
 		)
 
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-		c.DataFromReader(http.StatusOK, contentLength, contentType, resp.Body, nil)
+		status := http.StatusOK
+		if ranged && resp.ContentRange != nil {
+			status = http.StatusPartialContent
+			c.Header("Content-Range", *resp.ContentRange)
+		}
+		c.Header("Accept-Ranges", "bytes")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(filename)))
+		// Whole files are hashed as they stream, for the audit log.
+		var body io.Reader = resp.Body
+		hash := sha256.New()
+		hashed := status == http.StatusOK && c.Writer.Header().Get("Content-Encoding") == ""
+		if hashed {
+			body = io.TeeReader(body, hash)
+		}
+		transfer := metrics.StartTransfer(metrics.Download)
+		c.DataFromReader(status, contentLength, contentType, transfer.Reader(a.throttledReader(c, body)), nil)
+		transfer.Done()
+
+		checksum := ""
+		if hashed && (contentLength < 0 || int64(c.Writer.Size()) == contentLength) {
+			checksum = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		}
+		audit.Transfer(c, max(int64(c.Writer.Size()), 0), checksum)
+		span.SetAttributes(attribute.Int64("file.bytes_sent", max(int64(c.Writer.Size()), 0)))
+		if err := ctx.Err(); err != nil {
+			// The response has started, so all that's left is to not announce
+			// a download that didn't finish.
+			a.log(c).Info("Download cut short", zap.String("filename", filename), zap.Int("sent", c.Writer.Size()), zap.Error(err))
+			return
+		}
+
+		downloaded := events.File{Name: filename, Size: max(int64(c.Writer.Size()), 0), ContentType: contentType, Checksum: checksum}
+		if status == http.StatusPartialContent {
+			downloaded.Range = *resp.ContentRange
+		}
+		a.publishEvent(c, events.TypeFileDownloaded, downloaded)
+	}
+}
+
+func openDownload(ctx context.Context, store StorageClient, filename string, offset, count int64, ranged bool) (*azblob.DownloadStreamResponse, error) {
+	if ranged {
+		return store.DownloadBlobRange(ctx, filename, offset, count)
+	}
+	return store.DownloadBlob(ctx, filename)
+}
+
+// parseRange parses a single "bytes=start-end" or "bytes=start-" range.
+// Suffix and multi-part ranges are not supported and are ignored, which
+// RFC 9110 permits; the full file is served instead. A count of zero means
+// "to the end".
+func parseRange(header string) (offset, count int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found || startStr == "" {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(startStr), 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if strings.TrimSpace(endStr) == "" {
+		return start, 0, true
+	}
+	end, err := strconv.ParseInt(strings.TrimSpace(endStr), 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end - start + 1, true
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows the
+// given content coding.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		token, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		token = strings.TrimSpace(token)
+		if !strings.EqualFold(token, encoding) && token != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
 	}
+	return false
 }
@@ -2,6 +2,7 @@ package filehandler_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"io"
@@ -10,6 +11,9 @@ import (
 	"strconv"
 	"testing"
 
+	"stream-upload-file/pkg/filehandler"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -173,3 +177,87 @@ func TestDownloadHandler_SanitizeFilename(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, string(expectedContent), w.Body.String())
 }
+
+func gzipBytes(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func compressedDownloadRouter(t *testing.T, payload string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	body := gzipBytes(t, payload)
+	size := int64(len(body))
+	encoding := "gzip"
+	resp := &azblob.DownloadStreamResponse{}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = &size
+	resp.ContentEncoding = &encoding
+
+	router := gin.New()
+	router.GET("/download/:filename", filehandler.NewAzureFileHandler(&MockStorageClient{downloadResp: resp}).DownloadHandler(""))
+	return router
+}
+
+func TestDownloadHandler_ServesCompressedWhenAccepted(t *testing.T) {
+	router := compressedDownloadRouter(t, "compressible log line")
+
+	req := httptest.NewRequest("GET", "/download/app.log", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.8")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	zr, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	got, _ := io.ReadAll(zr)
+	assert.Equal(t, "compressible log line", string(got))
+}
+
+func TestDownloadHandler_DecompressesWhenNotAccepted(t *testing.T) {
+	router := compressedDownloadRouter(t, "compressible log line")
+
+	req := httptest.NewRequest("GET", "/download/app.log", nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0, identity")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "compressible log line", w.Body.String())
+}
+
+func TestDownloadHandler_Range(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	client := newMemStorageClient(map[string]string{"data.txt": "0123456789"})
+	router.GET("/download/:filename", filehandler.NewAzureFileHandler(client).DownloadHandler(""))
+
+	cases := []struct {
+		header       string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=-3", http.StatusOK, "0123456789", ""},
+		{"bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "", ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/download/data.txt", nil)
+		req.Header.Set("Range", tc.header)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tc.status, w.Code, tc.header)
+		if tc.body != "" {
+			assert.Equal(t, tc.body, w.Body.String(), tc.header)
+		}
+		assert.Equal(t, tc.contentRange, w.Header().Get("Content-Range"), tc.header)
+	}
+}
@@ -0,0 +1,76 @@
+package filehandler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// FileInfo describes one stored file in a listing.
+type FileInfo struct {
+	Name         string    `json:"name"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// ListHandler lists stored files, optionally filtered by the "prefix" query parameter.
+func (a *azureFileHandler) ListHandler(_ string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		prefix := c.Query("prefix")
+		if !a.authorizePrefix(c, auth.ScopeList, prefix) {
+			return
+		}
+
+		store, ok := a.store(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := storageContext(c, a.timeouts.Operation)
+		defer cancel()
+		items, err := store.ListBlobs(ctx, prefix)
+		if err != nil {
+			if a.aborted(c, ctx, prefix) {
+				return
+			}
+			a.log(c).Error("Failed to list blobs", zap.String("prefix", prefix), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to list files"))
+			return
+		}
+
+		files := make([]FileInfo, 0, len(items))
+		for _, item := range items {
+			if item.Name == nil || !a.visible(c, auth.ScopeList, *item.Name) {
+				continue
+			}
+			info := FileInfo{Name: *item.Name}
+			if p := item.Properties; p != nil {
+				if p.ContentLength != nil {
+					info.Size = *p.ContentLength
+				}
+				if p.ContentType != nil {
+					info.ContentType = *p.ContentType
+				}
+				if p.LastModified != nil {
+					info.LastModified = *p.LastModified
+				}
+			}
+			// Compressed or encrypted blobs are stored with a different
+			// length than the file the user uploaded.
+			if size, err := strconv.ParseInt(storage.MetadataValue(item.Metadata, storage.MetadataOriginalSize), 10, 64); err == nil {
+				info.Size = size
+			}
+			files = append(files, info)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"files": files})
+	}
+}
@@ -0,0 +1,76 @@
+package filehandler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/filehandler"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withIdentity stands in for the auth middleware in handler tests.
+func withIdentity(id *auth.Identity) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id != nil {
+			auth.SetIdentity(c, id)
+		}
+		c.Next()
+	}
+}
+
+func TestListHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := newMemStorageClient(map[string]string{"a-1": "x", "a-2": "yy", "b-1": "zzz"})
+	router := gin.New()
+	router.GET("/files", withIdentity(nil), filehandler.NewAzureFileHandler(client).ListHandler(""))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files?prefix=a-", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Files []filehandler.FileInfo `json:"files"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Files, 2)
+	assert.Equal(t, "a-1", resp.Files[0].Name)
+	assert.Equal(t, int64(2), resp.Files[1].Size)
+}
+
+func TestListHandler_ScopeAndPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := newMemStorageClient(map[string]string{"a-1": "x"})
+	h := filehandler.NewAzureFileHandler(client)
+	router := gin.New()
+	router.GET("/lister/files", withIdentity(&auth.Identity{ID: "k", Scopes: []string{auth.ScopeList}, PathPrefix: "a-"}), h.ListHandler(""))
+	router.GET("/uploader/files", withIdentity(&auth.Identity{ID: "k", Scopes: []string{auth.ScopeUpload}}), h.ListHandler(""))
+
+	for target, want := range map[string]int{
+		"/lister/files?prefix=a-":   http.StatusOK,
+		"/lister/files?prefix=a-1":  http.StatusOK,
+		"/lister/files":             http.StatusForbidden,
+		"/lister/files?prefix=b-":   http.StatusForbidden,
+		"/uploader/files?prefix=a-": http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		assert.Equal(t, want, w.Code, target)
+	}
+}
+
+func TestListHandler_Error(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/files", filehandler.NewAzureFileHandler(&MockStorageClient{listErr: errors.New("boom")}).ListHandler(""))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/files", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
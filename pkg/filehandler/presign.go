@@ -0,0 +1,147 @@
+package filehandler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPresignTTL = 15 * time.Minute
+	maxPresignTTL     = 7 * 24 * time.Hour
+)
+
+// PresignRequest is the JSON body accepted by PresignHandler.
+type PresignRequest struct {
+	Filename    string `json:"filename" binding:"required"`
+	Operation   string `json:"operation" binding:"required"`
+	ExpiresIn   int64  `json:"expiresIn"` // seconds
+	MaxSize     int64  `json:"maxSize"`
+	ContentType string `json:"contentType"`
+}
+
+// PresignHandler issues time-limited signed URLs for a single file and
+// operation. baseURL, when set, is prepended to the returned path so the
+// link can be handed out as-is.
+func (a *azureFileHandler) PresignHandler(baseURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.presigner == nil {
+			c.JSON(http.StatusNotFound, requestid.Error(c, "Presigned URLs are not enabled"))
+			return
+		}
+
+		var req PresignRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Invalid presign request"))
+			return
+		}
+		if req.Operation != presign.OperationUpload && req.Operation != presign.OperationDownload {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Operation must be upload or download"))
+			return
+		}
+		filename := sanitizeBlobName(req.Filename)
+		audit.Object(c, filename)
+		if strings.IndexFunc(filename, unicode.IsControl) >= 0 || filename == "" {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Invalid filename"))
+			return
+		}
+		scope := auth.ScopeDownload
+		if req.Operation == presign.OperationUpload {
+			scope = auth.ScopeUpload
+		}
+		if !a.authorize(c, scope, filename) {
+			return
+		}
+		if req.MaxSize < 0 || req.MaxSize > a.maxUploadSize.Load() {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Invalid maxSize"))
+			return
+		}
+		// The signed fields are newline-separated, so none may contain one.
+		if strings.IndexFunc(req.ContentType, unicode.IsControl) >= 0 {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Invalid contentType"))
+			return
+		}
+
+		ttl := defaultPresignTTL
+		if req.ExpiresIn > 0 {
+			ttl = time.Duration(req.ExpiresIn) * time.Second
+		}
+		if ttl > maxPresignTTL {
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "expiresIn exceeds the maximum of 7 days"))
+			return
+		}
+		expires := time.Now().Add(ttl)
+
+		params := presign.Params{
+			Operation: req.Operation,
+			Filename:  filename,
+			Expires:   expires,
+			Tenant:    tenant.Name(c),
+		}
+		path := "/download/" + url.PathEscape(filename)
+		if req.Operation == presign.OperationUpload {
+			params.MaxSize = req.MaxSize
+			params.ContentType = req.ContentType
+			path = "/upload"
+		}
+
+		signed := strings.TrimSuffix(baseURL, "/") + path + "?" + a.presigner.Sign(params).Encode()
+
+		a.log(c).Info("Issued presigned URL",
+			zap.String("filename", filename),
+			zap.String("operation", req.Operation),
+			zap.Time("expires", expires),
+			zap.String("client-ip", c.ClientIP()),
+		)
+		c.JSON(http.StatusOK, gin.H{
+			"url":       signed,
+			"method":    presignMethod(req.Operation),
+			"expiresAt": expires.UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+func presignMethod(operation string) string {
+	if operation == presign.OperationUpload {
+		return http.MethodPost
+	}
+	return http.MethodGet
+}
+
+// verifyPresigned checks a presigned request against the operation and file
+// being accessed. It returns (nil, nil) when the request is not presigned.
+func (a *azureFileHandler) verifyPresigned(c *gin.Context, operation, filename string) (*presign.Params, error) {
+	if !presign.IsPresigned(c.Request.URL.Query()) {
+		return nil, nil
+	}
+	if a.presigner == nil {
+		// Authentication middleware lets presigned requests through, so they
+		// must not be accepted unverified when presigning is off.
+		return nil, errPresignDisabled
+	}
+	p, err := a.presigner.Verify(c.Request.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if p.Operation != operation || p.Filename != filename || p.Tenant != tenant.Name(c) {
+		return nil, errPresignMismatch
+	}
+	return &p, nil
+}
+
+var (
+	errPresignMismatch = errors.New("presigned URL does not cover this request")
+	errPresignDisabled = errors.New("presigned URLs are not enabled")
+)
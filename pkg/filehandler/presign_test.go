@@ -0,0 +1,159 @@
+package filehandler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"stream-upload-file/pkg/filehandler"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPresignRouter(t *testing.T, client *memStorageClient) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	signer, err := presign.NewSigner([]byte(strings.Repeat("s", 32)))
+	require.NoError(t, err)
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithPresigner(signer))
+
+	router := gin.New()
+	router.POST("/presign", h.PresignHandler("https://files.example.com"))
+	router.POST("/upload", h.UploadHandler(""))
+	router.GET("/download/:filename", h.DownloadHandler(""))
+	return router
+}
+
+func issuePresigned(t *testing.T, router *gin.Engine, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/presign", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp struct {
+		URL string `json:"url"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, strings.TrimPrefix(resp.URL, "https://files.example.com")
+}
+
+func presignedUploadRequest(t *testing.T, target, filename, contentType, content string) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+	h.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(h)
+	require.NoError(t, err)
+	_, err = io.WriteString(part, content)
+	require.NoError(t, err)
+	writer.Close()
+	req := httptest.NewRequest("POST", target, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestPresign_Download(t *testing.T) {
+	router := newPresignRouter(t, newMemStorageClient(map[string]string{"a.txt": "alpha", "b.txt": "bravo"}))
+
+	code, target := issuePresigned(t, router, `{"filename":"a.txt","operation":"download","expiresIn":60}`)
+	require.Equal(t, http.StatusOK, code)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alpha", w.Body.String())
+
+	// The signature is bound to a.txt and cannot be replayed against b.txt.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", strings.Replace(target, "/download/a.txt", "/download/b.txt", 1), nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestPresign_UploadLimits(t *testing.T) {
+	client := newMemStorageClient(nil)
+	router := newPresignRouter(t, client)
+
+	code, target := issuePresigned(t, router, `{"filename":"in.csv","operation":"upload","maxSize":10,"contentType":"text/csv"}`)
+	require.Equal(t, http.StatusOK, code)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, presignedUploadRequest(t, target, "in.csv", "text/csv", "0123456789abc"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, presignedUploadRequest(t, target, "in.csv", "text/plain", "ok"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, presignedUploadRequest(t, target, "other.csv", "text/csv", "ok"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, presignedUploadRequest(t, target, "in.csv", "text/csv", "a,b"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "a,b", string(client.blobs["in.csv"]))
+}
+
+func TestPresign_BadRequests(t *testing.T) {
+	router := newPresignRouter(t, newMemStorageClient(nil))
+
+	for _, body := range []string{
+		`{"filename":"a.txt","operation":"delete"}`,
+		`{"filename":"a.txt","operation":"download","expiresIn":999999999}`,
+		`{"operation":"download"}`,
+		`{"filename":"a.txt","operation":"upload","contentType":"text/csv\n0\n"}`,
+	} {
+		code, _ := issuePresigned(t, router, body)
+		assert.Equal(t, http.StatusBadRequest, code, body)
+	}
+}
+
+func TestPresign_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/presign", filehandler.NewAzureFileHandler(newMemStorageClient(nil)).PresignHandler(""))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/presign", strings.NewReader(`{"filename":"a","operation":"download"}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPresign_BoundToTenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signer, err := presign.NewSigner([]byte(strings.Repeat("s", 32)))
+	require.NoError(t, err)
+	client := newMemStorageClient(map[string]string{"a.txt": "alpha"})
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithPresigner(signer))
+
+	inTenant := func(name string) gin.HandlerFunc {
+		return func(c *gin.Context) { tenant.SetTenant(c, &tenant.Tenant{Name: name}) }
+	}
+	router := gin.New()
+	router.POST("/presign", inTenant("finance"), h.PresignHandler(""))
+	router.GET("/finance/download/:filename", inTenant("finance"), h.DownloadHandler(""))
+	router.GET("/hr/download/:filename", inTenant("hr"), h.DownloadHandler(""))
+
+	code, signed := issuePresigned(t, router, `{"filename":"a.txt","operation":"download"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, signed, "tenant=finance")
+	query := signed[strings.Index(signed, "?"):]
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/finance/download/a.txt"+query, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/hr/download/a.txt"+query, nil))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
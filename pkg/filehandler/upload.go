@@ -1,47 +1,109 @@
 package filehandler
 
 import (
-	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"stream-upload-file/pkg/audit"
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/metrics"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
+// MaxUploadSize is the largest file accepted by UploadHandler unless
+// WithMaxUploadSize says otherwise.
+const MaxUploadSize = 100 * 1024 * 1024
+
+// WithMaxUploadSize sets the largest file accepted by UploadHandler and
+// allowed in presigned upload URLs.
+func WithMaxUploadSize(n int64) Option {
+	return func(a *azureFileHandler) {
+		a.maxUploadSize.Store(n)
+	}
+}
+
+// SetMaxUploadSize changes the upload size limit at runtime. Uploads
+// already in progress keep the limit they started with.
+func (a *azureFileHandler) SetMaxUploadSize(n int64) {
+	a.maxUploadSize.Store(n)
+}
+
 func (a *azureFileHandler) UploadHandler(_ string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		file, header, err := c.Request.FormFile("file")
 		if err != nil {
-			a.logger.Error("Failed to get file from request", zap.Error(err))
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get file"})
+			a.log(c).Error("Failed to get file from request", zap.Error(err))
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Failed to get file"))
 			return
 		}
 		defer file.Close()
 
 		filename := sanitizeFilename(header.Filename)
+		if dir := sanitizeBlobName(c.Request.FormValue("path")); dir != "" {
+			filename = dir + "/" + filename
+		}
+		audit.Object(c, filename)
 
-		a.logger.Info("File upload attempt",
+		a.log(c).Info("File upload attempt",
 			zap.String("filename", filename),
 			zap.Int64("size", header.Size),
 			zap.String("content-type", header.Header.Get("Content-Type")),
 			zap.String("client-ip", c.ClientIP()),
+			identityField(c),
 		)
 
-		if header.Size > 100*1024*1024 {
-			a.logger.Warn("File too large", zap.Int64("size", header.Size))
-			c.JSON(http.StatusBadRequest, gin.H{"error": "File too large (max 100MB)"})
+		if maxSize := a.maxUploadSize.Load(); header.Size > maxSize {
+			a.log(c).Warn("File too large", zap.Int64("size", header.Size))
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "File too large (max "+formatSize(maxSize)+")"))
 			return
 		}
 
-		ctx := context.Background()
 		contentType := header.Header.Get("Content-Type")
 		if contentType == "" {
 			contentType = "application/octet-stream"
 		}
+		ctx, cancel := storageContext(c, a.timeouts.Upload)
+		defer cancel()
+		ctx, span, endSpan := startSpan(c, ctx, "UploadHandler", filename,
+			attribute.Int64("file.size", header.Size), attribute.String("file.content_type", contentType))
+		defer endSpan()
+
+		signed, err := a.verifyPresigned(c, presign.OperationUpload, filename)
+		if err != nil {
+			a.log(c).Warn("Rejected presigned upload", zap.String("filename", filename), zap.Error(err))
+			c.JSON(http.StatusForbidden, requestid.Error(c, "Invalid or expired presigned URL"))
+			return
+		}
+		if signed != nil {
+			if signed.MaxSize > 0 && header.Size > signed.MaxSize {
+				c.JSON(http.StatusRequestEntityTooLarge, requestid.Error(c, "File exceeds the size allowed by the presigned URL"))
+				return
+			}
+			if signed.ContentType != "" && !strings.EqualFold(signed.ContentType, contentType) {
+				c.JSON(http.StatusUnsupportedMediaType, requestid.Error(c, "Content type not allowed by the presigned URL"))
+				return
+			}
+		} else if !a.authorize(c, auth.ScopeUpload, filename) {
+			return
+		}
+
+		store, ok := a.store(c)
+		if !ok {
+			return
+		}
 
 		options := azblob.UploadStreamOptions{
 			HTTPHeaders: &blob.HTTPHeaders{
@@ -49,18 +111,39 @@ func (a *azureFileHandler) UploadHandler(_ string) gin.HandlerFunc {
 			},
 			Metadata: map[string]*string{
 				"originalName": stringPtr(header.Filename),
-				"uploadedBy":   stringPtr(c.GetHeader("User-Agent")),
+				"uploadedBy":   stringPtr(uploadedBy(c)),
+				// Lets downloads of blobs compressed at rest report the real length.
+				storage.MetadataOriginalSize: stringPtr(strconv.FormatInt(header.Size, 10)),
 			},
 		}
 
-		err = a.storageClient.UploadBlob(ctx, filename, file, &options)
+		reservation, ok := a.reserveQuota(c, ctx, store, filename, header.Size)
+		if !ok {
+			return
+		}
+
+		transfer := metrics.StartTransfer(metrics.Upload)
+		defer transfer.Done()
+		hash := sha256.New()
+		// Blocks are staged as they stream and only committed once the whole
+		// file is in, so an upload cut short leaves no object behind.
+		err = store.UploadBlob(ctx, filename, a.throttledReader(c, io.TeeReader(transfer.Reader(file), hash)), &options)
 		if err != nil {
-			a.logger.Error("Failed to upload to Azure Blob", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store file"})
+			reservation.Release()
+			if a.aborted(c, ctx, filename) {
+				return
+			}
+			a.log(c).Error("Failed to upload to Azure Blob", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to store file"))
 			return
 		}
+		reservation.Commit()
 
-		a.logger.Info("File uploaded and overwritten successfully", zap.String("filename", filename))
+		checksum := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		span.SetAttributes(attribute.String("file.checksum", checksum))
+		audit.Transfer(c, header.Size, checksum)
+		a.notifyUploaded(c, filename, header.Size, contentType, checksum, options.Metadata)
+		a.log(c).Info("File uploaded and overwritten successfully", zap.String("filename", filename))
 		c.JSON(http.StatusOK, gin.H{
 			"message":   "File uploaded and overwritten successfully",
 			"filename":  filename,
@@ -75,6 +158,30 @@ func sanitizeFilename(filename string) string {
 	return filename
 }
 
+// sanitizeBlobName cleans a slash-separated blob name such as
+// "team-x/reports/q1.csv". Empty, "." and ".." segments are dropped so a
+// name can never climb out of its prefix.
+func sanitizeBlobName(name string) string {
+	parts := strings.Split(name, "/")
+	kept := parts[:0]
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." {
+			continue
+		}
+		kept = append(kept, strings.ReplaceAll(p, " ", "_"))
+	}
+	return strings.Join(kept, "/")
+}
+
+// formatSize renders whole mebibytes as e.g. "100MB" and anything else in
+// bytes.
+func formatSize(n int64) string {
+	if n%(1<<20) == 0 {
+		return strconv.FormatInt(n>>20, 10) + "MB"
+	}
+	return strconv.FormatInt(n, 10) + " bytes"
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
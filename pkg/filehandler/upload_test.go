@@ -9,6 +9,8 @@ import (
 	"strings"
 	"testing"
 
+	"stream-upload-file/pkg/filehandler"
+
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -108,3 +110,44 @@ func TestUploadHandler_SanitizeFileName(t *testing.T) {
 	assert.Contains(t, resp.Body.String(), "test_file.txt") // Check if the filename was sanitized
 	assert.Equal(t, writer.FormDataContentType(), req.Header.Get("Content-Type"))
 }
+
+func TestUploadHandler_MaxUploadSize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := newMemStorageClient(nil)
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithMaxUploadSize(8))
+	router := gin.New()
+	router.POST("/upload", h.UploadHandler(""))
+
+	req, _ := createMultipartRequest(t, "file", "small.txt", "12345678")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	req, _ = createMultipartRequest(t, "file", "big.txt", "123456789")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), "File too large (max 8 bytes)")
+	assert.NotContains(t, client.blobs, "big.txt")
+}
+
+func TestUploadHandler_SetMaxUploadSize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := newMemStorageClient(nil)
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithMaxUploadSize(8))
+	router := gin.New()
+	router.POST("/upload", h.UploadHandler(""))
+
+	h.SetMaxUploadSize(16)
+	req, _ := createMultipartRequest(t, "file", "grown.txt", "123456789")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	h.SetMaxUploadSize(4)
+	req, _ = createMultipartRequest(t, "file", "shrunk.txt", "12345")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), "File too large (max 4 bytes)")
+}
@@ -0,0 +1,116 @@
+package filehandler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/quota"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+	"stream-upload-file/pkg/tenant"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// UsageTracker accounts for stored bytes and objects and enforces quotas.
+// quota.Tracker implements it.
+type UsageTracker interface {
+	Reserve(tenant, user string, size int64, prev *quota.Object) (*quota.Reservation, error)
+	Check(tenant, user string, size int64) error
+	Remove(tenant string, o quota.Object)
+	Report(tenant, user string) quota.Report
+}
+
+// WithUsageTracker records uploads and deletes in usage and rejects uploads
+// that would exceed a quota.
+func WithUsageTracker(usage UsageTracker) Option {
+	return func(a *azureFileHandler) {
+		a.usage = usage
+	}
+}
+
+// UsageHandler reports the storage used by the caller's tenant and by the
+// caller.
+func (a *azureFileHandler) UsageHandler(_ string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.usage == nil {
+			c.JSON(http.StatusNotFound, requestid.Error(c, "Usage tracking is not enabled"))
+			return
+		}
+		user := ""
+		if id, ok := auth.FromContext(c); ok {
+			user = id.ID
+		}
+		c.JSON(http.StatusOK, a.usage.Report(tenant.Name(c), user))
+	}
+}
+
+// reserveQuota accounts for an upload of size bytes to name and responds
+// with 507 if it doesn't fit. The reservation is nil when usage isn't
+// tracked.
+func (a *azureFileHandler) reserveQuota(c *gin.Context, ctx context.Context, store blobProperties, name string, size int64) (*quota.Reservation, bool) {
+	if a.usage == nil {
+		return nil, true
+	}
+	prev, err := existingObject(ctx, store, name)
+	if err != nil {
+		// Counted as a new object; the next reconcile corrects the usage.
+		a.log(c).Warn("Failed to look up blob for quota accounting", zap.String("filename", name), zap.Error(err))
+	}
+	reservation, err := a.usage.Reserve(tenant.Name(c), quotaUser(c), size, prev)
+	if err != nil {
+		a.quotaExceeded(c, name, size, err)
+		return nil, false
+	}
+	return reservation, true
+}
+
+// quotaUser is who an upload counts against: the authenticated identity,
+// or no one for anonymous requests, which only the tenant limit applies to.
+// Anything an anonymous client sends, such as its User-Agent, is its own
+// to change.
+func quotaUser(c *gin.Context) string {
+	if id, ok := auth.FromContext(c); ok {
+		return id.ID
+	}
+	return ""
+}
+
+func (a *azureFileHandler) quotaExceeded(c *gin.Context, name string, size int64, err error) {
+	if !errors.Is(err, quota.ErrQuotaExceeded) {
+		a.log(c).Error("Quota check failed", zap.String("filename", name), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, requestid.Error(c, "Quota check failed"))
+		return
+	}
+	a.log(c).Warn("Upload rejected by quota",
+		zap.String("filename", name),
+		zap.Int64("size", size),
+		zap.String("tenant", tenant.Name(c)),
+		identityField(c),
+		zap.Error(err),
+	)
+	c.JSON(http.StatusInsufficientStorage, requestid.Error(c, "Storage quota exceeded"))
+}
+
+// blobProperties looks up a single blob; StorageClient and DirectUploader
+// both do.
+type blobProperties interface {
+	GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error)
+}
+
+// existingObject returns the blob called name, or nil if there is none.
+func existingObject(ctx context.Context, store blobProperties, name string) (*quota.Object, error) {
+	props, err := store.GetBlobProperties(ctx, name)
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	o := quota.ObjectFromProperties(props)
+	return &o, nil
+}
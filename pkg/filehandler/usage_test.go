@@ -0,0 +1,79 @@
+package filehandler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/filehandler"
+	"stream-upload-file/pkg/quota"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageTracker_EnforcesQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	client := newMemStorageClient(nil)
+	tracker := quota.NewTracker(&quota.Config{Default: quota.TenantLimits{Total: quota.Limit{MaxBytes: 10}}})
+	h := filehandler.NewAzureFileHandler(client, filehandler.WithUsageTracker(tracker))
+	router := gin.New()
+	router.Use(withIdentity(&auth.Identity{ID: "alice"}))
+	router.POST("/upload", h.UploadHandler(""))
+	router.DELETE("/files/*filename", h.DeleteHandler(""))
+	router.GET("/usage", h.UsageHandler(""))
+
+	upload := func(name, content string) int {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		part, err := mw.CreateFormFile("file", name)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		mw.Close()
+		req := httptest.NewRequest("POST", "/upload", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	usage := func() quota.Report {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/usage", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var r quota.Report
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+		return r
+	}
+
+	assert.Equal(t, http.StatusOK, upload("a.txt", "123456"))
+	assert.Equal(t, http.StatusInsufficientStorage, upload("b.txt", "123456"))
+	assert.NotContains(t, client.blobs, "b.txt")
+	// Overwriting only counts the difference.
+	assert.Equal(t, http.StatusOK, upload("a.txt", "12345678"))
+
+	r := usage()
+	assert.Equal(t, quota.Usage{Bytes: 8, Objects: 1}, r.Usage)
+	assert.Equal(t, int64(10), r.Limit.MaxBytes)
+	assert.Equal(t, "alice", r.User)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/files/a.txt", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, quota.Usage{}, usage().Usage)
+	assert.Equal(t, http.StatusOK, upload("b.txt", "123456"))
+	assert.Zero(t, client.lists, "existing files are looked up by name, not by listing")
+}
+
+func TestUsageHandler_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/usage", filehandler.NewAzureFileHandler(newMemStorageClient(nil)).UsageHandler(""))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/usage", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
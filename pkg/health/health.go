@@ -0,0 +1,274 @@
+// Package health probes the service's dependencies in the background and
+// derives readiness from the results, so that a pod whose storage has become
+// unreachable stops receiving traffic.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Dependency states, as reported by the deep health endpoint.
+const (
+	StatusUp      = "up"
+	StatusDown    = "down"
+	StatusUnknown = "unknown"
+)
+
+// Overall states. Unavailable means a critical dependency is not up; the
+// service is then unready.
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+)
+
+var dependencyUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "health_dependency_up",
+	Help: "1 while a dependency is considered up, 0 otherwise.",
+}, []string{"dependency"})
+
+// Probe checks one dependency. It should make a cheap call, such as reading
+// metadata, and return promptly when ctx is done.
+type Probe func(ctx context.Context) error
+
+// Config controls how often dependencies are probed and how many results it
+// takes to change their state.
+type Config struct {
+	// Interval between probes. Defaults to 15s.
+	Interval time.Duration
+	// Timeout of each probe. Defaults to 5s.
+	Timeout time.Duration
+	// FailureThreshold is the number of consecutive failures that mark a
+	// dependency down. Defaults to 3.
+	FailureThreshold int
+	// SuccessThreshold is the number of consecutive successes that mark a
+	// down dependency up again. Defaults to 2.
+	SuccessThreshold int
+	// OnChange, if set, is called when readiness changes.
+	OnChange func(ready bool)
+}
+
+// Monitor probes dependencies and tracks their state.
+type Monitor struct {
+	cfg    Config
+	logger *zap.Logger
+
+	mu    sync.RWMutex
+	deps  []*dependency
+	ready bool
+}
+
+type dependency struct {
+	name     string
+	critical bool
+	probe    Probe
+
+	status    string
+	failures  int
+	successes int
+	lastCheck time.Time
+	latency   time.Duration
+	lastErr   string
+}
+
+// NewMonitor returns a monitor with no dependencies, which is ready.
+func NewMonitor(cfg Config) *Monitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 15 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.SuccessThreshold <= 0 {
+		cfg.SuccessThreshold = 2
+	}
+	return &Monitor{
+		cfg:    cfg,
+		logger: zap.L().Named("health"),
+		ready:  true,
+	}
+}
+
+// Add registers a dependency. Critical dependencies make the service unready
+// while they are down, and until their first probe has succeeded; the others
+// are only reported. Add must be called before Run.
+func (m *Monitor) Add(name string, critical bool, probe Probe) {
+	m.mu.Lock()
+	m.deps = append(m.deps, &dependency{name: name, critical: critical, probe: probe, status: StatusUnknown})
+	m.mu.Unlock()
+	m.update()
+}
+
+// Run probes every dependency immediately and then at each interval until
+// ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check probes every dependency once, concurrently, and records the results.
+func (m *Monitor) Check(ctx context.Context) {
+	m.mu.RLock()
+	deps := m.deps
+	m.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, d := range deps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+			defer cancel()
+			start := time.Now()
+			err := d.probe(probeCtx)
+			m.record(d, err, time.Since(start))
+		}()
+	}
+	wg.Wait()
+	m.update()
+}
+
+// record applies one probe result. The first result sets the state
+// directly; after that it takes FailureThreshold failures in a row to go
+// down and SuccessThreshold successes in a row to come back.
+func (m *Monitor) record(d *dependency, err error, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d.lastCheck = time.Now()
+	d.latency = latency
+	d.lastErr = ""
+	if err != nil {
+		d.lastErr = err.Error()
+		d.failures++
+		d.successes = 0
+	} else {
+		d.successes++
+		d.failures = 0
+	}
+
+	prev := d.status
+	switch {
+	case d.status == StatusUnknown && err != nil:
+		d.status = StatusDown
+	case d.status == StatusUnknown:
+		d.status = StatusUp
+	case d.status == StatusUp && d.failures >= m.cfg.FailureThreshold:
+		d.status = StatusDown
+	case d.status == StatusDown && d.successes >= m.cfg.SuccessThreshold:
+		d.status = StatusUp
+	}
+	if err != nil && d.status == StatusUp {
+		m.logger.Warn("Dependency probe failed",
+			zap.String("dependency", d.name),
+			zap.Int("consecutiveFailures", d.failures),
+			zap.Error(err),
+		)
+	}
+	if d.status == prev {
+		return
+	}
+	if d.status == StatusUp {
+		dependencyUp.WithLabelValues(d.name).Set(1)
+		m.logger.Info("Dependency is up", zap.String("dependency", d.name))
+	} else {
+		dependencyUp.WithLabelValues(d.name).Set(0)
+		m.logger.Error("Dependency is down", zap.String("dependency", d.name), zap.String("error", d.lastErr))
+	}
+}
+
+// update recomputes readiness and reports a change.
+func (m *Monitor) update() {
+	m.mu.Lock()
+	ready := true
+	for _, d := range m.deps {
+		if d.critical && d.status != StatusUp {
+			ready = false
+		}
+	}
+	changed := ready != m.ready
+	m.ready = ready
+	m.mu.Unlock()
+
+	if changed && m.cfg.OnChange != nil {
+		m.cfg.OnChange(ready)
+	}
+}
+
+// Ready reports whether every critical dependency is up.
+func (m *Monitor) Ready() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ready
+}
+
+// DependencyStatus is the state of one dependency in a Report.
+type DependencyStatus struct {
+	Status              string    `json:"status"`
+	Critical            bool      `json:"critical"`
+	LastCheck           time.Time `json:"lastCheck,omitzero"`
+	LatencyMs           int64     `json:"latencyMs"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Error               string    `json:"error,omitempty"`
+}
+
+// Report is the body of the deep health endpoint.
+type Report struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Report returns the state of every dependency as of its last probe.
+func (m *Monitor) Report() Report {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r := Report{Status: StatusOK, Dependencies: make(map[string]DependencyStatus, len(m.deps))}
+	for _, d := range m.deps {
+		r.Dependencies[d.name] = DependencyStatus{
+			Status:              d.status,
+			Critical:            d.critical,
+			LastCheck:           d.lastCheck,
+			LatencyMs:           d.latency.Milliseconds(),
+			ConsecutiveFailures: d.failures,
+			Error:               d.lastErr,
+		}
+		if d.status != StatusUp && r.Status == StatusOK {
+			r.Status = StatusDegraded
+		}
+	}
+	if !m.ready {
+		r.Status = StatusUnavailable
+	}
+	return r
+}
+
+// Handler serves the Report, with 503 while the service is unready. It
+// doesn't probe, so it is cheap to call often.
+func (m *Monitor) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := m.Report()
+		status := http.StatusOK
+		if r.Status == StatusUnavailable {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, r)
+	}
+}
@@ -0,0 +1,111 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/health"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyProbe fails while err is set.
+type flakyProbe struct {
+	err error
+}
+
+func (f *flakyProbe) probe(context.Context) error {
+	return f.err
+}
+
+func TestMonitor_Hysteresis(t *testing.T) {
+	var changes []bool
+	m := health.NewMonitor(health.Config{
+		FailureThreshold: 3,
+		SuccessThreshold: 2,
+		OnChange:         func(ready bool) { changes = append(changes, ready) },
+	})
+	storage := &flakyProbe{}
+	m.Add("storage", true, storage.probe)
+	assert.False(t, m.Ready(), "unready until the first probe succeeds")
+
+	ctx := context.Background()
+	m.Check(ctx)
+	assert.True(t, m.Ready())
+
+	storage.err = errors.New("AuthenticationFailed")
+	m.Check(ctx)
+	m.Check(ctx)
+	assert.True(t, m.Ready(), "two failures are tolerated")
+	m.Check(ctx)
+	assert.False(t, m.Ready())
+
+	storage.err = nil
+	m.Check(ctx)
+	assert.False(t, m.Ready(), "one success is not enough to recover")
+	m.Check(ctx)
+	assert.True(t, m.Ready())
+
+	assert.Equal(t, []bool{false, true, false, true}, changes)
+}
+
+func TestMonitor_FirstFailureIsImmediate(t *testing.T) {
+	m := health.NewMonitor(health.Config{})
+	m.Add("storage", true, (&flakyProbe{err: errors.New("ContainerNotFound")}).probe)
+	m.Check(context.Background())
+	assert.False(t, m.Ready())
+	assert.Equal(t, health.StatusDown, m.Report().Dependencies["storage"].Status)
+}
+
+func TestMonitor_ProbeTimeout(t *testing.T) {
+	m := health.NewMonitor(health.Config{Timeout: 10 * time.Millisecond})
+	m.Add("storage", true, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	m.Check(context.Background())
+	assert.False(t, m.Ready())
+	assert.Contains(t, m.Report().Dependencies["storage"].Error, "deadline exceeded")
+}
+
+func TestHandler_ReportsDependencies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := health.NewMonitor(health.Config{})
+	storage := &flakyProbe{}
+	events := &flakyProbe{err: errors.New("nats: connection closed")}
+	m.Add("storage", true, storage.probe)
+	m.Add("events", false, events.probe)
+	m.Check(context.Background())
+
+	router := gin.New()
+	router.GET("/healthz/deep", m.Handler())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz/deep", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "non-critical dependencies don't affect readiness")
+	var report health.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, health.StatusDegraded, report.Status)
+	assert.Equal(t, health.StatusUp, report.Dependencies["storage"].Status)
+	assert.True(t, report.Dependencies["storage"].Critical)
+	assert.Equal(t, health.StatusDown, report.Dependencies["events"].Status)
+	assert.Equal(t, "nats: connection closed", report.Dependencies["events"].Error)
+
+	storage.err = errors.New("AuthorizationFailure")
+	for range 3 {
+		m.Check(context.Background())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz/deep", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, health.StatusUnavailable, report.Status)
+	assert.Equal(t, 3, report.Dependencies["storage"].ConsecutiveFailures)
+}
@@ -0,0 +1,72 @@
+// Package metrics exposes Prometheus metrics about HTTP requests, file
+// transfers and readiness. Other packages register their own metrics in the
+// default registry; all of them are served on /metrics.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// unmatchedRoute labels requests that matched no route, so that scans of
+// random paths don't create new series.
+const unmatchedRoute = "unmatched"
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "http_request_duration_seconds",
+		Help: "Time to serve HTTP requests, including streaming the body.",
+		// Uploads and downloads of large files take minutes.
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"route", "method", "status"})
+
+	transferBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_transfer_bytes_total",
+		Help: "File bytes received from or sent to clients.",
+	}, []string{"direction"})
+
+	transfersInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "file_transfers_in_flight",
+		Help: "Uploads and downloads currently streaming.",
+	}, []string{"direction"})
+
+	readyGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "service_ready",
+		Help: "1 while the readiness probe reports ready, 0 otherwise.",
+	})
+)
+
+// Middleware counts and times requests by route template, e.g.
+// "/download/*filename", rather than by path.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		requestsTotal.WithLabelValues(route, c.Request.Method, status).Inc()
+		requestDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// SetReady records the readiness state.
+func SetReady(ready bool) {
+	if ready {
+		readyGauge.Set(1)
+	} else {
+		readyGauge.Set(0)
+	}
+}
@@ -0,0 +1,54 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"stream-upload-file/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_LabelsByRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(metrics.Middleware())
+	router.GET("/download/*filename", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	for _, path := range []string{"/download/a.txt", "/download/b.txt", "/nope"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	expected := `
+# HELP http_requests_total HTTP requests by route, method and status code.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",route="/download/*filename",status="404"} 2
+http_requests_total{method="GET",route="unmatched",status="404"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "http_requests_total"))
+	count, err := testutil.GatherAndCount(prometheus.DefaultGatherer, "http_request_duration_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestSetReady(t *testing.T) {
+	metrics.SetReady(true)
+	require.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(`
+# HELP service_ready 1 while the readiness probe reports ready, 0 otherwise.
+# TYPE service_ready gauge
+service_ready 1
+`), "service_ready"))
+
+	metrics.SetReady(false)
+	require.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(`
+# HELP service_ready 1 while the readiness probe reports ready, 0 otherwise.
+# TYPE service_ready gauge
+service_ready 0
+`), "service_ready"))
+}
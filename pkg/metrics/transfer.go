@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Transfer directions, used as the "direction" label.
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Transfer tracks one upload or download: it is in flight from
+// StartTransfer until Done, and counts the bytes passing through its
+// reader or writer.
+type Transfer struct {
+	bytes prometheus.Counter
+	done  sync.Once
+	gauge prometheus.Gauge
+}
+
+// StartTransfer marks a transfer in direction as in flight.
+func StartTransfer(direction string) *Transfer {
+	t := &Transfer{
+		bytes: transferBytes.WithLabelValues(direction),
+		gauge: transfersInFlight.WithLabelValues(direction),
+	}
+	t.gauge.Inc()
+	return t
+}
+
+// Done marks the transfer as finished. It is safe to call more than once.
+func (t *Transfer) Done() {
+	t.done.Do(t.gauge.Dec)
+}
+
+// Reader counts the bytes read from r.
+func (t *Transfer) Reader(r io.Reader) io.Reader {
+	return &countingReader{r: r, bytes: t.bytes}
+}
+
+// Writer counts the bytes written to w.
+func (t *Transfer) Writer(w io.Writer) io.Writer {
+	return &countingWriter{w: w, bytes: t.bytes}
+}
+
+type countingReader struct {
+	r     io.Reader
+	bytes prometheus.Counter
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.bytes.Add(float64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w     io.Writer
+	bytes prometheus.Counter
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.bytes.Add(float64(n))
+	return n, err
+}
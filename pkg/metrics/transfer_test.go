@@ -0,0 +1,44 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"stream-upload-file/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransfer(t *testing.T) {
+	up := metrics.StartTransfer(metrics.Upload)
+	down := metrics.StartTransfer(metrics.Download)
+	_, err := io.Copy(io.Discard, up.Reader(strings.NewReader("12345")))
+	require.NoError(t, err)
+	_, err = io.Copy(down.Writer(&bytes.Buffer{}), strings.NewReader("123"))
+	require.NoError(t, err)
+
+	require.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(`
+# HELP file_transfer_bytes_total File bytes received from or sent to clients.
+# TYPE file_transfer_bytes_total counter
+file_transfer_bytes_total{direction="download"} 3
+file_transfer_bytes_total{direction="upload"} 5
+# HELP file_transfers_in_flight Uploads and downloads currently streaming.
+# TYPE file_transfers_in_flight gauge
+file_transfers_in_flight{direction="download"} 1
+file_transfers_in_flight{direction="upload"} 1
+`), "file_transfer_bytes_total", "file_transfers_in_flight"))
+
+	up.Done()
+	up.Done()
+	down.Done()
+	require.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(`
+# HELP file_transfers_in_flight Uploads and downloads currently streaming.
+# TYPE file_transfers_in_flight gauge
+file_transfers_in_flight{direction="download"} 0
+file_transfers_in_flight{direction="upload"} 0
+`), "file_transfers_in_flight"))
+}
@@ -0,0 +1,294 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"stream-upload-file/pkg/auth"
+
+	"go.uber.org/zap"
+)
+
+// Subject prefixes used in bindings. "*" matches any authenticated caller.
+const (
+	SubjectUser  = "user:"
+	SubjectGroup = "group:"
+	SubjectAny   = "*"
+)
+
+// Policy is the on-disk form of an RBAC policy.
+type Policy struct {
+	Roles    map[string]Role `json:"roles"`
+	Bindings []Binding       `json:"bindings"`
+}
+
+// Role grants operations on the blobs matching any of its path globs. A
+// "*" matches within one path segment and "**" matches any number of them.
+type Role struct {
+	Operations []string `json:"operations"`
+	Paths      []string `json:"paths"`
+}
+
+// Binding assigns a role to subjects such as "user:alice" or "group:team-x".
+type Binding struct {
+	Role     string   `json:"role"`
+	Subjects []string `json:"subjects"`
+}
+
+// Decision is the outcome of evaluating a request against the policy.
+type Decision struct {
+	Allowed bool
+	// Role is the role that granted access.
+	Role string
+}
+
+// Engine evaluates requests against a policy file. Anything the policy does
+// not explicitly allow is denied. The file is re-read when it changes; an
+// invalid file is rejected and the previous policy stays in force.
+type Engine struct {
+	path      string
+	logger    *zap.Logger
+	decisions *zap.Logger
+
+	policy  atomic.Pointer[Policy]
+	mu      sync.Mutex
+	lastRaw []byte
+}
+
+// Load reads the policy file at path.
+func Load(path string) (*Engine, error) {
+	e := &Engine{
+		path:      path,
+		logger:    zap.L().Named("policy"),
+		decisions: zap.L().Named("policy-decisions"),
+	}
+	if _, err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload re-reads the policy file and reports whether it changed.
+func (e *Engine) Reload() (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	raw, err := os.ReadFile(e.path)
+	if err != nil {
+		return false, err
+	}
+	if e.lastRaw != nil && bytes.Equal(raw, e.lastRaw) {
+		return false, nil
+	}
+	var p Policy
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return false, fmt.Errorf("policy %s: %w", e.path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return false, fmt.Errorf("policy %s: %w", e.path, err)
+	}
+	e.policy.Store(&p)
+	e.lastRaw = raw
+	e.logger.Info("Loaded RBAC policy", zap.Int("roles", len(p.Roles)), zap.Int("bindings", len(p.Bindings)))
+	return true, nil
+}
+
+// Watch polls the policy file every interval until ctx is done.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := e.Reload(); err != nil {
+				e.logger.Error("Failed to reload RBAC policy, keeping previous one", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Validate checks that every binding refers to a defined role and every
+// role uses known operations and well-formed globs.
+func (p *Policy) Validate() error {
+	for name, role := range p.Roles {
+		if len(role.Operations) == 0 || len(role.Paths) == 0 {
+			return fmt.Errorf("role %q needs operations and paths", name)
+		}
+		for _, op := range role.Operations {
+			switch op {
+			case auth.ScopeUpload, auth.ScopeDownload, auth.ScopeList, auth.ScopeDelete:
+			default:
+				return fmt.Errorf("role %q has unknown operation %q", name, op)
+			}
+		}
+		for _, glob := range role.Paths {
+			for _, seg := range strings.Split(glob, "/") {
+				if _, err := path.Match(seg, ""); err != nil {
+					return fmt.Errorf("role %q has invalid path %q", name, glob)
+				}
+			}
+		}
+	}
+	for i, b := range p.Bindings {
+		if _, ok := p.Roles[b.Role]; !ok {
+			return fmt.Errorf("binding %d refers to unknown role %q", i, b.Role)
+		}
+		for _, s := range b.Subjects {
+			if s != SubjectAny && !strings.HasPrefix(s, SubjectUser) && !strings.HasPrefix(s, SubjectGroup) {
+				return fmt.Errorf("binding %d has invalid subject %q", i, s)
+			}
+		}
+	}
+	if len(p.Bindings) == 0 {
+		return errors.New("policy has no bindings")
+	}
+	return nil
+}
+
+// Decide evaluates whether id may perform operation on the named blob.
+func (e *Engine) Decide(id *auth.Identity, operation, name string) Decision {
+	return e.decide(id, operation, func(glob string) bool { return matchGlob(glob, name) })
+}
+
+// DecidePrefix evaluates an operation over every blob under prefix, such
+// as a listing. It allows the request when some granted path could fall
+// under the prefix; callers are expected to filter the results with Decide.
+func (e *Engine) DecidePrefix(id *auth.Identity, operation, prefix string) Decision {
+	return e.decide(id, operation, func(glob string) bool {
+		lit := literalPrefix(glob)
+		return strings.HasPrefix(lit, prefix) || strings.HasPrefix(prefix, lit)
+	})
+}
+
+func (e *Engine) decide(id *auth.Identity, operation string, match func(glob string) bool) Decision {
+	if id == nil {
+		return Decision{}
+	}
+	p := e.policy.Load()
+	for _, b := range p.Bindings {
+		if !bound(b.Subjects, id) {
+			continue
+		}
+		role := p.Roles[b.Role]
+		if !contains(role.Operations, operation) {
+			continue
+		}
+		for _, glob := range role.Paths {
+			if match(glob) {
+				return Decision{Allowed: true, Role: b.Role}
+			}
+		}
+	}
+	return Decision{}
+}
+
+// Authorize decides a request on a single blob and records the decision.
+func (e *Engine) Authorize(id *auth.Identity, operation, name string) bool {
+	d := e.Decide(id, operation, name)
+	e.log(id, operation, name, d)
+	return d.Allowed
+}
+
+// AuthorizePrefix decides a request on a prefix and records the decision.
+func (e *Engine) AuthorizePrefix(id *auth.Identity, operation, prefix string) bool {
+	d := e.DecidePrefix(id, operation, prefix)
+	e.log(id, operation, prefix, d)
+	return d.Allowed
+}
+
+// Allows is Decide without the decision log, for filtering listings.
+func (e *Engine) Allows(id *auth.Identity, operation, name string) bool {
+	return e.Decide(id, operation, name).Allowed
+}
+
+func (e *Engine) log(id *auth.Identity, operation, resource string, d Decision) {
+	subject, method := "anonymous", ""
+	if id != nil {
+		subject, method = id.ID, id.Method
+	}
+	decision := "deny"
+	if d.Allowed {
+		decision = "allow"
+	}
+	e.decisions.Info("Policy decision",
+		zap.String("decision", decision),
+		zap.String("subject", subject),
+		zap.String("method", method),
+		zap.String("operation", operation),
+		zap.String("resource", resource),
+		zap.String("role", d.Role),
+	)
+}
+
+func bound(subjects []string, id *auth.Identity) bool {
+	for _, s := range subjects {
+		switch {
+		case s == SubjectAny:
+			return true
+		case strings.HasPrefix(s, SubjectUser):
+			if s[len(SubjectUser):] == id.ID {
+				return true
+			}
+		case strings.HasPrefix(s, SubjectGroup):
+			if contains(id.Groups, s[len(SubjectGroup):]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash-separated name against a glob in which "**"
+// spans any number of segments, including none.
+func matchGlob(glob, name string) bool {
+	return matchSegments(strings.Split(glob, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(glob, name []string) bool {
+	for len(glob) > 0 {
+		if glob[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(glob[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(glob[0], name[0]); !ok {
+			return false
+		}
+		glob, name = glob[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// literalPrefix returns the part of a glob before its first wildcard.
+func literalPrefix(glob string) string {
+	if i := strings.IndexAny(glob, `*?[\`); i >= 0 {
+		return glob[:i]
+	}
+	return glob
+}
@@ -0,0 +1,110 @@
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/policy"
+)
+
+const teamPolicy = `{
+  "roles": {
+    "team-x-rw": {"operations": ["upload", "download", "list", "delete"], "paths": ["team-x/**"]},
+    "reports-ro": {"operations": ["download", "list"], "paths": ["team-*/reports/*.csv"]},
+    "public-ro": {"operations": ["download"], "paths": ["public/**"]}
+  },
+  "bindings": [
+    {"role": "team-x-rw", "subjects": ["group:team-x"]},
+    {"role": "reports-ro", "subjects": ["user:auditor"]},
+    {"role": "public-ro", "subjects": ["*"]}
+  ]
+}`
+
+func loadPolicy(t *testing.T, content string) (*policy.Engine, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	e, err := policy.Load(path)
+	require.NoError(t, err)
+	return e, path
+}
+
+func TestEngine_Decide(t *testing.T) {
+	e, _ := loadPolicy(t, teamPolicy)
+	member := &auth.Identity{ID: "alice", Groups: []string{"team-x"}}
+	auditor := &auth.Identity{ID: "auditor"}
+	outsider := &auth.Identity{ID: "bob", Groups: []string{"team-y"}}
+
+	cases := []struct {
+		id        *auth.Identity
+		operation string
+		name      string
+		allowed   bool
+	}{
+		{member, auth.ScopeUpload, "team-x/a.txt", true},
+		{member, auth.ScopeDelete, "team-x/deep/nested/b.bin", true},
+		{member, auth.ScopeDownload, "team-y/a.txt", false},
+		{member, auth.ScopeUpload, "team-x-other/a.txt", false},
+		{auditor, auth.ScopeDownload, "team-y/reports/q1.csv", true},
+		{auditor, auth.ScopeDownload, "team-y/reports/2024/q1.csv", false},
+		{auditor, auth.ScopeUpload, "team-y/reports/q1.csv", false},
+		{outsider, auth.ScopeDownload, "public/logo.png", true},
+		{outsider, auth.ScopeUpload, "public/logo.png", false},
+		{nil, auth.ScopeDownload, "public/logo.png", false},
+	}
+	for _, tc := range cases {
+		d := e.Decide(tc.id, tc.operation, tc.name)
+		assert.Equal(t, tc.allowed, d.Allowed, "%v %s %s", tc.id, tc.operation, tc.name)
+	}
+	assert.Equal(t, "team-x-rw", e.Decide(member, auth.ScopeList, "team-x/a").Role)
+}
+
+func TestEngine_DecidePrefix(t *testing.T) {
+	e, _ := loadPolicy(t, teamPolicy)
+	member := &auth.Identity{ID: "alice", Groups: []string{"team-x"}}
+
+	for prefix, allowed := range map[string]bool{
+		"":               true, // results are filtered down to team-x/
+		"team-x/":        true,
+		"team-x/reports": true,
+		"team-y/":        false,
+		"public/":        false, // public-ro grants no list
+	} {
+		assert.Equal(t, allowed, e.DecidePrefix(member, auth.ScopeList, prefix).Allowed, prefix)
+	}
+}
+
+func TestEngine_ReloadKeepsLastGoodPolicy(t *testing.T) {
+	e, path := loadPolicy(t, teamPolicy)
+	member := &auth.Identity{ID: "alice", Groups: []string{"team-x"}}
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"roles":{},"bindings":[{"role":"missing","subjects":["*"]}]}`), 0o600))
+	_, err := e.Reload()
+	assert.Error(t, err)
+	assert.True(t, e.Allows(member, auth.ScopeUpload, "team-x/a"))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"roles":{"ro":{"operations":["download"],"paths":["team-x/**"]}},"bindings":[{"role":"ro","subjects":["group:team-x"]}]}`), 0o600))
+	changed, err := e.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.False(t, e.Allows(member, auth.ScopeUpload, "team-x/a"))
+	assert.True(t, e.Allows(member, auth.ScopeDownload, "team-x/a"))
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	for name, p := range map[string]policy.Policy{
+		"no bindings":     {Roles: map[string]policy.Role{"r": {Operations: []string{"list"}, Paths: []string{"**"}}}},
+		"unknown role":    {Bindings: []policy.Binding{{Role: "r", Subjects: []string{"*"}}}},
+		"bad operation":   {Roles: map[string]policy.Role{"r": {Operations: []string{"admin"}, Paths: []string{"**"}}}, Bindings: []policy.Binding{{Role: "r", Subjects: []string{"*"}}}},
+		"bad glob":        {Roles: map[string]policy.Role{"r": {Operations: []string{"list"}, Paths: []string{"a/["}}}, Bindings: []policy.Binding{{Role: "r", Subjects: []string{"*"}}}},
+		"bad subject":     {Roles: map[string]policy.Role{"r": {Operations: []string{"list"}, Paths: []string{"**"}}}, Bindings: []policy.Binding{{Role: "r", Subjects: []string{"alice"}}}},
+		"role with no op": {Roles: map[string]policy.Role{"r": {Paths: []string{"**"}}}, Bindings: []policy.Binding{{Role: "r", Subjects: []string{"*"}}}},
+	} {
+		assert.Error(t, p.Validate(), name)
+	}
+}
@@ -0,0 +1,126 @@
+package presign_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/presign"
+)
+
+func newSigner(t *testing.T) *presign.Signer {
+	t.Helper()
+	s, err := presign.NewSigner([]byte(strings.Repeat("k", 32)))
+	require.NoError(t, err)
+	return s
+}
+
+func TestNewSigner_ShortSecret(t *testing.T) {
+	_, err := presign.NewSigner([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestSignVerify_RoundTrip(t *testing.T) {
+	s := newSigner(t)
+	want := presign.Params{
+		Operation:   presign.OperationUpload,
+		Filename:    "report.csv",
+		Expires:     time.Now().Add(time.Minute).Truncate(time.Second),
+		MaxSize:     1024,
+		ContentType: "text/csv",
+	}
+
+	got, err := s.Verify(s.Sign(want))
+	require.NoError(t, err)
+	assert.Equal(t, want.Operation, got.Operation)
+	assert.Equal(t, want.Filename, got.Filename)
+	assert.Equal(t, want.MaxSize, got.MaxSize)
+	assert.Equal(t, want.ContentType, got.ContentType)
+	assert.True(t, want.Expires.Equal(got.Expires))
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	s := newSigner(t)
+	q := s.Sign(presign.Params{Operation: presign.OperationUpload, Filename: "a.txt", Expires: time.Now().Add(time.Minute), MaxSize: 10})
+
+	for _, mutate := range []func(url.Values){
+		func(q url.Values) { q.Set(presign.ParamFilename, "b.txt") },
+		func(q url.Values) { q.Set(presign.ParamMaxSize, "1000000") },
+		func(q url.Values) { q.Set(presign.ParamOperation, presign.OperationDownload) },
+		func(q url.Values) { q.Del(presign.ParamMaxSize) },
+		func(q url.Values) { q.Set(presign.ParamSignature, "AAAA") },
+	} {
+		tampered := url.Values{}
+		for k, v := range q {
+			tampered[k] = append([]string(nil), v...)
+		}
+		mutate(tampered)
+		_, err := s.Verify(tampered)
+		assert.ErrorIs(t, err, presign.ErrInvalidSignature)
+	}
+
+	other, err := presign.NewSigner([]byte(strings.Repeat("x", 32)))
+	require.NoError(t, err)
+	_, err = other.Verify(q)
+	assert.ErrorIs(t, err, presign.ErrInvalidSignature)
+}
+
+func TestSignVerify_Tenant(t *testing.T) {
+	s := newSigner(t)
+	q := s.Sign(presign.Params{Operation: presign.OperationDownload, Filename: "a.txt", Expires: time.Now().Add(time.Minute), Tenant: "finance"})
+	got, err := s.Verify(q)
+	require.NoError(t, err)
+	assert.Equal(t, "finance", got.Tenant)
+
+	for _, mutate := range []func(url.Values){
+		func(q url.Values) { q.Set(presign.ParamTenant, "hr") },
+		func(q url.Values) { q.Del(presign.ParamTenant) },
+	} {
+		tampered := url.Values{}
+		for k, v := range q {
+			tampered[k] = append([]string(nil), v...)
+		}
+		mutate(tampered)
+		_, err := s.Verify(tampered)
+		assert.ErrorIs(t, err, presign.ErrInvalidSignature)
+	}
+}
+
+func TestSignVerify_ID(t *testing.T) {
+	s := newSigner(t)
+	q := s.Sign(presign.Params{Operation: presign.OperationDirectUpload, Filename: "a.txt", Expires: time.Now().Add(time.Minute), ID: "0123"})
+	got, err := s.Verify(q)
+	require.NoError(t, err)
+	assert.Equal(t, "0123", got.ID)
+
+	for _, mutate := range []func(url.Values){
+		func(q url.Values) { q.Set(presign.ParamID, "4567") },
+		func(q url.Values) { q.Del(presign.ParamID) },
+		// The ID must not be accepted as a tenant.
+		func(q url.Values) { q.Del(presign.ParamID); q.Set(presign.ParamTenant, "0123") },
+	} {
+		tampered := url.Values{}
+		for k, v := range q {
+			tampered[k] = append([]string(nil), v...)
+		}
+		mutate(tampered)
+		_, err := s.Verify(tampered)
+		assert.ErrorIs(t, err, presign.ErrInvalidSignature)
+	}
+}
+
+func TestVerify_ExpiredAndMissing(t *testing.T) {
+	s := newSigner(t)
+	q := s.Sign(presign.Params{Operation: presign.OperationDownload, Filename: "a.txt", Expires: time.Now().Add(-time.Second)})
+	_, err := s.Verify(q)
+	assert.ErrorIs(t, err, presign.ErrExpired)
+
+	_, err = s.Verify(url.Values{})
+	assert.ErrorIs(t, err, presign.ErrMissingSignature)
+	assert.False(t, presign.IsPresigned(url.Values{}))
+	assert.True(t, presign.IsPresigned(q))
+}
@@ -0,0 +1,72 @@
+// Package proxy accepts PROXY protocol headers from trusted load balancers
+// only, so that a client connecting directly can't claim another source
+// address.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// Options controls which connections may carry a PROXY header.
+type Options struct {
+	// Trusted are the load balancers allowed to send a header. A header
+	// from any other source closes the connection.
+	Trusted []netip.Prefix
+	// Required makes trusted sources send a header on every connection.
+	// Other sources, such as kubelet probes, still connect directly.
+	Required bool
+	// HeaderTimeout bounds waiting for the header. Defaults to 10s.
+	HeaderTimeout time.Duration
+}
+
+// Listen wraps ln so that connections from trusted sources report the
+// client address given in their PROXY header.
+func Listen(ln net.Listener, opts Options) net.Listener {
+	return &proxyproto.Listener{
+		Listener:          ln,
+		ConnPolicy:        policy(opts),
+		ReadHeaderTimeout: opts.HeaderTimeout,
+	}
+}
+
+func policy(opts Options) proxyproto.ConnPolicyFunc {
+	trusted := proxyproto.USE
+	if opts.Required {
+		trusted = proxyproto.REQUIRE
+	}
+	return func(conn proxyproto.ConnPolicyOptions) (proxyproto.Policy, error) {
+		addr, err := netip.ParseAddrPort(conn.Upstream.String())
+		if err != nil {
+			return proxyproto.REJECT, nil
+		}
+		for _, p := range opts.Trusted {
+			if p.Contains(addr.Addr().Unmap()) {
+				return trusted, nil
+			}
+		}
+		return proxyproto.REJECT, nil
+	}
+}
+
+// ParsePrefixes parses CIDRs such as 10.0.0.0/8; a bare address is taken
+// as a single host.
+func ParsePrefixes(list []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			out = append(out, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q", s)
+		}
+		out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return out, nil
+}
@@ -0,0 +1,88 @@
+package proxy_test
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/proxy"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const header = "PROXY TCP4 203.0.113.7 127.0.0.1 40000 8080\r\n"
+
+// accept sends payload over a new connection to a listener wrapped with
+// opts and returns what the server saw.
+func accept(t *testing.T, opts proxy.Options, payload string) (remote string, got string, err error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	pl := proxy.Listen(ln, opts)
+	defer pl.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte(payload))
+	require.NoError(t, err)
+	client.(*net.TCPConn).CloseWrite()
+
+	conn, err := pl.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	data, err := io.ReadAll(conn)
+	return conn.RemoteAddr().String(), string(data), err
+}
+
+func prefixes(t *testing.T, list ...string) []netip.Prefix {
+	t.Helper()
+	p, err := proxy.ParsePrefixes(list)
+	require.NoError(t, err)
+	return p
+}
+
+func TestListen_TrustedSourceSetsClientAddress(t *testing.T) {
+	remote, got, err := accept(t, proxy.Options{Trusted: prefixes(t, "127.0.0.0/8")}, header+"ping")
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7:40000", remote)
+	assert.Equal(t, "ping", got)
+
+	remote, got, err = accept(t, proxy.Options{Trusted: prefixes(t, "127.0.0.1")}, "ping")
+	require.NoError(t, err, "the header is optional unless required")
+	assert.Contains(t, remote, "127.0.0.1:")
+	assert.Equal(t, "ping", got)
+}
+
+func TestListen_UntrustedSourceCannotSendHeader(t *testing.T) {
+	opts := proxy.Options{Trusted: prefixes(t, "10.0.0.0/8"), Required: true}
+	_, _, err := accept(t, opts, header+"ping")
+	assert.Error(t, err)
+
+	remote, got, err := accept(t, opts, "ping")
+	require.NoError(t, err, "direct clients such as probes still connect")
+	assert.Contains(t, remote, "127.0.0.1:")
+	assert.Equal(t, "ping", got)
+}
+
+func TestListen_RequiredHeaderMissing(t *testing.T) {
+	_, _, err := accept(t, proxy.Options{Trusted: prefixes(t, "127.0.0.1/32"), Required: true}, "ping")
+	assert.Error(t, err)
+}
+
+func TestParsePrefixes(t *testing.T) {
+	p, err := proxy.ParsePrefixes([]string{"10.1.2.3/8", "192.0.2.1", "2001:db8::/32"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, p)
+
+	_, err = proxy.ParsePrefixes([]string{"10.0.0.0/33"})
+	assert.ErrorContains(t, err, "10.0.0.0/33")
+}
@@ -0,0 +1,384 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"stream-upload-file/pkg/storage"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"go.uber.org/zap"
+)
+
+// MetadataUploadedBy is the blob metadata key recording who uploaded a blob.
+const MetadataUploadedBy = "uploadedBy"
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Limit caps usage. Zero fields are unlimited.
+type Limit struct {
+	MaxBytes   int64 `json:"maxBytes,omitempty"`
+	MaxObjects int64 `json:"maxObjects,omitempty"`
+}
+
+// TenantLimits are the limits that apply within one tenant.
+type TenantLimits struct {
+	// Total caps the tenant as a whole.
+	Total Limit `json:"total"`
+	// PerUser caps each uploader unless Users has an entry for them.
+	PerUser Limit            `json:"perUser"`
+	Users   map[string]Limit `json:"users,omitempty"`
+}
+
+// Config is the on-disk form of the quota configuration. Tenants without
+// an entry use Default. In single-tenant mode only Default applies.
+type Config struct {
+	Default TenantLimits            `json:"default"`
+	Tenants map[string]TenantLimits `json:"tenants,omitempty"`
+}
+
+// LoadConfig reads a quota configuration file.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("quota config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) limits(tenant string) TenantLimits {
+	if l, ok := cfg.Tenants[tenant]; ok {
+		return l
+	}
+	return cfg.Default
+}
+
+func (l TenantLimits) user(user string) Limit {
+	if u, ok := l.Users[user]; ok {
+		return u
+	}
+	return l.PerUser
+}
+
+// Usage is the storage consumed by a tenant or user.
+type Usage struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+func (u Usage) exceeds(l Limit) bool {
+	return (l.MaxBytes > 0 && u.Bytes > l.MaxBytes) || (l.MaxObjects > 0 && u.Objects > l.MaxObjects)
+}
+
+// Object is a stored blob as far as quotas are concerned.
+type Object struct {
+	User string
+	Size int64
+}
+
+// ObjectFromItem reads the size and uploader of a listed blob. The size is
+// the uploaded size, which differs from the stored one for compressed or
+// encrypted blobs.
+func ObjectFromItem(item *container.BlobItem) Object {
+	var o Object
+	if item.Properties != nil && item.Properties.ContentLength != nil {
+		o.Size = *item.Properties.ContentLength
+	}
+	if size, err := strconv.ParseInt(storage.MetadataValue(item.Metadata, storage.MetadataOriginalSize), 10, 64); err == nil {
+		o.Size = size
+	}
+	o.User = storage.MetadataValue(item.Metadata, MetadataUploadedBy)
+	return o
+}
+
+// ObjectFromProperties is ObjectFromItem for a single blob's properties.
+func ObjectFromProperties(props *blob.GetPropertiesResponse) Object {
+	var o Object
+	if props.ContentLength != nil {
+		o.Size = *props.ContentLength
+	}
+	if size, err := strconv.ParseInt(storage.MetadataValue(props.Metadata, storage.MetadataOriginalSize), 10, 64); err == nil {
+		o.Size = size
+	}
+	o.User = storage.MetadataValue(props.Metadata, MetadataUploadedBy)
+	return o
+}
+
+type userKey struct {
+	tenant, user string
+}
+
+// Tracker keeps per-tenant and per-user usage in memory. It is updated as
+// uploads and deletes happen and periodically replaced by a scan of the
+// storage listing, which also picks up changes made by other replicas.
+type Tracker struct {
+	cfg    *Config
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	tenants map[string]*Usage
+	users   map[userKey]*Usage
+	// pending are the reservations of uploads still in progress, which a
+	// reconcile must not forget.
+	pending map[*Reservation]struct{}
+}
+
+// NewTracker returns a tracker enforcing cfg, starting with no usage.
+func NewTracker(cfg *Config) *Tracker {
+	return &Tracker{
+		cfg:     cfg,
+		logger:  zap.L().Named("quota"),
+		tenants: map[string]*Usage{},
+		users:   map[userKey]*Usage{},
+		pending: map[*Reservation]struct{}{},
+	}
+}
+
+func (t *Tracker) tenantUsage(tenant string) *Usage {
+	u, ok := t.tenants[tenant]
+	if !ok {
+		u = &Usage{}
+		t.tenants[tenant] = u
+	}
+	return u
+}
+
+func (t *Tracker) userUsage(tenant, user string) *Usage {
+	k := userKey{tenant, user}
+	u, ok := t.users[k]
+	if !ok {
+		u = &Usage{}
+		t.users[k] = u
+	}
+	return u
+}
+
+// Reservation is the usage taken by an upload in progress. Commit it once
+// the upload is stored, or Release it if the upload fails; only the first
+// call counts. A nil Reservation does nothing.
+type Reservation struct {
+	t            *Tracker
+	tenant, user string
+	size         int64
+	prev         *Object
+	once         sync.Once
+}
+
+// Commit keeps the reserved usage.
+func (r *Reservation) Commit() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		r.t.mu.Lock()
+		defer r.t.mu.Unlock()
+		delete(r.t.pending, r)
+	})
+}
+
+// Release gives the reserved usage back.
+func (r *Reservation) Release() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		r.t.mu.Lock()
+		defer r.t.mu.Unlock()
+		delete(r.t.pending, r)
+		r.apply(-1)
+	})
+}
+
+// apply adds the reservation to the tracked usage, or takes it away when
+// sign is -1.
+func (r *Reservation) apply(sign int64) {
+	r.t.apply(r.tenant, r.user, sign*r.size, sign)
+	if r.prev != nil {
+		r.t.apply(r.tenant, r.prev.User, -sign*r.prev.Size, -sign)
+	}
+}
+
+// Reserve accounts for an upload of size bytes by user, replacing prev if
+// the name is already taken. It fails with ErrQuotaExceeded if the upload
+// would take the tenant or the user over their limit. An empty user is
+// anonymous and only held to the tenant limit.
+func (t *Tracker) Reserve(tenant, user string, size int64, prev *Object) (*Reservation, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limits := t.cfg.limits(tenant)
+	tu, uu := t.tenantUsage(tenant), t.userUsage(tenant, user)
+	tenantAfter := Usage{Bytes: tu.Bytes + size, Objects: tu.Objects + 1}
+	userAfter := Usage{Bytes: uu.Bytes + size, Objects: uu.Objects + 1}
+	if prev != nil {
+		tenantAfter.Bytes -= prev.Size
+		tenantAfter.Objects--
+		if prev.User == user {
+			userAfter.Bytes -= prev.Size
+			userAfter.Objects--
+		}
+	}
+	// Only growth is refused, so callers already over a lowered limit can
+	// still replace files with smaller ones.
+	if tenantAfter.Bytes > tu.Bytes || tenantAfter.Objects > tu.Objects {
+		if tenantAfter.exceeds(limits.Total) {
+			return nil, fmt.Errorf("%w: tenant", ErrQuotaExceeded)
+		}
+	}
+	if user != "" && (userAfter.Bytes > uu.Bytes || userAfter.Objects > uu.Objects) {
+		if userAfter.exceeds(limits.user(user)) {
+			return nil, fmt.Errorf("%w: user %s", ErrQuotaExceeded, user)
+		}
+	}
+
+	r := &Reservation{t: t, tenant: tenant, user: user, size: size, prev: prev}
+	r.apply(1)
+	t.pending[r] = struct{}{}
+	return r, nil
+}
+
+// Check reports whether an upload of size bytes would currently fit,
+// without reserving anything.
+func (t *Tracker) Check(tenant, user string, size int64) error {
+	r, err := t.Reserve(tenant, user, size, nil)
+	if err != nil {
+		return err
+	}
+	r.Release()
+	return nil
+}
+
+// Remove accounts for a deleted object.
+func (t *Tracker) Remove(tenant string, o Object) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.apply(tenant, o.User, -o.Size, -1)
+}
+
+func (t *Tracker) apply(tenant, user string, bytes, objects int64) {
+	tu, uu := t.tenantUsage(tenant), t.userUsage(tenant, user)
+	tu.Bytes += bytes
+	tu.Objects += objects
+	uu.Bytes += bytes
+	uu.Objects += objects
+}
+
+// Report is the usage and limits of a tenant and, optionally, one user.
+type Report struct {
+	Tenant       string     `json:"tenant,omitempty"`
+	Usage        Usage      `json:"usage"`
+	Limit        Limit      `json:"limit"`
+	User         string     `json:"user,omitempty"`
+	UserUsage    *Usage     `json:"userUsage,omitempty"`
+	UserLimit    *Limit     `json:"userLimit,omitempty"`
+	ReconciledAt *time.Time `json:"reconciledAt,omitempty"`
+}
+
+// Report returns the usage of tenant and, if user is not empty, of user.
+func (t *Tracker) Report(tenant, user string) Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	limits := t.cfg.limits(tenant)
+	r := Report{Tenant: tenant, Usage: *t.tenantUsage(tenant), Limit: limits.Total}
+	if user != "" {
+		uu := *t.userUsage(tenant, user)
+		ul := limits.user(user)
+		r.User, r.UserUsage, r.UserLimit = user, &uu, &ul
+	}
+	return r
+}
+
+// Lister lists blobs; every storage.BlobStore is one.
+type Lister interface {
+	ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error)
+}
+
+// Reconcile replaces the usage of tenant with the totals of a full listing
+// of its storage, plus the reservations of uploads still in progress so
+// that releasing them later doesn't take usage below what is stored.
+// Uploads that finish while the listing runs may be missed or counted
+// twice until the next reconcile.
+func (t *Tracker) Reconcile(ctx context.Context, tenant string, store Lister) error {
+	items, err := store.ListBlobs(ctx, "")
+	if err != nil {
+		return err
+	}
+	total := &Usage{}
+	users := map[string]*Usage{}
+	for _, item := range items {
+		// Direct uploads are counted when they are completed.
+		if item.Name != nil && storage.IsDirectUploadName(*item.Name) {
+			continue
+		}
+		o := ObjectFromItem(item)
+		total.Bytes += o.Size
+		total.Objects++
+		u, ok := users[o.User]
+		if !ok {
+			u = &Usage{}
+			users[o.User] = u
+		}
+		u.Bytes += o.Size
+		u.Objects++
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, tracked := t.tenants[tenant]
+	t.tenants[tenant] = total
+	for k := range t.users {
+		if k.tenant == tenant {
+			delete(t.users, k)
+		}
+	}
+	for user, u := range users {
+		t.users[userKey{tenant, user}] = u
+	}
+	for r := range t.pending {
+		if r.tenant == tenant {
+			r.apply(1)
+		}
+	}
+	if tracked && *prev != *total {
+		t.logger.Info("Corrected tenant usage",
+			zap.String("tenant", tenant),
+			zap.Int64("trackedBytes", prev.Bytes),
+			zap.Int64("actualBytes", total.Bytes),
+		)
+	}
+	return nil
+}
+
+// RunReconciler reconciles every store returned by stores immediately and
+// then every interval until ctx is done.
+func (t *Tracker) RunReconciler(ctx context.Context, interval time.Duration, stores func() (map[string]Lister, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		all, err := stores()
+		if err != nil {
+			t.logger.Error("Failed to open storage for quota reconcile", zap.Error(err))
+		}
+		for tenant, store := range all {
+			if err := t.Reconcile(ctx, tenant, store); err != nil {
+				t.logger.Error("Failed to reconcile usage", zap.String("tenant", tenant), zap.Error(err))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
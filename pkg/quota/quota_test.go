@@ -0,0 +1,154 @@
+package quota_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"stream-upload-file/pkg/quota"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserve_TenantAndUserLimits(t *testing.T) {
+	tr := quota.NewTracker(&quota.Config{
+		Default: quota.TenantLimits{Total: quota.Limit{MaxBytes: 100}, PerUser: quota.Limit{MaxObjects: 2}},
+		Tenants: map[string]quota.TenantLimits{
+			"big": {Users: map[string]quota.Limit{"bob": {MaxBytes: 10}}},
+		},
+	})
+
+	_, err := tr.Reserve("", "alice", 60, nil)
+	require.NoError(t, err)
+	_, err = tr.Reserve("", "carol", 50, nil)
+	assert.ErrorIs(t, err, quota.ErrQuotaExceeded, "tenant total")
+	_, err = tr.Reserve("", "alice", 10, nil)
+	require.NoError(t, err)
+	_, err = tr.Reserve("", "alice", 1, nil)
+	assert.ErrorIs(t, err, quota.ErrQuotaExceeded, "per-user object count")
+
+	// Replacing one of alice's objects doesn't add one.
+	_, err = tr.Reserve("", "alice", 5, &quota.Object{User: "alice", Size: 10})
+	require.NoError(t, err)
+	r := tr.Report("", "alice")
+	assert.Equal(t, quota.Usage{Bytes: 65, Objects: 2}, r.Usage)
+	assert.Equal(t, quota.Usage{Bytes: 65, Objects: 2}, *r.UserUsage)
+
+	_, err = tr.Reserve("big", "bob", 11, nil)
+	assert.ErrorIs(t, err, quota.ErrQuotaExceeded, "user override")
+	_, err = tr.Reserve("big", "alice", 1000, nil)
+	assert.NoError(t, err, "tenant entry without limits is unlimited")
+}
+
+func TestReserve_AnonymousOnlyTenantLimit(t *testing.T) {
+	tr := quota.NewTracker(&quota.Config{Default: quota.TenantLimits{
+		Total:   quota.Limit{MaxObjects: 3},
+		PerUser: quota.Limit{MaxObjects: 1},
+	}})
+
+	for range 3 {
+		_, err := tr.Reserve("", "", 1, nil)
+		require.NoError(t, err)
+	}
+	_, err := tr.Reserve("", "", 1, nil)
+	assert.ErrorIs(t, err, quota.ErrQuotaExceeded)
+}
+
+func TestReserve_ReleaseAndRemove(t *testing.T) {
+	tr := quota.NewTracker(&quota.Config{Default: quota.TenantLimits{Total: quota.Limit{MaxBytes: 10}}})
+
+	r, err := tr.Reserve("t", "alice", 8, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, tr.Check("t", "alice", 8), quota.ErrQuotaExceeded)
+	r.Release()
+	r.Release()
+	assert.Equal(t, quota.Usage{}, tr.Report("t", "").Usage)
+
+	r, err = tr.Reserve("t", "alice", 8, nil)
+	require.NoError(t, err)
+	r.Commit()
+	r.Release()
+	assert.Equal(t, quota.Usage{Bytes: 8, Objects: 1}, tr.Report("t", "").Usage, "a committed reservation stays")
+	tr.Remove("t", quota.Object{User: "alice", Size: 8})
+	assert.NoError(t, tr.Check("t", "alice", 10))
+}
+
+func TestReserve_ShrinkingOverLimit(t *testing.T) {
+	tr := quota.NewTracker(&quota.Config{Default: quota.TenantLimits{Total: quota.Limit{MaxBytes: 100}}})
+	_, err := tr.Reserve("", "alice", 100, nil)
+	require.NoError(t, err)
+
+	tr = quota.NewTracker(&quota.Config{Default: quota.TenantLimits{Total: quota.Limit{MaxBytes: 50}}})
+	_, err = tr.Reserve("", "alice", 100, nil)
+	require.Error(t, err)
+	require.NoError(t, tr.Reconcile(context.Background(), "", lister{item("a", 100, "alice")}))
+	_, err = tr.Reserve("", "alice", 80, &quota.Object{User: "alice", Size: 100})
+	assert.NoError(t, err, "a smaller replacement is allowed while over the limit")
+}
+
+type lister []*container.BlobItem
+
+func (l lister) ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error) {
+	return l, nil
+}
+
+func item(name string, size int64, user string) *container.BlobItem {
+	return &container.BlobItem{
+		Name:       &name,
+		Properties: &container.BlobProperties{ContentLength: &size},
+		Metadata:   map[string]*string{"Uploadedby": &user},
+	}
+}
+
+func TestReconcile_ReplacesUsage(t *testing.T) {
+	tr := quota.NewTracker(&quota.Config{})
+	ghost, err := tr.Reserve("t", "ghost", 500, nil)
+	require.NoError(t, err)
+	ghost.Commit()
+
+	compressed := item("c.log", 10, "alice")
+	original := "40"
+	compressed.Metadata["Originalsize"] = &original
+	require.NoError(t, tr.Reconcile(context.Background(), "t", lister{item("a", 5, "alice"), compressed, item("b", 7, "bob")}))
+
+	r := tr.Report("t", "alice")
+	assert.Equal(t, quota.Usage{Bytes: 52, Objects: 3}, r.Usage)
+	assert.Equal(t, quota.Usage{Bytes: 45, Objects: 2}, *r.UserUsage)
+	assert.Equal(t, quota.Usage{}, *tr.Report("t", "ghost").UserUsage)
+}
+
+func TestReconcile_KeepsReservationsInFlight(t *testing.T) {
+	tr := quota.NewTracker(&quota.Config{})
+	r, err := tr.Reserve("t", "alice", 30, &quota.Object{User: "bob", Size: 7})
+	require.NoError(t, err)
+
+	// The listing doesn't include the upload still in progress.
+	require.NoError(t, tr.Reconcile(context.Background(), "t", lister{item("a", 5, "alice"), item("b", 7, "bob")}))
+	assert.Equal(t, quota.Usage{Bytes: 35, Objects: 2}, tr.Report("t", "").Usage)
+
+	r.Release()
+	assert.Equal(t, quota.Usage{Bytes: 12, Objects: 2}, tr.Report("t", "").Usage)
+	assert.Equal(t, quota.Usage{Bytes: 5, Objects: 1}, *tr.Report("t", "alice").UserUsage)
+	assert.Equal(t, quota.Usage{Bytes: 7, Objects: 1}, *tr.Report("t", "bob").UserUsage)
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"default": {"total": {"maxBytes": 1000}, "perUser": {"maxObjects": 5}},
+		"tenants": {"acme": {"total": {"maxBytes": 50}, "users": {"ci": {"maxBytes": 10}}}}
+	}`), 0o600))
+
+	cfg, err := quota.LoadConfig(path)
+	require.NoError(t, err)
+	tr := quota.NewTracker(cfg)
+	assert.Equal(t, quota.Limit{MaxBytes: 1000}, tr.Report("other", "").Limit)
+	assert.Equal(t, quota.Limit{MaxBytes: 10}, *tr.Report("acme", "ci").UserLimit)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"default":`), 0o600))
+	_, err = quota.LoadConfig(path)
+	assert.Error(t, err)
+}
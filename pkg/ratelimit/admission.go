@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"stream-upload-file/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Admission caps the uploads in progress at once, by count and by
+// declared size, so that a burst of uploads can't exhaust the pod's memory
+// and temporary disk. Requests beyond the cap are turned away rather than
+// queued.
+type Admission struct {
+	retryAfter time.Duration
+	logger     *zap.Logger
+
+	mu          sync.Mutex
+	maxUploads  int64
+	maxBytes    int64
+	unknownSize int64
+	uploads     int64
+	bytes       int64
+}
+
+// NewAdmission admits at most maxUploads uploads totalling maxBytes at a
+// time; zero means no cap. Requests without a Content-Length are counted
+// as unknownSize bytes.
+func NewAdmission(maxUploads int, maxBytes, unknownSize int64) *Admission {
+	a := &Admission{
+		retryAfter: time.Second,
+		logger:     zap.L().Named("admission"),
+	}
+	a.SetLimits(maxUploads, maxBytes, unknownSize)
+	return a
+}
+
+// SetLimits changes the caps. Uploads already admitted are not affected,
+// so lowering a cap only turns new uploads away until enough have finished.
+func (a *Admission) SetLimits(maxUploads int, maxBytes, unknownSize int64) {
+	maxUploadsLimit.Set(float64(maxUploads))
+	maxBytesLimit.Set(float64(maxBytes))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxUploads = int64(maxUploads)
+	a.maxBytes = maxBytes
+	a.unknownSize = unknownSize
+}
+
+// Acquire admits an upload of size bytes. If it doesn't fit it returns the
+// reason; otherwise the returned func must be called once it's done.
+func (a *Admission) Acquire(size int64) (func(), string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if size < 0 {
+		size = a.unknownSize
+	}
+	if a.maxBytes > 0 {
+		// A single upload larger than the cap is admitted on its own.
+		size = min(size, a.maxBytes)
+	}
+	if a.maxUploads > 0 && a.uploads >= a.maxUploads {
+		return nil, ReasonMaxUploads
+	}
+	if a.maxBytes > 0 && a.bytes+size > a.maxBytes {
+		return nil, ReasonMaxBytes
+	}
+	a.uploads++
+	a.bytes += size
+	a.report()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			a.uploads--
+			a.bytes -= size
+			a.report()
+		})
+	}, ""
+}
+
+func (a *Admission) report() {
+	inFlightUploads.Set(float64(a.uploads))
+	inFlightBytes.Set(float64(a.bytes))
+}
+
+// Middleware rejects uploads that don't fit with 503 and a Retry-After
+// header.
+func (a *Admission) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		release, reason := a.Acquire(c.Request.ContentLength)
+		if release == nil {
+			rejectedTotal.WithLabelValues(reason).Inc()
+			requestid.Logger(c, a.logger).Warn("Upload rejected by admission control",
+				zap.String("reason", reason),
+				zap.Int64("size", c.Request.ContentLength),
+				zap.String("client-ip", c.ClientIP()),
+			)
+			c.Header("Retry-After", retryAfter(a.retryAfter))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, requestid.Error(c, "Server busy, retry later"))
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -0,0 +1,80 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"stream-upload-file/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmission_Caps(t *testing.T) {
+	a := ratelimit.NewAdmission(2, 100, 60)
+
+	first, reason := a.Acquire(50)
+	require.NotNil(t, first, reason)
+	_, reason = a.Acquire(-1)
+	assert.Equal(t, ratelimit.ReasonMaxBytes, reason, "unknown sizes count as 60")
+	second, _ := a.Acquire(50)
+	require.NotNil(t, second)
+	_, reason = a.Acquire(0)
+	assert.Equal(t, ratelimit.ReasonMaxUploads, reason)
+
+	first()
+	first()
+	second()
+	huge, reason := a.Acquire(1000)
+	require.NotNil(t, huge, "an oversized upload is admitted alone")
+	_, reason = a.Acquire(1)
+	assert.Equal(t, ratelimit.ReasonMaxBytes, reason)
+	huge()
+}
+
+func TestAdmission_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := ratelimit.NewAdmission(1, 0, 0)
+	hold, _ := a.Acquire(0)
+
+	router := gin.New()
+	router.POST("/upload", a.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	post := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader("data")))
+		return w
+	}
+
+	w := post()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	hold()
+	assert.Equal(t, http.StatusOK, post().Code)
+	assert.Equal(t, http.StatusOK, post().Code, "released after the request")
+}
+
+func TestAdmission_SetLimits(t *testing.T) {
+	a := ratelimit.NewAdmission(2, 0, 0)
+	first, _ := a.Acquire(10)
+	require.NotNil(t, first)
+	second, _ := a.Acquire(10)
+	require.NotNil(t, second)
+
+	a.SetLimits(1, 0, 0)
+	_, reason := a.Acquire(10)
+	assert.Equal(t, ratelimit.ReasonMaxUploads, reason)
+	first()
+	_, reason = a.Acquire(10)
+	assert.Equal(t, ratelimit.ReasonMaxUploads, reason, "admitted uploads still count after lowering the cap")
+	second()
+
+	a.SetLimits(3, 0, 0)
+	for range 3 {
+		release, reason := a.Acquire(10)
+		require.NotNil(t, release, reason)
+	}
+}
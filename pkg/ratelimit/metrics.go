@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons a request is turned away, used as the "reason" label.
+const (
+	ReasonRateLimit  = "rate_limit"
+	ReasonMaxUploads = "max_uploads"
+	ReasonMaxBytes   = "max_bytes"
+)
+
+var (
+	rejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "admission_rejected_requests_total",
+		Help: "Requests rejected by rate limiting or upload admission control.",
+	}, []string{"reason"})
+
+	rateLimitRPS = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rate_limit_requests_per_second",
+		Help: "Configured sustained request rate per client.",
+	})
+	rateLimitBurst = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rate_limit_burst",
+		Help: "Configured request burst per client.",
+	})
+	rateLimitClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rate_limit_tracked_clients",
+		Help: "Clients with a token bucket in memory.",
+	})
+
+	inFlightUploads = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "admission_in_flight_uploads",
+		Help: "Uploads currently admitted.",
+	})
+	inFlightBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "admission_in_flight_upload_bytes",
+		Help: "Declared bytes of the uploads currently admitted.",
+	})
+	maxUploadsLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "admission_max_in_flight_uploads",
+		Help: "Configured cap on concurrent uploads, 0 if unlimited.",
+	})
+	maxBytesLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "admission_max_in_flight_upload_bytes",
+		Help: "Configured cap on concurrent upload bytes, 0 if unlimited.",
+	})
+)
@@ -0,0 +1,184 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Limiter applies a token bucket per client. Authenticated callers are
+// limited by identity, so that clients behind one NAT don't share a
+// bucket, and anonymous ones by client IP.
+type Limiter struct {
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	rate    rate.Limit
+	burst   int
+	clients map[string]*client
+}
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewLimiter allows each client rps requests per second on average and
+// bursts of up to burst requests.
+func NewLimiter(rps float64, burst int) *Limiter {
+	l := &Limiter{
+		logger:  zap.L().Named("rate-limit"),
+		clients: map[string]*client{},
+	}
+	l.SetLimit(rps, burst)
+	return l
+}
+
+// SetLimit changes the rate and burst, including for clients already
+// tracked. A burst below 1 defaults to the rate rounded up, and a rate of
+// zero lets every request through.
+func (l *Limiter) SetLimit(rps float64, burst int) {
+	if burst < 1 {
+		burst = int(math.Ceil(rps))
+	}
+	rateLimitRPS.Set(rps)
+	rateLimitBurst.Set(float64(burst))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate.Limit(rps)
+	if rps <= 0 {
+		l.rate = rate.Inf
+	}
+	l.burst = burst
+	for _, cl := range l.clients {
+		cl.limiter.SetLimit(l.rate)
+		cl.limiter.SetBurst(burst)
+	}
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it
+// returns false and how long until a token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	if l.rate == rate.Inf {
+		l.mu.Unlock()
+		return true, 0
+	}
+	cl, ok := l.clients[key]
+	if !ok {
+		cl = &client{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.clients[key] = cl
+		rateLimitClients.Set(float64(len(l.clients)))
+	}
+	cl.lastSeen = now
+	l.mu.Unlock()
+
+	r := cl.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// Middleware rejects requests over the limit with 429 and a Retry-After
+// header. It must run after authentication to key callers by identity.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if id, ok := auth.FromContext(c); ok {
+			key = "id:" + id.ID
+		}
+		ok, wait := l.Allow(key)
+		if ok {
+			c.Next()
+			return
+		}
+		l.reject(c, key, wait)
+	}
+}
+
+// FailedAuthMiddleware limits, by client IP, requests that authentication
+// turns away with 401. It runs before authentication, which Middleware
+// can't, so that API keys and tokens can't be guessed faster than the
+// limit: a client that has used up its bucket on failures gets 429 without
+// its credentials being checked. Requests that authenticate cost nothing,
+// so clients sharing an address are still limited by Middleware alone.
+// Failures have buckets of their own, apart from Middleware's.
+func (l *Limiter) FailedAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "auth-failure:" + c.ClientIP()
+		if wait := l.wait(key); wait > 0 {
+			l.reject(c, key, wait)
+			return
+		}
+		c.Next()
+		if c.Writer.Status() == http.StatusUnauthorized {
+			l.Allow(key)
+		}
+	}
+}
+
+// wait returns how long until key's bucket has a token, without taking one.
+func (l *Limiter) wait(key string) time.Duration {
+	l.mu.Lock()
+	cl, ok := l.clients[key]
+	l.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	limit := cl.limiter.Limit()
+	tokens := cl.limiter.Tokens()
+	if limit == rate.Inf || tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - tokens) / float64(limit) * float64(time.Second))
+}
+
+func (l *Limiter) reject(c *gin.Context, key string, wait time.Duration) {
+	rejectedTotal.WithLabelValues(ReasonRateLimit).Inc()
+	requestid.Logger(c, l.logger).Warn("Rate limit exceeded", zap.String("client", key), zap.String("path", c.FullPath()))
+	c.Header("Retry-After", retryAfter(wait))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, requestid.Error(c, "Too many requests"))
+}
+
+// Prune forgets clients whose bucket has refilled, every interval until
+// ctx is done. A full bucket behaves exactly like a new one, so this only
+// bounds memory.
+func (l *Limiter) Prune(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			refill := time.Duration(float64(l.burst) / float64(l.rate) * float64(time.Second))
+			for key, cl := range l.clients {
+				if now.Sub(cl.lastSeen) > refill {
+					delete(l.clients, key)
+				}
+			}
+			rateLimitClients.Set(float64(len(l.clients)))
+			l.mu.Unlock()
+		}
+	}
+}
+
+// retryAfter formats a wait as whole seconds, rounded up.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
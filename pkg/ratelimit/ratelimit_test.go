@@ -0,0 +1,128 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := ratelimit.NewLimiter(1, 2)
+
+	for range 2 {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.Greater(t, wait.Seconds(), 0.0)
+
+	ok, _ = l.Allow("b")
+	assert.True(t, ok, "buckets are per client")
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := ratelimit.NewLimiter(0.1, 1)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			auth.SetIdentity(c, &auth.Identity{ID: user})
+		}
+	}, l.Middleware())
+	router.GET("/files", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/files", nil)
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get("alice").Code)
+	w := get("alice")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+
+	// Same client IP, different identity.
+	assert.Equal(t, http.StatusOK, get("bob").Code)
+	assert.Equal(t, http.StatusOK, get("").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("").Code)
+}
+
+func TestLimiter_SetLimit(t *testing.T) {
+	l := ratelimit.NewLimiter(0.1, 1)
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	l.SetLimit(1000, 1)
+	time.Sleep(5 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok, "the new rate applies to known clients")
+
+	l.SetLimit(0.1, 2)
+	for range 2 {
+		ok, _ = l.Allow("b")
+		assert.True(t, ok)
+	}
+	ok, _ = l.Allow("b")
+	assert.False(t, ok)
+
+	l.SetLimit(0, 0)
+	ok, _ = l.Allow("b")
+	assert.True(t, ok, "a zero rate disables limiting")
+}
+
+func TestLimiter_EnabledByReload(t *testing.T) {
+	l := ratelimit.NewLimiter(0, 0)
+	for range 5 {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+	}
+
+	l.SetLimit(0.1, 1)
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+}
+
+func TestLimiter_FailedAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := ratelimit.NewLimiter(0.1, 2)
+	checked := 0
+	router := gin.New()
+	router.GET("/files", l.FailedAuthMiddleware(), func(c *gin.Context) {
+		checked++
+		if c.GetHeader("X-Key") != "valid" {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+	}, l.Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(key string) int {
+		req := httptest.NewRequest("GET", "/files", nil)
+		req.Header.Set("X-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Successful requests don't use up the failure budget.
+	assert.Equal(t, http.StatusOK, get("valid"))
+	assert.Equal(t, http.StatusOK, get("valid"))
+	assert.Equal(t, http.StatusUnauthorized, get("guess-1"))
+	assert.Equal(t, http.StatusUnauthorized, get("guess-2"))
+	assert.Equal(t, http.StatusTooManyRequests, get("guess-3"))
+	assert.Equal(t, 4, checked, "credentials aren't checked once the budget is used up")
+}
@@ -0,0 +1,94 @@
+// Package requestid gives every request an ID that is returned to the
+// client, attached to the log lines about the request and sent on to the
+// storage service, so that one request can be followed end to end.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Header carries the request ID in both directions.
+const Header = "X-Request-ID"
+
+// maxLength bounds IDs supplied by clients, which end up in logs and in
+// storage request headers.
+const maxLength = 128
+
+type contextKey struct{}
+
+// Middleware accepts the caller's X-Request-ID when it is well formed and
+// generates one otherwise. The ID is echoed in the response and stored in
+// the request context.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !valid(id) {
+			id = newID()
+		}
+		c.Header(Header, id)
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID in ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Get returns the ID of the request.
+func Get(c *gin.Context) string {
+	return FromContext(c.Request.Context())
+}
+
+// Logger returns logger with the request's ID attached to every line.
+func Logger(c *gin.Context, logger *zap.Logger) *zap.Logger {
+	if id := Get(c); id != "" {
+		return logger.With(zap.String("request_id", id))
+	}
+	return logger
+}
+
+// Error returns the JSON body of an error response. It carries the request
+// ID so that clients can quote it when reporting a problem.
+func Error(c *gin.Context, message string) gin.H {
+	body := gin.H{"error": message}
+	if id := Get(c); id != "" {
+		body["requestId"] = id
+	}
+	return body
+}
+
+// valid accepts IDs made of characters that are safe in headers and logs,
+// which covers UUIDs, hex and base64 IDs from upstream proxies.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':', r == '+', r == '/', r == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
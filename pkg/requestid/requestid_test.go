@@ -0,0 +1,96 @@
+package requestid_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"stream-upload-file/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(requestid.Middleware())
+	router.GET("/", handler)
+	return router
+}
+
+func TestMiddleware_KeepsValidID(t *testing.T) {
+	var seen string
+	router := newRouter(func(c *gin.Context) { seen = requestid.Get(c) })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(requestid.Header, "0b6e2f1c-9a4d-4c39-8f1e-5c0e7d2a9b10")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, "0b6e2f1c-9a4d-4c39-8f1e-5c0e7d2a9b10", seen)
+	assert.Equal(t, seen, w.Header().Get(requestid.Header))
+}
+
+func TestMiddleware_GeneratesID(t *testing.T) {
+	for name, incoming := range map[string]string{
+		"missing":  "",
+		"invalid":  "bad id\r\nX-Injected: 1",
+		"too long": strings.Repeat("a", 129),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var seen string
+			router := newRouter(func(c *gin.Context) { seen = requestid.Get(c) })
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if incoming != "" {
+				req.Header[requestid.Header] = []string{incoming}
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Regexp(t, "^[0-9a-f]{32}$", seen)
+			assert.Equal(t, seen, w.Header().Get(requestid.Header))
+		})
+	}
+}
+
+func TestError_IncludesRequestID(t *testing.T) {
+	router := newRouter(func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, requestid.Error(c, "boom"))
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(requestid.Header, "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, map[string]string{"error": "boom", "requestId": "req-1"}, body)
+}
+
+func TestLogger_AddsRequestID(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	router := newRouter(func(c *gin.Context) {
+		requestid.Logger(c, zap.New(core)).Info("handled")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(requestid.Header, "req-2")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, "req-2", logs.All()[0].ContextMap()["request_id"])
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Empty(t, requestid.FromContext(context.Background()))
+	assert.Equal(t, "abc", requestid.FromContext(requestid.NewContext(context.Background(), "abc")))
+}
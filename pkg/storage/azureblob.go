@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -12,14 +13,28 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/tracing/azotel"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
+// ErrInvalidRange is returned by ranged downloads that start past the end of the blob.
+var ErrInvalidRange = errors.New("requested range not satisfiable")
+
+// ErrBlobNotFound is returned by GetBlobProperties for a blob that doesn't exist.
+var ErrBlobNotFound = errors.New("blob not found")
+
 type AzureBlobClient struct {
 	client     *azblob.Client
 	accountURL string
 	container  string
 	logger     *zap.Logger
+
+	delegationKeys delegationKeyCache
 }
 
 // For testing
@@ -35,6 +50,18 @@ type BlobClient interface {
 	DownloadStream(ctx context.Context, containerName string, blobName string, options *azblob.DownloadStreamOptions) (azblob.DownloadStreamResponse, error)
 }
 
+// BlobStore is the set of blob operations the file handlers rely on.
+// AzureBlobClient implements it, and decorators such as CompressingClient and
+// EncryptingClient wrap it.
+type BlobStore interface {
+	UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error
+	DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error)
+	DownloadBlobRange(ctx context.Context, blobName string, offset, count int64) (*azblob.DownloadStreamResponse, error)
+	ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error)
+	DeleteBlob(ctx context.Context, blobName string) error
+	GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error)
+}
+
 var NewBlobClientFunc = func(url string, cred azcore.TokenCredential, options *BlobClientOptions) (BlobClient, error) {
 	var clientOptions *azblob.ClientOptions
 	if options != nil {
@@ -53,12 +80,41 @@ func (a *AzureBlobClient) AccountURL() string {
 	return a.accountURL
 }
 
+// AzureBlobConfig selects the account, container and identity a client uses.
+type AzureBlobConfig struct {
+	AccountName   string
+	ContainerName string
+	// ClientID of the workload or managed identity to authenticate as.
+	// Defaults to AZURE_CLIENT_ID.
+	ClientID string
+	// Retry is the retry policy of storage calls. Defaults to DefaultRetry.
+	Retry *policy.RetryOptions
+}
+
+// DefaultRetry retries failed storage calls three times, backing off from
+// one second up to 30.
+var DefaultRetry = policy.RetryOptions{
+	MaxRetries:    3,
+	RetryDelay:    1 * time.Second,
+	MaxRetryDelay: 30 * time.Second,
+}
+
+// NewAzureBlobClient connects to the account and container named by
+// STORAGE_ACCOUNT_NAME and STORAGE_CONTAINER_NAME.
 func NewAzureBlobClient() (*AzureBlobClient, error) {
+	return NewAzureBlobClientWithConfig(AzureBlobConfig{
+		AccountName:   os.Getenv("STORAGE_ACCOUNT_NAME"),
+		ContainerName: os.Getenv("STORAGE_CONTAINER_NAME"),
+	})
+}
+
+// NewAzureBlobClientWithConfig connects to the account and container in cfg,
+// e.g. those of one tenant.
+func NewAzureBlobClientWithConfig(cfg AzureBlobConfig) (*AzureBlobClient, error) {
 	logger := zap.L().Named("azure-blob-client")
 
-	// Get required environment variables
-	storageAccountName := os.Getenv("STORAGE_ACCOUNT_NAME")
-	containerName := os.Getenv("STORAGE_CONTAINER_NAME")
+	storageAccountName := cfg.AccountName
+	containerName := cfg.ContainerName
 
 	if storageAccountName == "" || containerName == "" {
 		logger.Error("Missing required environment variables",
@@ -71,7 +127,10 @@ func NewAzureBlobClient() (*AzureBlobClient, error) {
 	accountURL := fmt.Sprintf("https://%s.blob.core.windows.net", storageAccountName)
 
 	// Get workload identity credentials
-	clientID := os.Getenv("AZURE_CLIENT_ID")
+	clientID := cfg.ClientID
+	if clientID == "" {
+		clientID = os.Getenv("AZURE_CLIENT_ID")
+	}
 	tenantID := os.Getenv("AZURE_TENANT_ID")
 	tokenFilePath := os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
 
@@ -126,13 +185,16 @@ func NewAzureBlobClient() (*AzureBlobClient, error) {
 	}
 
 	// Create the blob client with retry options
+	retry := DefaultRetry
+	if cfg.Retry != nil {
+		retry = *cfg.Retry
+	}
 	clientOptions := &azblob.ClientOptions{
 		ClientOptions: policy.ClientOptions{
-			Retry: policy.RetryOptions{
-				MaxRetries:    3,
-				RetryDelay:    1 * time.Second,
-				MaxRetryDelay: 30 * time.Second,
-			},
+			// The SDK's own spans, one per REST call such as each block
+			// upload and the final commit, nest under ours.
+			TracingProvider: azotel.NewTracingProvider(otel.GetTracerProvider(), nil),
+			Retry:           retry,
 		},
 	}
 
@@ -171,15 +233,71 @@ func NewAzureBlobClient() (*AzureBlobClient, error) {
 	}, nil
 }
 
-func (a *AzureBlobClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
-	_, err := a.client.UploadStream(ctx, a.container, blobName, data, options)
+func (a *AzureBlobClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) (err error) {
+	ctx, done := a.instrument(ctx, opUpload, blobAttr(blobName))
+	defer done(&err)
+	_, err = a.client.UploadStream(ctx, a.container, blobName, data, options)
 	return err
 }
 
-func (a *AzureBlobClient) DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error) {
+func (a *AzureBlobClient) DownloadBlob(ctx context.Context, blobName string) (_ *azblob.DownloadStreamResponse, err error) {
+	ctx, done := a.instrument(ctx, opDownload, blobAttr(blobName))
+	defer done(&err)
 	resp, err := a.client.DownloadStream(ctx, a.container, blobName, nil)
 	if err != nil {
 		return nil, err
 	}
 	return &resp, nil
 }
+
+// DownloadBlobRange downloads count bytes starting at offset. A count of zero
+// or less reads to the end of the blob. The response's ContentRange reports
+// the bytes returned and the total blob size.
+func (a *AzureBlobClient) DownloadBlobRange(ctx context.Context, blobName string, offset, count int64) (_ *azblob.DownloadStreamResponse, err error) {
+	ctx, done := a.instrument(ctx, opDownloadRange, blobAttr(blobName),
+		attribute.Int64("storage.range.offset", offset), attribute.Int64("storage.range.count", count))
+	defer done(&err)
+	if count < 0 {
+		count = 0
+	}
+	resp, err := a.client.DownloadStream(ctx, a.container, blobName, &azblob.DownloadStreamOptions{
+		Range: azblob.HTTPRange{Offset: offset, Count: count},
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.InvalidRange) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRange, err)
+		}
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteBlob removes a blob and its snapshots.
+func (a *AzureBlobClient) DeleteBlob(ctx context.Context, blobName string) (err error) {
+	ctx, done := a.instrument(ctx, opDelete, blobAttr(blobName))
+	defer done(&err)
+	deleteSnapshots := azblob.DeleteSnapshotsOptionTypeInclude
+	_, err = a.client.DeleteBlob(ctx, a.container, blobName, &azblob.DeleteBlobOptions{
+		DeleteSnapshots: &deleteSnapshots,
+	})
+	return err
+}
+
+// ListBlobs returns every blob in the container whose name starts with prefix.
+func (a *AzureBlobClient) ListBlobs(ctx context.Context, prefix string) (_ []*container.BlobItem, err error) {
+	ctx, done := a.instrument(ctx, opList, attribute.String("storage.prefix", prefix))
+	defer done(&err)
+	var items []*container.BlobItem
+	pager := a.client.NewListBlobsFlatPager(a.container, &azblob.ListBlobsFlatOptions{
+		Prefix:  &prefix,
+		Include: azblob.ListBlobsInclude{Metadata: true},
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Segment.BlobItems...)
+	}
+	return items, nil
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// Supported at-rest encodings. The values double as HTTP Content-Encoding tokens.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+const (
+	// MetadataEncoding records the at-rest encoding of a blob.
+	MetadataEncoding = "encoding"
+	// MetadataOriginalSize records the uncompressed size of a blob when the uploader knows it.
+	MetadataOriginalSize = "originalSize"
+)
+
+// CompressingClient compresses blobs on upload and tags them with the
+// encoding used. Downloads are returned as stored; callers either forward
+// the compressed bytes with a Content-Encoding header or call DecodeBody.
+type CompressingClient struct {
+	BlobStore
+	encoding string
+	logger   *zap.Logger
+}
+
+// NewCompressingClient wraps inner so that uploads are compressed with encoding.
+func NewCompressingClient(inner BlobStore, encoding string) (*CompressingClient, error) {
+	if encoding != EncodingGzip && encoding != EncodingZstd {
+		return nil, fmt.Errorf("unsupported storage compression %q (want %q or %q)", encoding, EncodingGzip, EncodingZstd)
+	}
+	return &CompressingClient{
+		BlobStore: inner,
+		encoding:  encoding,
+		logger:    zap.L().Named("compressing-client"),
+	}, nil
+}
+
+// Encoding returns the encoding applied to new uploads.
+func (c *CompressingClient) Encoding() string {
+	return c.encoding
+}
+
+func (c *CompressingClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
+	var opts azblob.UploadStreamOptions
+	if options != nil {
+		opts = *options
+	}
+	headers := blob.HTTPHeaders{}
+	if opts.HTTPHeaders != nil {
+		headers = *opts.HTTPHeaders
+	}
+
+	contentType := ""
+	if headers.BlobContentType != nil {
+		contentType = *headers.BlobContentType
+	}
+	if headers.BlobContentEncoding != nil || IsCompressedContentType(contentType) {
+		return c.BlobStore.UploadBlob(ctx, blobName, data, options)
+	}
+
+	metadata := make(map[string]*string, len(opts.Metadata)+1)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	encoding := c.encoding
+	metadata[MetadataEncoding] = &encoding
+	headers.BlobContentEncoding = &encoding
+	opts.HTTPHeaders = &headers
+	opts.Metadata = metadata
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(c.compress(pw, data))
+	}()
+
+	err := c.BlobStore.UploadBlob(ctx, blobName, pr, &opts)
+	// Unblock the compressor if the upload gave up before draining the pipe.
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	c.logger.Debug("Stored compressed blob", zap.String("blob", blobName), zap.String("encoding", encoding))
+	return nil
+}
+
+func (c *CompressingClient) compress(w io.Writer, r io.Reader) error {
+	var enc io.WriteCloser
+	switch c.encoding {
+	case EncodingZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		enc = zw
+	default:
+		enc = gzip.NewWriter(w)
+	}
+	if _, err := io.Copy(enc, r); err != nil {
+		enc.Close()
+		return err
+	}
+	return enc.Close()
+}
+
+// compressedContentTypes lists MIME types whose payload is already compressed.
+var compressedContentTypes = map[string]bool{
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zstd":             true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+	"application/pdf":              true,
+}
+
+// IsCompressedContentType reports whether compressing a payload of the given
+// MIME type is unlikely to save space.
+func IsCompressedContentType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	if compressedContentTypes[mediaType] {
+		return true
+	}
+	if mediaType == "image/svg+xml" || mediaType == "image/bmp" {
+		return false
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentEncoding returns the at-rest encoding of a downloaded blob, or "" if
+// it is stored as-is.
+func ContentEncoding(resp *azblob.DownloadStreamResponse) string {
+	if resp.ContentEncoding != nil && *resp.ContentEncoding != "" {
+		return *resp.ContentEncoding
+	}
+	return MetadataValue(resp.Metadata, MetadataEncoding)
+}
+
+// DecodeBody replaces the body of a compressed download with a decompressing
+// reader and adjusts the length and encoding fields to describe the original bytes.
+func DecodeBody(resp *azblob.DownloadStreamResponse) error {
+	encoding := ContentEncoding(resp)
+	if encoding == "" {
+		return nil
+	}
+
+	var body io.ReadCloser
+	switch encoding {
+	case EncodingGzip:
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = &decodedBody{Reader: zr, closers: []io.Closer{zr, resp.Body}}
+	case EncodingZstd:
+		zr, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = &decodedBody{Reader: zr, closers: []io.Closer{zstdCloser{zr}, resp.Body}}
+	default:
+		return fmt.Errorf("unsupported blob encoding %q", encoding)
+	}
+
+	resp.Body = body
+	resp.ContentEncoding = nil
+	resp.ContentLength = nil
+	if size, err := strconv.ParseInt(MetadataValue(resp.Metadata, MetadataOriginalSize), 10, 64); err == nil {
+		resp.ContentLength = &size
+	}
+	return nil
+}
+
+// MetadataValue looks up a metadata key case-insensitively, since the service
+// does not preserve the casing used at upload time.
+func MetadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decodedBody) Close() error {
+	var firstErr error
+	for _, c := range d.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type zstdCloser struct {
+	d *zstd.Decoder
+}
+
+func (z zstdCloser) Close() error {
+	z.d.Close()
+	return nil
+}
@@ -0,0 +1,189 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/storage"
+)
+
+// memBlobStore is an in-memory BlobStore that keeps the headers and metadata
+// passed at upload time so they can be returned on download.
+type memBlobStore struct {
+	data     map[string][]byte
+	headers  map[string]blob.HTTPHeaders
+	metadata map[string]map[string]*string
+}
+
+func newMemBlobStore() *memBlobStore {
+	return &memBlobStore{
+		data:     map[string][]byte{},
+		headers:  map[string]blob.HTTPHeaders{},
+		metadata: map[string]map[string]*string{},
+	}
+}
+
+func (m *memBlobStore) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
+	b, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.data[blobName] = b
+	if options != nil {
+		if options.HTTPHeaders != nil {
+			m.headers[blobName] = *options.HTTPHeaders
+		}
+		m.metadata[blobName] = options.Metadata
+	}
+	return nil
+}
+
+func (m *memBlobStore) DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error) {
+	b, ok := m.data[blobName]
+	if !ok {
+		return nil, errors.New("blob not found")
+	}
+	size := int64(len(b))
+	h := m.headers[blobName]
+	resp := &azblob.DownloadStreamResponse{}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = &size
+	resp.ContentType = h.BlobContentType
+	resp.ContentEncoding = h.BlobContentEncoding
+	resp.Metadata = m.metadata[blobName]
+	return resp, nil
+}
+
+func (m *memBlobStore) DownloadBlobRange(ctx context.Context, blobName string, offset, count int64) (*azblob.DownloadStreamResponse, error) {
+	resp, err := m.DownloadBlob(ctx, blobName)
+	if err != nil {
+		return nil, err
+	}
+	b := m.data[blobName]
+	total := int64(len(b))
+	if offset >= total {
+		return nil, storage.ErrInvalidRange
+	}
+	end := total
+	if count > 0 && offset+count < total {
+		end = offset + count
+	}
+	size := end - offset
+	contentRange := fmt.Sprintf("bytes %d-%d/%d", offset, end-1, total)
+	resp.Body = io.NopCloser(bytes.NewReader(b[offset:end]))
+	resp.ContentLength = &size
+	resp.ContentRange = &contentRange
+	return resp, nil
+}
+
+func (m *memBlobStore) ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error) {
+	var items []*container.BlobItem
+	for name := range m.data {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, &container.BlobItem{Name: &name})
+		}
+	}
+	return items, nil
+}
+
+func (m *memBlobStore) GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error) {
+	b, ok := m.data[blobName]
+	if !ok {
+		return nil, storage.ErrBlobNotFound
+	}
+	size := int64(len(b))
+	return &blob.GetPropertiesResponse{ContentLength: &size, Metadata: m.metadata[blobName]}, nil
+}
+
+func (m *memBlobStore) DeleteBlob(ctx context.Context, blobName string) error {
+	delete(m.data, blobName)
+	return nil
+}
+
+func uploadOptions(contentType string, metadata map[string]*string) *azblob.UploadStreamOptions {
+	return &azblob.UploadStreamOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: &contentType},
+		Metadata:    metadata,
+	}
+}
+
+func TestNewCompressingClient_RejectsUnknownEncoding(t *testing.T) {
+	_, err := storage.NewCompressingClient(newMemBlobStore(), "brotli")
+	assert.Error(t, err)
+}
+
+func TestCompressingClient_RoundTrip(t *testing.T) {
+	for _, encoding := range []string{storage.EncodingGzip, storage.EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			inner := newMemBlobStore()
+			client, err := storage.NewCompressingClient(inner, encoding)
+			require.NoError(t, err)
+
+			payload := strings.Repeat("2025-01-01 INFO request served\n", 2000)
+			size := "60000"
+			err = client.UploadBlob(context.Background(), "app.log", strings.NewReader(payload),
+				uploadOptions("text/plain", map[string]*string{storage.MetadataOriginalSize: &size}))
+			require.NoError(t, err)
+
+			assert.Less(t, len(inner.data["app.log"]), len(payload))
+			assert.Equal(t, encoding, *inner.headers["app.log"].BlobContentEncoding)
+
+			resp, err := client.DownloadBlob(context.Background(), "app.log")
+			require.NoError(t, err)
+			assert.Equal(t, encoding, storage.ContentEncoding(resp))
+
+			require.NoError(t, storage.DecodeBody(resp))
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, payload, string(got))
+			assert.Nil(t, resp.ContentEncoding)
+			require.NotNil(t, resp.ContentLength)
+			assert.Equal(t, int64(60000), *resp.ContentLength)
+		})
+	}
+}
+
+func TestCompressingClient_SkipsCompressedTypes(t *testing.T) {
+	inner := newMemBlobStore()
+	client, err := storage.NewCompressingClient(inner, storage.EncodingGzip)
+	require.NoError(t, err)
+
+	err = client.UploadBlob(context.Background(), "photo.jpg", strings.NewReader("jpegdata"), uploadOptions("image/jpeg", nil))
+	require.NoError(t, err)
+
+	assert.Equal(t, "jpegdata", string(inner.data["photo.jpg"]))
+	assert.Nil(t, inner.headers["photo.jpg"].BlobContentEncoding)
+}
+
+func TestIsCompressedContentType(t *testing.T) {
+	assert.True(t, storage.IsCompressedContentType("application/zip"))
+	assert.True(t, storage.IsCompressedContentType("video/mp4"))
+	assert.True(t, storage.IsCompressedContentType("Application/GZIP; charset=binary"))
+	assert.False(t, storage.IsCompressedContentType("image/svg+xml"))
+	assert.False(t, storage.IsCompressedContentType("text/plain"))
+	assert.False(t, storage.IsCompressedContentType(""))
+}
+
+func TestDecodeBody_Uncompressed(t *testing.T) {
+	size := int64(3)
+	resp := &azblob.DownloadStreamResponse{}
+	resp.Body = io.NopCloser(strings.NewReader("abc"))
+	resp.ContentLength = &size
+
+	require.NoError(t, storage.DecodeBody(resp))
+	got, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "abc", string(got))
+	assert.Equal(t, int64(3), *resp.ContentLength)
+}
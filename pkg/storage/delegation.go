@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+	"go.uber.org/zap"
+)
+
+// DirectUploadPrefix holds direct uploads until they are completed, and the
+// markers of completed ones. It is not part of the file namespace: the file
+// handlers refuse names under it and leave it out of listings and usage.
+const DirectUploadPrefix = ".direct-uploads/"
+
+// IsDirectUploadName reports whether name is under DirectUploadPrefix.
+func IsDirectUploadName(name string) bool {
+	return strings.HasPrefix(name, DirectUploadPrefix)
+}
+
+const (
+	// delegationKeyLifetime is how long a fetched user delegation key stays
+	// valid. Keys are reused until they are within maxDelegatedSASTTL of expiry.
+	delegationKeyLifetime = 6 * time.Hour
+	// maxDelegatedSASTTL caps the lifetime of a single delegated SAS.
+	maxDelegatedSASTTL = time.Hour
+	// clockSkew backdates SAS start times to tolerate clock drift.
+	clockSkew = 5 * time.Minute
+	// copyPollInterval is how often a pending copy is checked. Copies within
+	// an account normally finish before StartCopyFromURL returns.
+	copyPollInterval = 500 * time.Millisecond
+)
+
+// delegationKeyCache keeps the current user delegation key so that issuing a
+// SAS does not cost a round trip to the storage service every time.
+type delegationKeyCache struct {
+	mu     sync.Mutex
+	cred   *service.UserDelegationCredential
+	expiry time.Time
+}
+
+// DelegatedUploadURL returns a user-delegation SAS URL that lets the holder
+// create or overwrite exactly one blob until the returned expiry. The
+// service's own identity signs the SAS, so no account key is involved.
+func (a *AzureBlobClient) DelegatedUploadURL(ctx context.Context, blobName string, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 || ttl > maxDelegatedSASTTL {
+		ttl = maxDelegatedSASTTL
+	}
+	now := time.Now().UTC()
+	expiry := now.Add(ttl)
+
+	cred, err := a.userDelegationCredential(ctx, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	perms := sas.BlobPermissions{Create: true, Write: true}
+	qp, err := sas.BlobSignatureValues{
+		Protocol:      sas.ProtocolHTTPS,
+		StartTime:     now.Add(-clockSkew),
+		ExpiryTime:    expiry,
+		Permissions:   perms.String(),
+		ContainerName: a.container,
+		BlobName:      blobName,
+	}.SignWithUserDelegation(cred)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("sign delegated SAS: %w", err)
+	}
+
+	blobURL := fmt.Sprintf("%s/%s/%s?%s", a.accountURL, a.container, url.PathEscape(blobName), qp.Encode())
+	return blobURL, expiry, nil
+}
+
+func (a *AzureBlobClient) userDelegationCredential(ctx context.Context, now time.Time) (*service.UserDelegationCredential, error) {
+	a.delegationKeys.mu.Lock()
+	defer a.delegationKeys.mu.Unlock()
+
+	if a.delegationKeys.cred != nil && now.Add(maxDelegatedSASTTL).Before(a.delegationKeys.expiry) {
+		return a.delegationKeys.cred, nil
+	}
+
+	expiry := now.Add(delegationKeyLifetime)
+	info := service.KeyInfo{
+		Start:  stringPtr(now.Add(-clockSkew).Format(sas.TimeFormat)),
+		Expiry: stringPtr(expiry.Format(sas.TimeFormat)),
+	}
+	keyCtx, done := a.instrument(ctx, opDelegationKey)
+	cred, err := a.client.ServiceClient().GetUserDelegationCredential(keyCtx, info, nil)
+	done(&err)
+	if err != nil {
+		a.logger.Error("Failed to obtain user delegation key", zap.Error(err))
+		return nil, err
+	}
+	a.delegationKeys.cred = cred
+	a.delegationKeys.expiry = expiry
+	return cred, nil
+}
+
+// GetBlobProperties returns the properties and metadata of a blob without downloading it.
+func (a *AzureBlobClient) GetBlobProperties(ctx context.Context, blobName string) (_ *blob.GetPropertiesResponse, err error) {
+	ctx, done := a.instrument(ctx, opGetProperties, blobAttr(blobName))
+	defer done(&err)
+	resp, err := a.client.ServiceClient().NewContainerClient(a.container).NewBlobClient(blobName).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, fmt.Errorf("%w: %v", ErrBlobNotFound, err)
+		}
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CopyBlob copies src to dst within the container and gives dst metadata.
+// The copy only happens if src still has etag, so that what lands at dst is
+// what the caller checked. It returns once the copy has finished.
+func (a *AzureBlobClient) CopyBlob(ctx context.Context, src, dst string, etag azcore.ETag, metadata map[string]*string) (err error) {
+	ctx, done := a.instrument(ctx, opCopy, blobAttr(dst))
+	defer done(&err)
+	cc := a.client.ServiceClient().NewContainerClient(a.container)
+	dstClient := cc.NewBlobClient(dst)
+	resp, err := dstClient.StartCopyFromURL(ctx, cc.NewBlobClient(src).URL(), &blob.StartCopyFromURLOptions{
+		Metadata:                       metadata,
+		SourceModifiedAccessConditions: &blob.SourceModifiedAccessConditions{SourceIfMatch: &etag},
+	})
+	if err != nil {
+		return err
+	}
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			// Don't leave a copy running that the caller considers failed.
+			if resp.CopyID != nil {
+				_, _ = dstClient.AbortCopyFromURL(context.WithoutCancel(ctx), *resp.CopyID, nil)
+			}
+			return ctx.Err()
+		case <-time.After(copyPollInterval):
+		}
+		props, err := dstClient.GetProperties(ctx, nil)
+		if err != nil {
+			return err
+		}
+		status = props.CopyStatus
+	}
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		return fmt.Errorf("copy of %s to %s %s", src, dst, *status)
+	}
+	return nil
+}
+
+// CreateMarker creates an empty blob called name unless one already exists,
+// and reports whether it did. Replicas use it to agree on which of them
+// handles something.
+func (a *AzureBlobClient) CreateMarker(ctx context.Context, name string) (created bool, err error) {
+	ctx, done := a.instrument(ctx, opCreateMarker, blobAttr(name))
+	defer done(&err)
+	etagAny := azcore.ETagAny
+	_, err = a.client.ServiceClient().NewContainerClient(a.container).NewBlockBlobClient(name).Upload(ctx,
+		streaming.NopCloser(bytes.NewReader(nil)),
+		&blockblob.UploadOptions{AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etagAny},
+		}},
+	)
+	if bloberror.HasCode(err, bloberror.BlobAlreadyExists, bloberror.ConditionNotMet) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Ping reads the container's properties, which fails if the credential has
+// expired, lacks access or the container is gone.
+func (a *AzureBlobClient) Ping(ctx context.Context) (err error) {
+	ctx, done := a.instrument(ctx, opContainerProperties)
+	defer done(&err)
+	_, err = a.client.ServiceClient().NewContainerClient(a.container).GetProperties(ctx, nil)
+	return err
+}
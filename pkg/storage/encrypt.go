@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"go.uber.org/zap"
+)
+
+const (
+	// MetadataEncryption names the encryption scheme of an encrypted blob.
+	MetadataEncryption = "encryption"
+	// MetadataEncryptionKeyID identifies the KEK that wrapped the data key.
+	MetadataEncryptionKeyID = "encryptionKeyId"
+	// MetadataWrappedKey holds the base64 wrapped data key.
+	MetadataWrappedKey = "encryptionWrappedKey"
+)
+
+const (
+	encryptionScheme = "AES256-GCM-CHUNKED-V1"
+
+	// encryptionChunkSize is the plaintext size of every chunk except the last.
+	encryptionChunkSize = 64 * 1024
+	gcmTagSize          = 16
+	encryptedChunkSize  = encryptionChunkSize + gcmTagSize
+)
+
+// EncryptingClient encrypts blobs before they reach the wrapped store and
+// decrypts them on download, so the storage provider only ever sees ciphertext.
+//
+// Each object gets a fresh AES-256 data key, wrapped by a KeyWrapper and kept
+// in the blob metadata. The payload is split into 64 KiB chunks sealed with
+// AES-GCM; the nonce encodes the chunk index and a final-chunk flag, which
+// rules out reordering and truncation and lets ranged reads decrypt only the
+// chunks they touch. Every chunk is also authenticated against the blob name
+// and the wrapped key, so an object copied under another name, or given
+// another object's key metadata, fails to decrypt.
+//
+// The name is the one passed to the client, so a PrefixedClient adding a
+// tenant prefix must wrap the EncryptingClient rather than be wrapped by
+// it. Chunks are then bound to the full name, prefix included, and an
+// object copied into another tenant's prefix won't decrypt.
+type EncryptingClient struct {
+	BlobStore
+	keys   KeyWrapper
+	logger *zap.Logger
+}
+
+// NewEncryptingClient wraps inner so that uploads are encrypted with data keys protected by keys.
+func NewEncryptingClient(inner BlobStore, keys KeyWrapper) *EncryptingClient {
+	return &EncryptingClient{
+		BlobStore: inner,
+		keys:      keys,
+		logger:    zap.L().Named("encrypting-client"),
+	}
+}
+
+func (e *EncryptingClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return err
+	}
+	aead, err := newChunkAEAD(dek)
+	if err != nil {
+		return err
+	}
+	wrapped, err := e.keys.WrapKey(ctx, dek)
+	if err != nil {
+		return fmt.Errorf("wrap data key: %w", err)
+	}
+
+	var opts azblob.UploadStreamOptions
+	if options != nil {
+		opts = *options
+	}
+	metadata := make(map[string]*string, len(opts.Metadata)+3)
+	for k, v := range opts.Metadata {
+		metadata[k] = v
+	}
+	metadata[MetadataEncryption] = stringPtr(encryptionScheme)
+	metadata[MetadataEncryptionKeyID] = stringPtr(e.keys.KeyID())
+	metadata[MetadataWrappedKey] = stringPtr(base64.StdEncoding.EncodeToString(wrapped))
+	opts.Metadata = metadata
+
+	ad := additionalData(blobName, wrapped)
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encryptStream(pw, data, aead, ad))
+	}()
+
+	err = e.BlobStore.UploadBlob(ctx, blobName, pr, &opts)
+	pr.CloseWithError(err)
+	return err
+}
+
+func (e *EncryptingClient) DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error) {
+	resp, err := e.BlobStore.DownloadBlob(ctx, blobName)
+	if err != nil {
+		return nil, err
+	}
+	if !isEncrypted(resp) {
+		return resp, nil
+	}
+	if resp.ContentLength == nil {
+		resp.Body.Close()
+		return nil, errors.New("encrypted blob has no content length")
+	}
+
+	aead, ad, err := e.dataKey(ctx, blobName, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	total := *resp.ContentLength
+	plainSize := plaintextSize(total)
+	resp.Body = &decryptReader{
+		body:      resp.Body,
+		aead:      aead,
+		ad:        ad,
+		index:     0,
+		final:     lastChunkIndex(total),
+		remaining: plainSize,
+	}
+	resp.ContentLength = &plainSize
+	resp.ContentMD5 = nil
+	return resp, nil
+}
+
+// DownloadBlobRange maps a plaintext range onto the covering ciphertext
+// chunks, fetches only those and decrypts them.
+func (e *EncryptingClient) DownloadBlobRange(ctx context.Context, blobName string, offset, count int64) (*azblob.DownloadStreamResponse, error) {
+	firstChunk := offset / encryptionChunkSize
+	var cipherCount int64
+	if count > 0 {
+		lastChunk := (offset + count - 1) / encryptionChunkSize
+		cipherCount = (lastChunk - firstChunk + 1) * encryptedChunkSize
+	}
+
+	resp, err := e.BlobStore.DownloadBlobRange(ctx, blobName, firstChunk*encryptedChunkSize, cipherCount)
+	if err != nil {
+		return nil, err
+	}
+	if !isEncrypted(resp) {
+		// The chunk-aligned range we asked for is wider than the caller's, so
+		// plain blobs are fetched again with the real bounds.
+		resp.Body.Close()
+		return e.BlobStore.DownloadBlobRange(ctx, blobName, offset, count)
+	}
+
+	total, ok := contentRangeTotal(resp.ContentRange)
+	if !ok {
+		resp.Body.Close()
+		return nil, errors.New("encrypted blob range response has no total size")
+	}
+	plainSize := plaintextSize(total)
+	if offset >= plainSize {
+		resp.Body.Close()
+		return nil, ErrInvalidRange
+	}
+	end := plainSize - 1
+	if count > 0 && offset+count-1 < end {
+		end = offset + count - 1
+	}
+
+	aead, ad, err := e.dataKey(ctx, blobName, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	length := end - offset + 1
+	resp.Body = &decryptReader{
+		body:      resp.Body,
+		aead:      aead,
+		ad:        ad,
+		index:     uint64(firstChunk),
+		final:     lastChunkIndex(total),
+		skip:      offset - firstChunk*encryptionChunkSize,
+		remaining: length,
+	}
+	resp.ContentLength = &length
+	resp.ContentRange = stringPtr(fmt.Sprintf("bytes %d-%d/%d", offset, end, plainSize))
+	resp.ContentMD5 = nil
+	return resp, nil
+}
+
+// dataKey unwraps the data key of blobName and returns it with the
+// additional data its chunks were sealed with.
+func (e *EncryptingClient) dataKey(ctx context.Context, blobName string, resp *azblob.DownloadStreamResponse) (cipher.AEAD, []byte, error) {
+	if scheme := MetadataValue(resp.Metadata, MetadataEncryption); scheme != encryptionScheme {
+		return nil, nil, fmt.Errorf("unsupported encryption scheme %q", scheme)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(MetadataValue(resp.Metadata, MetadataWrappedKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode wrapped key: %w", err)
+	}
+	dek, err := e.keys.UnwrapKey(ctx, MetadataValue(resp.Metadata, MetadataEncryptionKeyID), wrapped)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	aead, err := newChunkAEAD(dek)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, additionalData(blobName, wrapped), nil
+}
+
+// additionalData binds chunks to the blob name and wrapped key. The name is
+// length-prefixed so that no two pairs encode the same way.
+func additionalData(blobName string, wrapped []byte) []byte {
+	ad := make([]byte, 0, 4+len(blobName)+len(wrapped))
+	ad = binary.BigEndian.AppendUint32(ad, uint32(len(blobName)))
+	ad = append(ad, blobName...)
+	return append(ad, wrapped...)
+}
+
+func isEncrypted(resp *azblob.DownloadStreamResponse) bool {
+	return MetadataValue(resp.Metadata, MetadataEncryption) != ""
+}
+
+func newChunkAEAD(dek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(index uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	if final {
+		nonce[0] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	return nonce
+}
+
+// plaintextSize derives the plaintext length from the stored ciphertext length.
+// Every object has at least one chunk, so empty plaintext is one bare tag.
+func plaintextSize(cipherSize int64) int64 {
+	chunks := (cipherSize + encryptedChunkSize - 1) / encryptedChunkSize
+	if chunks == 0 {
+		return 0
+	}
+	return cipherSize - chunks*gcmTagSize
+}
+
+func lastChunkIndex(cipherSize int64) uint64 {
+	chunks := (cipherSize + encryptedChunkSize - 1) / encryptedChunkSize
+	if chunks == 0 {
+		return 0
+	}
+	return uint64(chunks - 1)
+}
+
+// contentRangeTotal extracts the complete length from a "bytes a-b/total" header value.
+func contentRangeTotal(contentRange *string) (int64, bool) {
+	if contentRange == nil {
+		return 0, false
+	}
+	_, total, ok := strings.Cut(*contentRange, "/")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(total, 10, 64)
+	return n, err == nil
+}
+
+// readChunk fills p from r, reporting eof when r ran out before p was full.
+func readChunk(r io.Reader, p []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, p)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	return n, false, err
+}
+
+func encryptStream(w io.Writer, r io.Reader, aead cipher.AEAD, ad []byte) error {
+	cur := make([]byte, encryptionChunkSize)
+	next := make([]byte, encryptionChunkSize)
+	out := make([]byte, 0, encryptedChunkSize)
+
+	n, eof, err := readChunk(r, cur)
+	if err != nil {
+		return err
+	}
+	for index := uint64(0); ; index++ {
+		// Read one chunk ahead so the final flag is known before sealing.
+		final := eof
+		var nextN int
+		var nextEOF bool
+		if !final {
+			nextN, nextEOF, err = readChunk(r, next)
+			if err != nil {
+				return err
+			}
+			final = nextN == 0 && nextEOF
+		}
+
+		out = aead.Seal(out[:0], chunkNonce(index, final), cur[:n], ad)
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		cur, next = next, cur
+		n, eof = nextN, nextEOF
+	}
+}
+
+// decryptReader decrypts a run of consecutive chunks starting at index.
+type decryptReader struct {
+	body      io.ReadCloser
+	aead      cipher.AEAD
+	ad        []byte
+	index     uint64
+	final     uint64
+	skip      int64
+	remaining int64
+
+	buf   []byte
+	plain []byte
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	if d.remaining <= 0 {
+		return 0, io.EOF
+	}
+	for len(d.plain) == 0 {
+		if err := d.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	d.remaining -= int64(n)
+	return n, nil
+}
+
+func (d *decryptReader) nextChunk() error {
+	if d.index > d.final {
+		return io.ErrUnexpectedEOF
+	}
+	if d.buf == nil {
+		d.buf = make([]byte, encryptedChunkSize)
+	}
+	n, err := io.ReadFull(d.body, d.buf)
+	if err == io.EOF || (err == io.ErrUnexpectedEOF && d.index != d.final) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.index, d.index == d.final), d.buf[:n], d.ad)
+	if err != nil {
+		return fmt.Errorf("decrypt chunk %d: %w", d.index, err)
+	}
+	d.index++
+	if d.skip > 0 {
+		skip := min(d.skip, int64(len(plain)))
+		plain = plain[skip:]
+		d.skip -= skip
+	}
+	d.plain = plain
+	return nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.body.Close()
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,162 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/storage"
+)
+
+const testChunk = 64 * 1024
+
+func newTestKeyWrapper(t *testing.T) *storage.LocalKeyWrapper {
+	t.Helper()
+	kek := make([]byte, 32)
+	_, err := rand.Read(kek)
+	require.NoError(t, err)
+	keys, err := storage.NewLocalKeyWrapper(kek)
+	require.NoError(t, err)
+	return keys
+}
+
+func randomPayload(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestEncryptingClient_RoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, testChunk - 1, testChunk, testChunk + 1, 3*testChunk + 5} {
+		inner := newMemBlobStore()
+		client := storage.NewEncryptingClient(inner, newTestKeyWrapper(t))
+		payload := randomPayload(t, n)
+
+		require.NoError(t, client.UploadBlob(context.Background(), "secret.bin", bytes.NewReader(payload), nil))
+		if n > 0 {
+			assert.NotContains(t, string(inner.data["secret.bin"]), string(payload[:min(n, 64)]), "size %d", n)
+		}
+
+		resp, err := client.DownloadBlob(context.Background(), "secret.bin")
+		require.NoError(t, err)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err, "size %d", n)
+		assert.Equal(t, payload, got, "size %d", n)
+		assert.Equal(t, int64(n), *resp.ContentLength)
+	}
+}
+
+func TestEncryptingClient_RangedReads(t *testing.T) {
+	inner := newMemBlobStore()
+	client := storage.NewEncryptingClient(inner, newTestKeyWrapper(t))
+	payload := randomPayload(t, 3*testChunk+100)
+	require.NoError(t, client.UploadBlob(context.Background(), "r.bin", bytes.NewReader(payload), nil))
+
+	cases := []struct{ offset, count int64 }{
+		{0, 10},
+		{testChunk - 5, 10},
+		{testChunk, testChunk},
+		{2*testChunk + 7, 0},
+		{3*testChunk + 90, 500},
+	}
+	for _, tc := range cases {
+		resp, err := client.DownloadBlobRange(context.Background(), "r.bin", tc.offset, tc.count)
+		require.NoError(t, err)
+		got, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		end := int64(len(payload))
+		if tc.count > 0 && tc.offset+tc.count < end {
+			end = tc.offset + tc.count
+		}
+		assert.Equal(t, payload[tc.offset:end], got, "range %d+%d", tc.offset, tc.count)
+		assert.Equal(t, end-tc.offset, *resp.ContentLength)
+		assert.Contains(t, *resp.ContentRange, "/196708")
+	}
+
+	_, err := client.DownloadBlobRange(context.Background(), "r.bin", int64(len(payload)), 0)
+	assert.ErrorIs(t, err, storage.ErrInvalidRange)
+}
+
+func TestEncryptingClient_DetectsTampering(t *testing.T) {
+	inner := newMemBlobStore()
+	client := storage.NewEncryptingClient(inner, newTestKeyWrapper(t))
+	require.NoError(t, client.UploadBlob(context.Background(), "t.bin", bytes.NewReader(randomPayload(t, 2*testChunk)), nil))
+
+	// Dropping the final chunk must not look like a valid shorter object.
+	inner.data["t.bin"] = inner.data["t.bin"][:testChunk+16]
+	resp, err := client.DownloadBlob(context.Background(), "t.bin")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+}
+
+func TestEncryptingClient_BindsNameAndKey(t *testing.T) {
+	inner := newMemBlobStore()
+	client := storage.NewEncryptingClient(inner, newTestKeyWrapper(t))
+	ctx := context.Background()
+	require.NoError(t, client.UploadBlob(ctx, "tenant-a/report.pdf", bytes.NewReader([]byte("a's report")), nil))
+	require.NoError(t, client.UploadBlob(ctx, "tenant-b/report.pdf", bytes.NewReader([]byte("b's report")), nil))
+
+	// An object copied with its metadata under another name.
+	inner.data["tenant-b/copy.pdf"] = inner.data["tenant-a/report.pdf"]
+	inner.metadata["tenant-b/copy.pdf"] = inner.metadata["tenant-a/report.pdf"]
+	resp, err := client.DownloadBlob(ctx, "tenant-b/copy.pdf")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+
+	// Another object's ciphertext under this object's metadata.
+	inner.data["tenant-b/report.pdf"] = inner.data["tenant-a/report.pdf"]
+	resp, err = client.DownloadBlobRange(ctx, "tenant-b/report.pdf", 0, 4)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+}
+
+func TestEncryptingClient_PlainBlobsPassThrough(t *testing.T) {
+	inner := newMemBlobStore()
+	require.NoError(t, inner.UploadBlob(context.Background(), "plain.txt", bytes.NewReader([]byte("hello world")), nil))
+	client := storage.NewEncryptingClient(inner, newTestKeyWrapper(t))
+
+	resp, err := client.DownloadBlobRange(context.Background(), "plain.txt", 6, 5)
+	require.NoError(t, err)
+	got, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "world", string(got))
+}
+
+func TestEncryptingClient_WrongKey(t *testing.T) {
+	inner := newMemBlobStore()
+	require.NoError(t, storage.NewEncryptingClient(inner, newTestKeyWrapper(t)).
+		UploadBlob(context.Background(), "k.bin", bytes.NewReader([]byte("data")), nil))
+
+	_, err := storage.NewEncryptingClient(inner, newTestKeyWrapper(t)).DownloadBlob(context.Background(), "k.bin")
+	assert.True(t, errors.Is(err, storage.ErrUnknownKey))
+}
+
+func TestNewLocalKeyWrapperFromFile(t *testing.T) {
+	kek := randomPayload(t, 32)
+	path := filepath.Join(t.TempDir(), "kek")
+	require.NoError(t, os.WriteFile(path, []byte(hex.EncodeToString(kek)+"\n"), 0o600))
+
+	fromFile, err := storage.NewLocalKeyWrapperFromFile(path)
+	require.NoError(t, err)
+	direct, err := storage.NewLocalKeyWrapper(kek)
+	require.NoError(t, err)
+	assert.Equal(t, direct.KeyID(), fromFile.KeyID())
+
+	require.NoError(t, os.WriteFile(path, []byte("too short"), 0o600))
+	_, err = storage.NewLocalKeyWrapperFromFile(path)
+	assert.Error(t, err)
+}
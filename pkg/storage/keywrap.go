@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyWrapper protects per-object data keys with a key-encryption key (KEK).
+// Implementations may keep the KEK locally or delegate to a KMS such as
+// Azure Key Vault; the data key never leaves the service unwrapped.
+type KeyWrapper interface {
+	// KeyID identifies the KEK used by WrapKey. It is stored with each object
+	// so the matching KEK can be selected after rotation.
+	KeyID() string
+	WrapKey(ctx context.Context, dek []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// ErrUnknownKey is returned when an object was wrapped with a KEK the wrapper does not hold.
+var ErrUnknownKey = errors.New("unknown key-encryption key")
+
+// LocalKeyWrapper wraps data keys with AES-256-GCM under a KEK held in memory.
+// It is intended for tests and single-cluster deployments where the KEK is
+// mounted from a secret.
+type LocalKeyWrapper struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewLocalKeyWrapper creates a wrapper from a 32-byte KEK. The key ID is
+// derived from the key so that every replica agrees on it.
+func NewLocalKeyWrapper(kek []byte) (*LocalKeyWrapper, error) {
+	if len(kek) != 32 {
+		return nil, fmt.Errorf("key-encryption key must be 32 bytes, got %d", len(kek))
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(kek)
+	return &LocalKeyWrapper{
+		id:   "local:" + hex.EncodeToString(sum[:8]),
+		aead: aead,
+	}, nil
+}
+
+// NewLocalKeyWrapperFromFile loads a KEK from a file containing either 32 raw
+// bytes, or the key encoded as hex or base64.
+func NewLocalKeyWrapperFromFile(path string) (*LocalKeyWrapper, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := decodeKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return NewLocalKeyWrapper(kek)
+}
+
+func decodeKey(raw []byte) ([]byte, error) {
+	if len(raw) == 32 {
+		return raw, nil
+	}
+	text := strings.TrimSpace(string(raw))
+	if b, err := hex.DecodeString(text); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(text); err == nil && len(b) == 32 {
+		return b, nil
+	}
+	return nil, errors.New("expected a 32-byte key (raw, hex or base64)")
+}
+
+func (l *LocalKeyWrapper) KeyID() string {
+	return l.id
+}
+
+func (l *LocalKeyWrapper) WrapKey(_ context.Context, dek []byte) ([]byte, error) {
+	nonce := make([]byte, l.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return l.aead.Seal(nonce, nonce, dek, []byte(l.id)), nil
+}
+
+func (l *LocalKeyWrapper) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != l.id {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	ns := l.aead.NonceSize()
+	if len(wrapped) < ns {
+		return nil, errors.New("wrapped key too short")
+	}
+	return l.aead.Open(nil, wrapped[:ns], wrapped[ns:], []byte(l.id))
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"stream-upload-file/pkg/requestid"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("stream-upload-file/storage")
+
+// Storage operations, used as the "operation" label.
+const (
+	opUpload        = "upload"
+	opDownload      = "download"
+	opDownloadRange = "download_range"
+	opDelete        = "delete"
+	opList          = "list"
+	opGetProperties = "get_properties"
+	opCopy          = "copy"
+	opCreateMarker  = "create_marker"
+	opDelegationKey = "get_user_delegation_key"
+
+	opContainerProperties = "get_container_properties"
+)
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "storage_operation_duration_seconds",
+		Help: "Time taken by Blob Storage calls. Downloads are timed until the response headers arrive.",
+		// Uploads stream the whole file and take minutes for large ones.
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"operation"})
+
+	operationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_operation_errors_total",
+		Help: "Failed Blob Storage calls by Azure error code, e.g. BlobNotFound.",
+	}, []string{"operation", "code"})
+)
+
+// instrument starts a span for a storage call and returns the context to
+// make the call with. The returned function ends the span and records the
+// call's metrics; it is deferred with a pointer to the call's error so that
+// it sees the final value.
+func (a *AzureBlobClient) instrument(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, func(err *error)) {
+	start := time.Now()
+	if id := requestid.FromContext(ctx); id != "" {
+		// Azure logs this header, so its diagnostics can be matched to ours.
+		ctx = policy.WithHTTPHeader(ctx, http.Header{"x-ms-client-request-id": {id}})
+		attrs = append(attrs, attribute.String("request.id", id))
+	}
+	ctx, span := tracer.Start(ctx, "storage."+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("storage.container", a.container))...))
+	return ctx, func(err *error) {
+		operationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		if *err != nil {
+			code := errorCode(*err)
+			operationErrors.WithLabelValues(operation, code).Inc()
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, code)
+		}
+		span.End()
+	}
+}
+
+func blobAttr(name string) attribute.KeyValue {
+	return attribute.String("storage.blob", name)
+}
+
+// errorCode returns a low-cardinality label for err.
+func errorCode(err error) string {
+	var respErr *azcore.ResponseError
+	switch {
+	case errors.As(err, &respErr) && respErr.ErrorCode != "":
+		return respErr.ErrorCode
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
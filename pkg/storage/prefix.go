@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// PrefixedClient is a BlobStore decorator that confines all operations to
+// blobs under a fixed prefix, so several tenants can share one container.
+// Names passed in and returned from listings are relative to the prefix.
+type PrefixedClient struct {
+	BlobStore
+	prefix string
+}
+
+// NewPrefixedClient wraps inner so that every blob name is stored under prefix.
+func NewPrefixedClient(inner BlobStore, prefix string) *PrefixedClient {
+	return &PrefixedClient{BlobStore: inner, prefix: prefix}
+}
+
+// Prefix returns the prefix blobs are stored under.
+func (p *PrefixedClient) Prefix() string {
+	return p.prefix
+}
+
+func (p *PrefixedClient) UploadBlob(ctx context.Context, blobName string, data io.Reader, options *azblob.UploadStreamOptions) error {
+	return p.BlobStore.UploadBlob(ctx, p.prefix+blobName, data, options)
+}
+
+func (p *PrefixedClient) DownloadBlob(ctx context.Context, blobName string) (*azblob.DownloadStreamResponse, error) {
+	return p.BlobStore.DownloadBlob(ctx, p.prefix+blobName)
+}
+
+func (p *PrefixedClient) DownloadBlobRange(ctx context.Context, blobName string, offset, count int64) (*azblob.DownloadStreamResponse, error) {
+	return p.BlobStore.DownloadBlobRange(ctx, p.prefix+blobName, offset, count)
+}
+
+func (p *PrefixedClient) DeleteBlob(ctx context.Context, blobName string) error {
+	return p.BlobStore.DeleteBlob(ctx, p.prefix+blobName)
+}
+
+func (p *PrefixedClient) GetBlobProperties(ctx context.Context, blobName string) (*blob.GetPropertiesResponse, error) {
+	return p.BlobStore.GetBlobProperties(ctx, p.prefix+blobName)
+}
+
+func (p *PrefixedClient) ListBlobs(ctx context.Context, prefix string) ([]*container.BlobItem, error) {
+	items, err := p.BlobStore.ListBlobs(ctx, p.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.Name != nil {
+			name := strings.TrimPrefix(*item.Name, p.prefix)
+			item.Name = &name
+		}
+	}
+	return items, nil
+}
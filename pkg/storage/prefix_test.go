@@ -0,0 +1,43 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/storage"
+)
+
+func TestPrefixedClient(t *testing.T) {
+	ctx := context.Background()
+	inner := newMemBlobStore()
+	inner.data["other/secret.txt"] = []byte("not yours")
+	client := storage.NewPrefixedClient(inner, "finance/")
+
+	require.NoError(t, client.UploadBlob(ctx, "q1/report.csv", bytes.NewReader([]byte("numbers")), nil))
+	assert.Contains(t, inner.data, "finance/q1/report.csv")
+
+	resp, err := client.DownloadBlob(ctx, "q1/report.csv")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "numbers", string(body))
+
+	props, err := client.GetBlobProperties(ctx, "q1/report.csv")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *props.ContentLength)
+
+	items, err := client.ListBlobs(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "q1/report.csv", *items[0].Name)
+
+	_, err = client.DownloadBlob(ctx, "../other/secret.txt")
+	assert.Error(t, err)
+
+	require.NoError(t, client.DeleteBlob(ctx, "q1/report.csv"))
+	assert.NotContains(t, inner.data, "finance/q1/report.csv")
+}
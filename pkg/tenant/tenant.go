@@ -0,0 +1,260 @@
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/presign"
+	"stream-upload-file/pkg/requestid"
+	"stream-upload-file/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Sources a request's tenant can be resolved from.
+const (
+	SourceHost   = "host"
+	SourceHeader = "header"
+	SourceClaim  = "claim"
+)
+
+// DefaultHeader carries the tenant name when resolving from a header.
+const DefaultHeader = "X-Tenant"
+
+// tenantKey is the gin context key under which the resolved tenant is stored.
+const tenantKey = "tenant"
+
+var (
+	ErrNoTenant      = errors.New("request does not name a tenant")
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrWrongTenant   = errors.New("credential belongs to a different tenant")
+)
+
+// Tenant is one business unit with its own storage namespace. Unset
+// account and container fields fall back to the service defaults.
+type Tenant struct {
+	Name string `json:"-"`
+	// Hosts are the hostnames that select this tenant when resolving by host.
+	Hosts     []string `json:"hosts,omitempty"`
+	Account   string   `json:"account,omitempty"`
+	Container string   `json:"container,omitempty"`
+	// Prefix confines the tenant to blobs under it in a shared container.
+	Prefix string `json:"prefix,omitempty"`
+	// ClientID is the managed identity used for this tenant's account.
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// Config is the on-disk form of the tenant configuration.
+type Config struct {
+	Source string `json:"source"`
+	// Header names the header used with SourceHeader. Defaults to X-Tenant.
+	Header string `json:"header,omitempty"`
+	// Default is used for requests that don't name a tenant.
+	Default string             `json:"default,omitempty"`
+	Tenants map[string]*Tenant `json:"tenants"`
+}
+
+// LoadConfig reads and validates a tenant configuration file.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("tenant config %s: %w", path, err)
+	}
+	if err := cfg.normalize(); err != nil {
+		return nil, fmt.Errorf("tenant config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) normalize() error {
+	switch cfg.Source {
+	case SourceHost, SourceHeader, SourceClaim:
+	default:
+		return fmt.Errorf("unknown tenant source %q", cfg.Source)
+	}
+	if cfg.Header == "" {
+		cfg.Header = DefaultHeader
+	}
+	if len(cfg.Tenants) == 0 {
+		return errors.New("no tenants defined")
+	}
+	if _, ok := cfg.Tenants[cfg.Default]; cfg.Default != "" && !ok {
+		return fmt.Errorf("default tenant %q is not defined", cfg.Default)
+	}
+	hosts := map[string]string{}
+	for name, t := range cfg.Tenants {
+		t.Name = name
+		if t.Prefix != "" && !strings.HasSuffix(t.Prefix, "/") {
+			t.Prefix += "/"
+		}
+		for _, h := range t.Hosts {
+			h = strings.ToLower(h)
+			if other, dup := hosts[h]; dup {
+				return fmt.Errorf("host %q is used by tenants %q and %q", h, other, name)
+			}
+			hosts[h] = name
+		}
+	}
+	return nil
+}
+
+// Resolver determines the tenant a request belongs to.
+type Resolver struct {
+	cfg   *Config
+	hosts map[string]*Tenant
+}
+
+// NewResolver returns a resolver for cfg.
+func NewResolver(cfg *Config) *Resolver {
+	r := &Resolver{cfg: cfg, hosts: map[string]*Tenant{}}
+	for _, t := range cfg.Tenants {
+		for _, h := range t.Hosts {
+			r.hosts[strings.ToLower(h)] = t
+		}
+	}
+	return r
+}
+
+// Tenants returns every configured tenant.
+func (r *Resolver) Tenants() []*Tenant {
+	out := make([]*Tenant, 0, len(r.cfg.Tenants))
+	for _, t := range r.cfg.Tenants {
+		out = append(out, t)
+	}
+	return out
+}
+
+// Resolve returns the tenant of a request. It must run after
+// authentication so that claims and credential bindings are known.
+func (r *Resolver) Resolve(c *gin.Context) (*Tenant, error) {
+	id, authenticated := auth.FromContext(c)
+
+	var name string
+	switch r.cfg.Source {
+	case SourceHost:
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if t, ok := r.hosts[strings.ToLower(host)]; ok {
+			name = t.Name
+		}
+	case SourceHeader:
+		name = c.GetHeader(r.cfg.Header)
+	case SourceClaim:
+		if authenticated {
+			name = id.Tenant
+		}
+	}
+	// Presigned requests carry no credential; the tenant they were issued
+	// for is part of the signed parameters, which the handler verifies.
+	if name == "" && !authenticated && presign.IsPresigned(c.Request.URL.Query()) {
+		name = c.Query(presign.ParamTenant)
+	}
+	if name == "" {
+		name = r.cfg.Default
+	}
+	if name == "" {
+		return nil, ErrNoTenant
+	}
+
+	t, ok := r.cfg.Tenants[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTenant, name)
+	}
+	if authenticated && id.Tenant != "" && id.Tenant != t.Name {
+		return nil, ErrWrongTenant
+	}
+	return t, nil
+}
+
+// Middleware resolves the tenant of every request and makes it available
+// through FromContext.
+func Middleware(r *Resolver) gin.HandlerFunc {
+	logger := zap.L().Named("tenant")
+	return func(c *gin.Context) {
+		t, err := r.Resolve(c)
+		if err != nil {
+			requestid.Logger(c, logger).Warn("Failed to resolve tenant",
+				zap.String("host", c.Request.Host),
+				zap.String("client-ip", c.ClientIP()),
+				zap.Error(err),
+			)
+			switch {
+			case errors.Is(err, ErrWrongTenant):
+				c.AbortWithStatusJSON(http.StatusForbidden, requestid.Error(c, "Permission denied"))
+			case errors.Is(err, ErrUnknownTenant):
+				c.AbortWithStatusJSON(http.StatusNotFound, requestid.Error(c, "Unknown tenant"))
+			default:
+				c.AbortWithStatusJSON(http.StatusBadRequest, requestid.Error(c, "Tenant required"))
+			}
+			return
+		}
+		SetTenant(c, t)
+		c.Next()
+	}
+}
+
+// SetTenant attaches a tenant to the request.
+func SetTenant(c *gin.Context, t *Tenant) {
+	c.Set(tenantKey, t)
+}
+
+// FromContext returns the tenant resolved by the middleware, if any.
+func FromContext(c *gin.Context) (*Tenant, bool) {
+	v, ok := c.Get(tenantKey)
+	if !ok {
+		return nil, false
+	}
+	t, ok := v.(*Tenant)
+	return t, ok && t != nil
+}
+
+// Name returns the tenant name of a request, or "" in single-tenant mode.
+func Name(c *gin.Context) string {
+	if t, ok := FromContext(c); ok {
+		return t.Name
+	}
+	return ""
+}
+
+// Stores creates each tenant's storage on first use and caches it, so
+// clients and their credentials are shared by all requests of a tenant.
+type Stores struct {
+	factory func(*Tenant) (storage.BlobStore, error)
+
+	mu     sync.Mutex
+	stores map[string]storage.BlobStore
+}
+
+// NewStores returns a cache that builds stores with factory.
+func NewStores(factory func(*Tenant) (storage.BlobStore, error)) *Stores {
+	return &Stores{factory: factory, stores: map[string]storage.BlobStore{}}
+}
+
+// Store returns the storage of tenant t.
+func (s *Stores) Store(t *Tenant) (storage.BlobStore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if store, ok := s.stores[t.Name]; ok {
+		return store, nil
+	}
+	store, err := s.factory(t)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %q storage: %w", t.Name, err)
+	}
+	s.stores[t.Name] = store
+	return store, nil
+}
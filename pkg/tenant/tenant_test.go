@@ -0,0 +1,135 @@
+package tenant_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/auth"
+	"stream-upload-file/pkg/storage"
+	"stream-upload-file/pkg/tenant"
+)
+
+func loadConfig(t *testing.T, content string) (*tenant.Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return tenant.LoadConfig(path)
+}
+
+// resolve runs the tenant middleware behind an optional identity and
+// returns the status and resolved tenant name.
+func resolve(t *testing.T, cfg *tenant.Config, id *auth.Identity, prepare func(*http.Request)) (int, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var got string
+	router.GET("/files", func(c *gin.Context) {
+		if id != nil {
+			auth.SetIdentity(c, id)
+		}
+	}, tenant.Middleware(tenant.NewResolver(cfg)), func(c *gin.Context) {
+		got = tenant.Name(c)
+	})
+	req := httptest.NewRequest("GET", "/files", nil)
+	if prepare != nil {
+		prepare(req)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code, got
+}
+
+func TestResolve_Host(t *testing.T) {
+	cfg, err := loadConfig(t, `{"source":"host","tenants":{
+		"finance":{"hosts":["finance.files.example.com"],"container":"finance"},
+		"hr":{"hosts":["HR.files.example.com"],"prefix":"hr"}
+	}}`)
+	require.NoError(t, err)
+	assert.Equal(t, "hr/", cfg.Tenants["hr"].Prefix)
+
+	code, name := resolve(t, cfg, nil, func(r *http.Request) { r.Host = "hr.files.example.com:8443" })
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hr", name)
+
+	code, _ = resolve(t, cfg, nil, func(r *http.Request) { r.Host = "unknown.example.com" })
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// A credential bound to one tenant can't be used on another's host.
+	code, _ = resolve(t, cfg, &auth.Identity{ID: "k", Tenant: "finance"}, func(r *http.Request) { r.Host = "hr.files.example.com" })
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestResolve_HeaderWithDefault(t *testing.T) {
+	cfg, err := loadConfig(t, `{"source":"header","default":"shared","tenants":{"shared":{},"ops":{"prefix":"ops/"}}}`)
+	require.NoError(t, err)
+
+	_, name := resolve(t, cfg, nil, func(r *http.Request) { r.Header.Set(tenant.DefaultHeader, "ops") })
+	assert.Equal(t, "ops", name)
+	_, name = resolve(t, cfg, nil, nil)
+	assert.Equal(t, "shared", name)
+	code, _ := resolve(t, cfg, nil, func(r *http.Request) { r.Header.Set(tenant.DefaultHeader, "nope") })
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestResolve_Claim(t *testing.T) {
+	cfg, err := loadConfig(t, `{"source":"claim","tenants":{"finance":{},"hr":{}}}`)
+	require.NoError(t, err)
+
+	_, name := resolve(t, cfg, &auth.Identity{ID: "alice", Tenant: "finance"}, nil)
+	assert.Equal(t, "finance", name)
+
+	code, _ := resolve(t, cfg, &auth.Identity{ID: "bob"}, nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Presigned requests name the tenant they were signed for.
+	_, name = resolve(t, cfg, nil, func(r *http.Request) { r.URL.RawQuery = "tenant=hr&sig=x" })
+	assert.Equal(t, "hr", name)
+	code, _ = resolve(t, cfg, nil, func(r *http.Request) { r.URL.RawQuery = "tenant=hr" })
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"source":     `{"source":"cookie","tenants":{"a":{}}}`,
+		"no tenants": `{"source":"header","tenants":{}}`,
+		"default":    `{"source":"header","default":"b","tenants":{"a":{}}}`,
+		"dup host":   `{"source":"host","tenants":{"a":{"hosts":["x.example.com"]},"b":{"hosts":["X.example.com"]}}}`,
+	} {
+		_, err := loadConfig(t, content)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestStores_CachesPerTenant(t *testing.T) {
+	calls := map[string]int{}
+	stores := tenant.NewStores(func(tn *tenant.Tenant) (storage.BlobStore, error) {
+		calls[tn.Name]++
+		if tn.Name == "broken" {
+			return nil, errors.New("no access")
+		}
+		return storage.NewPrefixedClient(nil, tn.Prefix), nil
+	})
+
+	a, err := stores.Store(&tenant.Tenant{Name: "a", Prefix: "a/"})
+	require.NoError(t, err)
+	again, err := stores.Store(&tenant.Tenant{Name: "a", Prefix: "a/"})
+	require.NoError(t, err)
+	assert.Same(t, a, again)
+	_, err = stores.Store(&tenant.Tenant{Name: "b"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls["a"])
+
+	_, err = stores.Store(&tenant.Tenant{Name: "broken"})
+	assert.Error(t, err)
+	_, err = stores.Store(&tenant.Tenant{Name: "broken"})
+	assert.Error(t, err)
+	assert.Equal(t, 2, calls["broken"], "failures are retried")
+}
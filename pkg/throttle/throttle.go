@@ -0,0 +1,191 @@
+package throttle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// maxChunk bounds how many bytes move between two waits, so that a
+// transfer's rate is smooth rather than a burst per buffer.
+const maxChunk = 64 * 1024
+
+// Limit is a bandwidth limit in bytes per second. Zero fields are unlimited.
+type Limit struct {
+	// PerConnection caps each upload or download.
+	PerConnection int64 `json:"perConnection,omitempty"`
+	// Total caps all transfers together.
+	Total int64 `json:"total,omitempty"`
+}
+
+// Config is the on-disk form of the bandwidth limits. Default.Total caps
+// the whole pod. A tenant entry replaces the per-connection limit for that
+// tenant and, if it sets Total, caps the tenant's transfers together on
+// top of the pod-wide cap.
+type Config struct {
+	Default Limit            `json:"default"`
+	Tenants map[string]Limit `json:"tenants,omitempty"`
+}
+
+// LoadConfig reads a bandwidth configuration file.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("bandwidth config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("bandwidth config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Default.PerConnection < 0 || cfg.Default.Total < 0 {
+		return fmt.Errorf("default limits must not be negative")
+	}
+	for name, l := range cfg.Tenants {
+		if l.PerConnection < 0 || l.Total < 0 {
+			return fmt.Errorf("tenant %q limits must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// Throttle limits the bandwidth of transfers.
+type Throttle struct {
+	limits atomic.Pointer[limits]
+}
+
+// limits are the limiters for one Config.
+type limits struct {
+	cfg     *Config
+	total   *rate.Limiter
+	tenants map[string]*rate.Limiter
+}
+
+// New returns a throttle enforcing cfg.
+func New(cfg *Config) *Throttle {
+	t := &Throttle{}
+	t.SetConfig(cfg)
+	return t
+}
+
+// SetConfig replaces the limits. Transfers already in progress keep the
+// limits they started with.
+func (t *Throttle) SetConfig(cfg *Config) {
+	l := &limits{cfg: cfg, total: newLimiter(cfg.Default.Total), tenants: map[string]*rate.Limiter{}}
+	for name, tl := range cfg.Tenants {
+		if tl.Total > 0 {
+			l.tenants[name] = newLimiter(tl.Total)
+		}
+	}
+	t.limits.Store(l)
+}
+
+func newLimiter(bytesPerSec int64) *rate.Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), int(min(bytesPerSec, maxChunk)))
+}
+
+// stream returns the limiters one transfer of tenant is subject to.
+func (t *Throttle) stream(ctx context.Context, tenant string) *stream {
+	limits := t.limits.Load()
+	perConn := limits.cfg.Default.PerConnection
+	if l, ok := limits.cfg.Tenants[tenant]; ok && l.PerConnection > 0 {
+		perConn = l.PerConnection
+	}
+	s := &stream{ctx: ctx, chunk: maxChunk}
+	for _, l := range []*rate.Limiter{newLimiter(perConn), limits.tenants[tenant], limits.total} {
+		if l != nil {
+			s.limiters = append(s.limiters, l)
+			s.chunk = min(s.chunk, l.Burst())
+		}
+	}
+	return s
+}
+
+// Reader limits the rate at which r can be read by one transfer of tenant.
+// Waiting stops with an error when ctx is done.
+func (t *Throttle) Reader(ctx context.Context, tenant string, r io.Reader) io.Reader {
+	s := t.stream(ctx, tenant)
+	if len(s.limiters) == 0 {
+		return r
+	}
+	return &reader{stream: s, r: r}
+}
+
+// Writer limits the rate at which w can be written by one transfer of
+// tenant.
+func (t *Throttle) Writer(ctx context.Context, tenant string, w io.Writer) io.Writer {
+	s := t.stream(ctx, tenant)
+	if len(s.limiters) == 0 {
+		return w
+	}
+	return &writer{stream: s, w: w}
+}
+
+type stream struct {
+	ctx      context.Context
+	limiters []*rate.Limiter
+	chunk    int
+}
+
+func (s *stream) wait(n int) error {
+	for _, l := range s.limiters {
+		if err := l.WaitN(s.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type reader struct {
+	*stream
+	r io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	*stream
+	w io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.chunk)]
+		if err := w.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,90 @@
+package throttle_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/throttle"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader_Unlimited(t *testing.T) {
+	th := throttle.New(&throttle.Config{Tenants: map[string]throttle.Limit{"slow": {PerConnection: 10}}})
+	r := strings.NewReader("data")
+	assert.Same(t, r, th.Reader(context.Background(), "", r))
+}
+
+func TestSetConfig(t *testing.T) {
+	th := throttle.New(&throttle.Config{Default: throttle.Limit{PerConnection: 10}})
+	r := strings.NewReader("data")
+	assert.NotSame(t, r, th.Reader(context.Background(), "", r))
+
+	th.SetConfig(&throttle.Config{})
+	assert.Same(t, r, th.Reader(context.Background(), "", r), "new transfers use the new limits")
+}
+
+func TestReader_PerConnection(t *testing.T) {
+	th := throttle.New(&throttle.Config{Default: throttle.Limit{PerConnection: 200 * 1024}})
+	data := bytes.Repeat([]byte("x"), 100*1024)
+
+	start := time.Now()
+	got, err := io.ReadAll(th.Reader(context.Background(), "", bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	// 64 KiB of burst, the remaining 36 KiB at 200 KiB/s.
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestReader_TenantOverrideAndCancel(t *testing.T) {
+	th := throttle.New(&throttle.Config{
+		Default: throttle.Limit{PerConnection: 1 << 30},
+		Tenants: map[string]throttle.Limit{"batch": {PerConnection: 1000}},
+	})
+	data := bytes.Repeat([]byte("x"), 10*1000)
+
+	_, err := io.ReadAll(th.Reader(context.Background(), "interactive", bytes.NewReader(data)))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = io.ReadAll(th.Reader(ctx, "batch", bytes.NewReader(data)))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWriter_SharedTotal(t *testing.T) {
+	th := throttle.New(&throttle.Config{Tenants: map[string]throttle.Limit{"batch": {Total: 100 * 1024}}})
+	data := bytes.Repeat([]byte("y"), 50*1024)
+
+	start := time.Now()
+	for range 2 {
+		var out bytes.Buffer
+		n, err := th.Writer(context.Background(), "batch", &out).Write(data)
+		require.NoError(t, err)
+		assert.Equal(t, len(data), n)
+		assert.Equal(t, data, out.Bytes())
+	}
+	// The second write shares the tenant's bucket with the first.
+	assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bandwidth.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"default":{"perConnection":1048576,"total":10485760},"tenants":{"batch":{"total":2097152}}}`), 0o600))
+	cfg, err := throttle.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2097152), cfg.Tenants["batch"].Total)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"tenants":{"batch":{"total":-1}}}`), 0o600))
+	_, err = throttle.LoadConfig(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,169 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Client certificate modes accepted by ParseClientAuth.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+type state struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// Reloader serves a certificate and client CA bundle from files and picks
+// up changes without a restart, e.g. when cert-manager renews a mounted
+// secret. Handshakes always use the most recently loaded good pair.
+type Reloader struct {
+	certFile, keyFile, caFile string
+	logger                    *zap.Logger
+
+	state atomic.Pointer[state]
+	mu    sync.Mutex
+	last  [][]byte
+}
+
+// NewReloader loads the certificate, key and, if caFile is not empty, the
+// PEM bundle of CAs that client certificates must chain to.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		logger:   zap.L().Named("tls"),
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the files. It reports whether anything changed. On error
+// the previously loaded certificate stays in use.
+func (r *Reloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	raw := make([][]byte, len(files))
+	for i, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return false, err
+		}
+		raw[i] = b
+	}
+	if r.last != nil && equalAll(raw, r.last) {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(raw[0], raw[1])
+	if err != nil {
+		return false, fmt.Errorf("tls key pair %s: %w", r.certFile, err)
+	}
+	s := &state{cert: &cert}
+	if r.caFile != "" {
+		s.clientCAs = x509.NewCertPool()
+		if !s.clientCAs.AppendCertsFromPEM(raw[2]) {
+			return false, fmt.Errorf("client CA file %s has no certificates", r.caFile)
+		}
+	}
+
+	r.state.Store(s)
+	r.last = raw
+	r.logger.Info("Loaded TLS certificate",
+		zap.String("subject", cert.Leaf.Subject.String()),
+		zap.Time("notAfter", cert.Leaf.NotAfter),
+	)
+	return true, nil
+}
+
+// Watch polls the files every interval until ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Reload(); err != nil {
+				r.logger.Error("Failed to reload TLS certificate, keeping previous one", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Config returns a server TLS configuration that resolves the certificate
+// and client CAs on every handshake.
+func (r *Reloader) Config(clientAuth tls.ClientAuthType) *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: clientAuth,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	cfg := base.Clone()
+	cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.state.Load().cert, nil
+	}
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		s := r.state.Load()
+		c := base.Clone()
+		c.Certificates = []tls.Certificate{*s.cert}
+		c.ClientCAs = s.clientCAs
+		return c, nil
+	}
+	return cfg
+}
+
+// ParseClientAuth maps a client certificate mode to its tls setting.
+// Client certificates can only be verified when a CA file is configured.
+func ParseClientAuth(mode string, haveCAs bool) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", ClientAuthNone:
+		if haveCAs && mode == "" {
+			return tls.VerifyClientCertIfGiven, nil
+		}
+		return tls.NoClientCert, nil
+	case ClientAuthOptional, ClientAuthRequire:
+		if !haveCAs {
+			return 0, errors.New("client certificate verification needs a client CA file")
+		}
+		if mode == ClientAuthRequire {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.VerifyClientCertIfGiven, nil
+	}
+	return 0, fmt.Errorf("unknown client auth mode %q", mode)
+}
+
+func equalAll(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package tlsconfig_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"stream-upload-file/pkg/tlsconfig"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// issue creates a certificate signed by parent, or a self-signed CA when
+// parent is nil.
+func issue(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(certFile, c.certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, c.keyPEM, 0o600))
+}
+
+// serve accepts TLS connections and completes their handshakes.
+func serve(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_ = conn.(*tls.Conn).Handshake()
+				_, _ = conn.Read(make([]byte, 1))
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func servedSerial(t *testing.T, addr string) *big.Int {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber
+}
+
+func TestReloader_HotReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first := issue(t, "server", nil)
+	first.write(t, certFile, keyFile)
+
+	r, err := tlsconfig.NewReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	addr := serve(t, r.Config(tls.NoClientCert))
+	assert.Equal(t, first.cert.SerialNumber, servedSerial(t, addr))
+
+	changed, err := r.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	second := issue(t, "server", nil)
+	second.write(t, certFile, keyFile)
+	changed, err = r.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, second.cert.SerialNumber, servedSerial(t, addr))
+
+	// A half-written renewal is rejected and the current certificate kept.
+	require.NoError(t, os.WriteFile(keyFile, first.keyPEM, 0o600))
+	_, err = r.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, second.cert.SerialNumber, servedSerial(t, addr))
+}
+
+func TestReloader_ClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "ca", nil)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.certPEM, 0o600))
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	issue(t, "server", ca).write(t, certFile, keyFile)
+
+	r, err := tlsconfig.NewReloader(certFile, keyFile, caFile)
+	require.NoError(t, err)
+	addr := serve(t, r.Config(tls.RequireAndVerifyClientCert))
+
+	handshake := func(client *testCert) error {
+		cfg := &tls.Config{InsecureSkipVerify: true}
+		if client != nil {
+			cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}}
+		}
+		conn, err := tls.Dial("tcp", addr, cfg)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// With TLS 1.3 a rejected client certificate surfaces on first read.
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		return err
+	}
+
+	assert.NoError(t, handshake(issue(t, "client", ca)))
+	assert.Error(t, handshake(nil))
+	assert.Error(t, handshake(issue(t, "stranger", issue(t, "other-ca", nil))))
+}
+
+func TestParseClientAuth(t *testing.T) {
+	cases := []struct {
+		mode    string
+		haveCAs bool
+		want    tls.ClientAuthType
+		wantErr bool
+	}{
+		{"", false, tls.NoClientCert, false},
+		{"", true, tls.VerifyClientCertIfGiven, false},
+		{"none", true, tls.NoClientCert, false},
+		{"optional", true, tls.VerifyClientCertIfGiven, false},
+		{"require", true, tls.RequireAndVerifyClientCert, false},
+		{"require", false, 0, true},
+		{"always", true, 0, true},
+	}
+	for _, tc := range cases {
+		got, err := tlsconfig.ParseClientAuth(tc.mode, tc.haveCAs)
+		if tc.wantErr {
+			assert.Error(t, err, tc.mode)
+			continue
+		}
+		assert.NoError(t, err, tc.mode)
+		assert.Equal(t, tc.want, got, tc.mode)
+	}
+}
@@ -0,0 +1,84 @@
+// Package tracing sets up OpenTelemetry tracing: OTLP export, W3C trace
+// context propagation and server spans for the gin router.
+package tracing
+
+import (
+	"context"
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is reported as service.name unless OTEL_SERVICE_NAME is set.
+const ServiceName = "stream-upload-file"
+
+const tracerName = "stream-upload-file"
+
+// Enabled reports whether an OTLP endpoint is configured through the
+// standard OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+// variables.
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Setup installs a global tracer provider that exports spans over
+// OTLP/HTTP, and the W3C trace context and baggage propagators. The
+// endpoint, headers, sampler and resource attributes follow the standard
+// OTEL_* environment variables; opts override them. The returned function
+// flushes buffered spans and stops the exporter.
+func Setup(ctx context.Context, opts ...otlptracehttp.Option) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for every request, continuing the
+// caller's trace when it sends a traceparent header. Requests to the routes
+// in skip, such as probes, are not traced.
+func Middleware(skip ...string) gin.HandlerFunc {
+	return otelgin.Middleware(ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		return !slices.Contains(skip, c.FullPath())
+	}))
+}
+
+// Start starts a span as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End marks span as failed if err is set, then ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,93 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"stream-upload-file/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is an in-process OTLP/HTTP trace receiver.
+type collector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (col *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req collectortrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	col.mu.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			col.spans = append(col.spans, ss.Spans...)
+		}
+	}
+	col.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	out, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Write(out)
+}
+
+func (col *collector) span(name string) *tracepb.Span {
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	for _, s := range col.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestSetup_ExportsAndPropagates(t *testing.T) {
+	col := &collector{}
+	srv := httptest.NewServer(col)
+	defer srv.Close()
+
+	shutdown, err := tracing.Setup(context.Background(), otlptracehttp.WithEndpointURL(srv.URL+"/v1/traces"))
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(tracing.Middleware("/healthz"))
+	router.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/download/*filename", func(c *gin.Context) {
+		_, span := tracing.Start(c.Request.Context(), "storage.download")
+		tracing.End(span, io.ErrUnexpectedEOF)
+		c.Status(http.StatusOK)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/download/a.txt", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	require.NoError(t, shutdown(context.Background()))
+
+	server := col.span("GET /download/*filename")
+	child := col.span("storage.download")
+	require.NotNil(t, server)
+	require.NotNil(t, child)
+	assert.Equal(t, traceID, hex.EncodeToString(server.TraceId), "the caller's trace is continued")
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(server.ParentSpanId))
+	assert.Equal(t, server.SpanId, child.ParentSpanId)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, child.Status.Code)
+	assert.Nil(t, col.span("GET /healthz"), "skipped routes are not traced")
+}
@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+
+	"stream-upload-file/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ListHandler lists recent deliveries and dead letters, optionally
+// filtered with ?status=pending|retrying|delivered|failed.
+func (d *Dispatcher) ListHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := c.Query("status")
+		switch status {
+		case "", StatusPending, StatusRetrying, StatusDelivered, StatusFailed:
+		default:
+			c.JSON(http.StatusBadRequest, requestid.Error(c, "Unknown status"))
+			return
+		}
+		deliveries, err := d.Deliveries(status)
+		if err != nil {
+			requestid.Logger(c, d.logger).Error("Failed to list webhook deliveries", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to list deliveries"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deliveries": deliveries, "count": len(deliveries)})
+	}
+}
+
+// GetHandler returns the delivery named by the :id route parameter.
+func (d *Dispatcher) GetHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		del, err := d.Delivery(c.Param("id"))
+		if err != nil {
+			d.respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, del)
+	}
+}
+
+// RetryHandler requeues the dead letter named by the :id route parameter.
+func (d *Dispatcher) RetryHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		del, err := d.Retry(c.Param("id"))
+		if err != nil {
+			d.respondError(c, err)
+			return
+		}
+		requestid.Logger(c, d.logger).Info("Webhook delivery requeued", zap.String("delivery", del.ID), zap.String("target", del.Target))
+		c.JSON(http.StatusAccepted, del)
+	}
+}
+
+func (d *Dispatcher) respondError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, requestid.Error(c, "Delivery not found"))
+		return
+	}
+	requestid.Logger(c, d.logger).Error("Failed to read webhook delivery", zap.Error(err))
+	c.JSON(http.StatusInternalServerError, requestid.Error(c, "Failed to read delivery"))
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrNotFound = errors.New("delivery not found")
+
+// DeadLetterStore keeps deliveries that could not be made, so they can be
+// inspected and retried.
+type DeadLetterStore interface {
+	Put(d *Delivery) error
+	Get(id string) (*Delivery, error)
+	List() ([]*Delivery, error)
+	Delete(id string) error
+}
+
+// MemoryStore is a DeadLetterStore that lives as long as the process.
+type MemoryStore struct {
+	mu         sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{deliveries: map[string]*Delivery{}}
+}
+
+// Put implements DeadLetterStore.
+func (s *MemoryStore) Put(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *d
+	s.deliveries[d.ID] = &copied
+	return nil
+}
+
+// Get implements DeadLetterStore.
+func (s *MemoryStore) Get(id string) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *d
+	return &copied, nil
+}
+
+// List implements DeadLetterStore.
+func (s *MemoryStore) List() ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*Delivery, 0, len(s.deliveries))
+	for _, d := range s.deliveries {
+		copied := *d
+		out = append(out, &copied)
+	}
+	return out, nil
+}
+
+// Delete implements DeadLetterStore.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.deliveries[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.deliveries, id)
+	return nil
+}
+
+// DirStore is a DeadLetterStore keeping one JSON file per delivery in a
+// directory, e.g. on a persistent volume, so dead letters survive restarts.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore uses dir, creating it if needed.
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (s *DirStore) path(id string) (string, error) {
+	// IDs come from API paths, so keep them from naming other files.
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// Put implements DeadLetterStore. The file is written to a temporary name
+// first so that a crash never leaves a truncated delivery behind.
+func (s *DirStore) Put(d *Delivery) error {
+	path, err := s.path(d.ID)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Get implements DeadLetterStore.
+func (s *DirStore) Get(id string) (*Delivery, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d Delivery
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, fmt.Errorf("dead letter %s: %w", id, err)
+	}
+	return &d, nil
+}
+
+// List implements DeadLetterStore.
+func (s *DirStore) List() ([]*Delivery, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Delivery, 0, len(paths))
+	for _, p := range paths {
+		d, err := s.Get(strings.TrimSuffix(filepath.Base(p), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// Delete implements DeadLetterStore.
+func (s *DirStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package webhook_test
+
+import (
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterStores(t *testing.T) {
+	dir, err := webhook.NewDirStore(t.TempDir())
+	require.NoError(t, err)
+
+	for name, store := range map[string]webhook.DeadLetterStore{"memory": webhook.NewMemoryStore(), "dir": dir} {
+		d := &webhook.Delivery{
+			ID:        "abc123",
+			Target:    "etl",
+			Status:    webhook.StatusFailed,
+			Event:     webhook.Event{ID: "e1", Type: webhook.EventFileUploaded, File: webhook.File{Name: "a.txt", Size: 3}},
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		}
+		require.NoError(t, store.Put(d), name)
+
+		got, err := store.Get("abc123")
+		require.NoError(t, err, name)
+		assert.Equal(t, d, got, name)
+
+		all, err := store.List()
+		require.NoError(t, err, name)
+		assert.Len(t, all, 1, name)
+
+		require.NoError(t, store.Delete("abc123"), name)
+		_, err = store.Get("abc123")
+		assert.ErrorIs(t, err, webhook.ErrNotFound, name)
+		assert.ErrorIs(t, store.Delete("abc123"), webhook.ErrNotFound, name)
+	}
+
+	_, err = dir.Get("../secret")
+	assert.ErrorIs(t, err, webhook.ErrNotFound)
+}
@@ -0,0 +1,389 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Delivery states.
+const (
+	StatusPending   = "pending"
+	StatusRetrying  = "retrying"
+	StatusDelivered = "delivered"
+	// StatusFailed deliveries are in the dead-letter store.
+	StatusFailed = "failed"
+)
+
+const (
+	queueSize = 1024
+	// maxRecent bounds how many finished deliveries are kept for the
+	// status API. Pending ones are always kept.
+	maxRecent = 1000
+)
+
+// Delivery is one event on its way to one target.
+type Delivery struct {
+	ID          string    `json:"id"`
+	Target      string    `json:"target"`
+	Event       Event     `json:"event"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastCode    int       `json:"lastStatusCode,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt,omitzero"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Dispatcher delivers events to the configured targets in the background,
+// retrying with exponential backoff. Deliveries that still fail after
+// MaxAttempts, or that are pending at shutdown, go to the dead-letter
+// store.
+type Dispatcher struct {
+	cfg    atomic.Pointer[dispatchConfig]
+	dead   DeadLetterStore
+	client *http.Client
+	logger *zap.Logger
+	queue  chan *Delivery
+
+	mu     sync.Mutex
+	recent map[string]*Delivery
+	order  []string
+}
+
+// dispatchConfig is a Config with its targets indexed by name.
+type dispatchConfig struct {
+	*Config
+	targets map[string]*Target
+}
+
+// NewDispatcher returns a dispatcher for cfg. Call Run to start delivering.
+func NewDispatcher(cfg *Config, dead DeadLetterStore) *Dispatcher {
+	d := &Dispatcher{
+		dead:   dead,
+		client: &http.Client{},
+		logger: zap.L().Named("webhooks"),
+		queue:  make(chan *Delivery, queueSize),
+		recent: map[string]*Delivery{},
+	}
+	d.SetConfig(cfg)
+	return d
+}
+
+// SetConfig replaces the targets and delivery settings. Queued deliveries
+// to a target that was removed go to the dead-letter store; the others are
+// retried under the new settings.
+func (d *Dispatcher) SetConfig(cfg *Config) {
+	dc := &dispatchConfig{Config: cfg, targets: map[string]*Target{}}
+	for _, t := range cfg.Targets {
+		dc.targets[t.Name] = t
+	}
+	d.cfg.Store(dc)
+}
+
+// Publish queues e for every target that wants it. It never blocks: if the
+// queue is full the deliveries go straight to the dead-letter store.
+func (d *Dispatcher) Publish(e Event) {
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	for _, t := range d.cfg.Load().Targets {
+		if !t.wants(&e) {
+			continue
+		}
+		now := time.Now().UTC()
+		del := &Delivery{
+			ID:        newID(),
+			Target:    t.Name,
+			Event:     e,
+			Status:    StatusPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		d.track(del)
+		d.enqueue(del)
+	}
+}
+
+func (d *Dispatcher) enqueue(del *Delivery) {
+	select {
+	case d.queue <- del:
+	default:
+		d.update(del, func() { del.LastError = "delivery queue full" })
+		d.deadLetter(del)
+	}
+}
+
+// Run delivers queued events with the given number of workers until ctx is
+// done. Deliveries still pending then are moved to the dead-letter store.
+func (d *Dispatcher) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case del := <-d.queue:
+					d.attempt(ctx, del)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	d.mu.Lock()
+	var pending []*Delivery
+	for _, del := range d.recent {
+		if del.Status == StatusPending || del.Status == StatusRetrying {
+			pending = append(pending, del)
+		}
+	}
+	d.mu.Unlock()
+	for _, del := range pending {
+		d.update(del, func() { del.LastError = "not delivered before shutdown" })
+		d.deadLetter(del)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, del *Delivery) {
+	cfg := d.cfg.Load()
+	t, ok := cfg.targets[del.Target]
+	if !ok {
+		d.update(del, func() { del.LastError = "target no longer configured" })
+		d.deadLetter(del)
+		return
+	}
+
+	code, err := d.send(ctx, t, del, time.Duration(cfg.Timeout))
+	// Retry resets the delivery under d.mu, so the count is only read there.
+	var attempts int
+	d.update(del, func() {
+		del.Attempts++
+		attempts = del.Attempts
+		del.LastCode = code
+		del.LastError = ""
+		if err != nil {
+			del.LastError = err.Error()
+		}
+	})
+	if err == nil {
+		d.update(del, func() {
+			del.Status = StatusDelivered
+			del.NextAttempt = time.Time{}
+		})
+		d.logger.Info("Webhook delivered",
+			zap.String("target", t.Name),
+			zap.String("event", del.Event.ID),
+			zap.Int("attempts", attempts),
+		)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if !retryable(code) || attempts >= cfg.MaxAttempts {
+		d.deadLetter(del)
+		return
+	}
+
+	wait := cfg.backoff(attempts)
+	d.update(del, func() {
+		del.Status = StatusRetrying
+		del.NextAttempt = time.Now().Add(wait).UTC()
+	})
+	d.logger.Warn("Webhook delivery failed, retrying",
+		zap.String("target", t.Name),
+		zap.String("event", del.Event.ID),
+		zap.Int("attempts", attempts),
+		zap.Duration("retryIn", wait),
+		zap.Error(err),
+	)
+	time.AfterFunc(wait, func() {
+		if ctx.Err() == nil {
+			d.enqueue(del)
+		}
+	})
+}
+
+func (d *Dispatcher) send(ctx context.Context, t *Target, del *Delivery, timeout time.Duration) (int, error) {
+	body, err := json.Marshal(del.Event)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, del.Event.ID)
+	req.Header.Set(HeaderTimestamp, fmt.Sprint(now.Unix()))
+	req.Header.Set(HeaderSignature, Sign(t.Secret, now, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("target responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether a failed attempt is worth repeating. Client
+// errors other than timeouts and throttling won't go away by themselves.
+func retryable(code int) bool {
+	if code == 0 || code >= 500 {
+		return true
+	}
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// backoff doubles from InitialBackoff up to MaxBackoff, with jitter so that
+// deliveries failing together don't retry together.
+func (cfg *Config) backoff(attempts int) time.Duration {
+	wait := time.Duration(cfg.InitialBackoff) << min(attempts-1, 30)
+	wait = min(wait, time.Duration(cfg.MaxBackoff))
+	return wait/2 + rand.N(wait/2+1)
+}
+
+func (d *Dispatcher) deadLetter(del *Delivery) {
+	d.update(del, func() {
+		del.Status = StatusFailed
+		del.NextAttempt = time.Time{}
+	})
+	d.mu.Lock()
+	snapshot := *del
+	d.mu.Unlock()
+	if err := d.dead.Put(&snapshot); err != nil {
+		d.logger.Error("Failed to store dead letter", zap.String("delivery", del.ID), zap.Error(err))
+	}
+	d.logger.Error("Webhook delivery failed permanently",
+		zap.String("target", del.Target),
+		zap.String("event", del.Event.ID),
+		zap.Int("attempts", snapshot.Attempts),
+		zap.String("lastError", snapshot.LastError),
+	)
+}
+
+func (d *Dispatcher) track(del *Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recent[del.ID] = del
+	d.order = append(d.order, del.ID)
+	if len(d.order) <= maxRecent {
+		return
+	}
+	kept := d.order[:0]
+	for _, id := range d.order {
+		r := d.recent[id]
+		if len(d.recent) > maxRecent && (r.Status == StatusDelivered || r.Status == StatusFailed) {
+			delete(d.recent, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	d.order = kept
+}
+
+func (d *Dispatcher) update(del *Delivery, f func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f()
+	del.UpdatedAt = time.Now().UTC()
+}
+
+// Deliveries returns recent deliveries and every dead letter, newest
+// first, optionally only those with the given status.
+func (d *Dispatcher) Deliveries(status string) ([]Delivery, error) {
+	dead, err := d.dead.List()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var out []Delivery
+	d.mu.Lock()
+	for _, del := range d.recent {
+		seen[del.ID] = true
+		if status == "" || del.Status == status {
+			out = append(out, *del)
+		}
+	}
+	d.mu.Unlock()
+	for _, del := range dead {
+		if !seen[del.ID] && (status == "" || del.Status == status) {
+			out = append(out, *del)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+// Delivery returns one delivery by ID.
+func (d *Dispatcher) Delivery(id string) (Delivery, error) {
+	d.mu.Lock()
+	del, ok := d.recent[id]
+	if ok {
+		copied := *del
+		d.mu.Unlock()
+		return copied, nil
+	}
+	d.mu.Unlock()
+	dead, err := d.dead.Get(id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	return *dead, nil
+}
+
+// Retry takes a delivery out of the dead-letter store and queues it again
+// with a fresh set of attempts.
+func (d *Dispatcher) Retry(id string) (Delivery, error) {
+	dead, err := d.dead.Get(id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	if err := d.dead.Delete(id); err != nil {
+		return Delivery{}, err
+	}
+
+	d.mu.Lock()
+	del, ok := d.recent[id]
+	d.mu.Unlock()
+	if !ok {
+		del = dead
+		d.track(del)
+	}
+	d.update(del, func() {
+		del.Status = StatusPending
+		del.Attempts = 0
+		del.LastError = ""
+		del.LastCode = 0
+	})
+	d.enqueue(del)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return *del, nil
+}
@@ -0,0 +1,265 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/webhook"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("0123456789abcdef")
+
+// receiver answers with the given status codes in turn, then 200, and
+// records the events it accepted. reject, when set, is asked first.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	reject   func(e webhook.Event) int
+	calls    atomic.Int32
+	events   []webhook.Event
+	badSigs  int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.calls.Add(1)
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := webhook.Verify(testSecret, req.Header, body, time.Minute); err != nil {
+		r.badSigs++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var e webhook.Event
+	json.Unmarshal(body, &e)
+	if r.reject != nil {
+		if status := r.reject(e); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	r.events = append(r.events, e)
+}
+
+func (r *receiver) accepted() []webhook.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhook.Event(nil), r.events...)
+}
+
+func startDispatcher(t *testing.T, urls map[string]string, maxAttempts int) (*webhook.Dispatcher, *webhook.MemoryStore) {
+	t.Helper()
+	cfg := &webhook.Config{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: webhook.Duration(10 * time.Millisecond),
+		MaxBackoff:     webhook.Duration(20 * time.Millisecond),
+		Timeout:        webhook.Duration(time.Second),
+	}
+	for name, url := range urls {
+		target := &webhook.Target{Name: name, URL: url, Secret: testSecret}
+		if name == "finance-only" {
+			target.Tenants = []string{"finance"}
+		}
+		cfg.Targets = append(cfg.Targets, target)
+	}
+	dead := webhook.NewMemoryStore()
+	d := webhook.NewDispatcher(cfg, dead)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, 2)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d, dead
+}
+
+func uploaded(name, tenant string) webhook.Event {
+	return webhook.Event{Type: webhook.EventFileUploaded, Tenant: tenant, File: webhook.File{Name: name, Size: 5, Checksum: "sha256:x"}}
+}
+
+func TestDispatcher_DeliversSignedEvents(t *testing.T) {
+	all, finance := &receiver{}, &receiver{}
+	allSrv, financeSrv := httptest.NewServer(all), httptest.NewServer(finance)
+	defer allSrv.Close()
+	defer financeSrv.Close()
+	d, _ := startDispatcher(t, map[string]string{"all": allSrv.URL, "finance-only": financeSrv.URL}, 3)
+
+	d.Publish(uploaded("a.txt", "hr"))
+	d.Publish(uploaded("b.txt", "finance"))
+
+	require.Eventually(t, func() bool {
+		delivered, _ := d.Deliveries(webhook.StatusDelivered)
+		return len(delivered) == 3
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Len(t, all.accepted(), 2)
+	require.Len(t, finance.accepted(), 1)
+	assert.Equal(t, "b.txt", finance.accepted()[0].File.Name)
+	assert.NotEmpty(t, finance.accepted()[0].ID)
+	assert.Zero(t, all.badSigs)
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusBadGateway, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	d, dead := startDispatcher(t, map[string]string{"etl": srv.URL}, 5)
+
+	d.Publish(uploaded("a.txt", ""))
+	var delivered []webhook.Delivery
+	require.Eventually(t, func() bool {
+		delivered, _ = d.Deliveries(webhook.StatusDelivered)
+		return len(delivered) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Len(t, r.accepted(), 1)
+	assert.Equal(t, 3, delivered[0].Attempts)
+	letters, _ := dead.List()
+	assert.Empty(t, letters)
+}
+
+func TestDispatcher_SetConfig(t *testing.T) {
+	before, after := &receiver{}, &receiver{}
+	beforeSrv, afterSrv := httptest.NewServer(before), httptest.NewServer(after)
+	defer beforeSrv.Close()
+	defer afterSrv.Close()
+	d, _ := startDispatcher(t, map[string]string{"etl": beforeSrv.URL}, 3)
+
+	d.SetConfig(&webhook.Config{
+		Targets:        []*webhook.Target{{Name: "archive", URL: afterSrv.URL, Secret: testSecret}},
+		MaxAttempts:    3,
+		InitialBackoff: webhook.Duration(10 * time.Millisecond),
+		MaxBackoff:     webhook.Duration(20 * time.Millisecond),
+		Timeout:        webhook.Duration(time.Second),
+	})
+	d.Publish(uploaded("a.txt", ""))
+
+	require.Eventually(t, func() bool {
+		return len(after.accepted()) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Empty(t, before.accepted(), "removed targets get no new events")
+}
+
+func TestDispatcher_DeadLettersAndRetry(t *testing.T) {
+	var healthy atomic.Bool
+	r := &receiver{reject: func(e webhook.Event) int {
+		switch {
+		case healthy.Load():
+			return 0
+		case e.File.Name == "gone.txt":
+			return http.StatusGone
+		default:
+			return http.StatusInternalServerError
+		}
+	}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	d, dead := startDispatcher(t, map[string]string{"etl": srv.URL}, 2)
+
+	// Two 500s exhaust the attempts; 410 is not retried at all.
+	d.Publish(uploaded("a.txt", ""))
+	d.Publish(uploaded("gone.txt", ""))
+	require.Eventually(t, func() bool {
+		letters, _ := dead.List()
+		return len(letters) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(3), r.calls.Load())
+
+	failed, err := d.Deliveries(webhook.StatusFailed)
+	require.NoError(t, err)
+	require.Len(t, failed, 2)
+	for _, f := range failed {
+		assert.NotEmpty(t, f.LastError)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/webhooks/deliveries", d.ListHandler())
+	router.GET("/webhooks/deliveries/:id", d.GetHandler())
+	router.POST("/webhooks/deliveries/:id/retry", d.RetryHandler())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/deliveries?status=failed", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"count":2`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/deliveries?status=lost", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/deliveries/"+failed[0].ID, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	healthy.Store(true)
+	for _, f := range failed {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/deliveries/"+f.ID+"/retry", nil))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+	require.Eventually(t, func() bool {
+		delivered, _ := d.Deliveries(webhook.StatusDelivered)
+		return len(delivered) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Len(t, r.accepted(), 2)
+	letters, _ := dead.List()
+	assert.Empty(t, letters)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/deliveries/"+failed[0].ID+"/retry", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "only dead letters can be retried")
+}
+
+func TestDispatcher_PendingAtShutdownAreDeadLettered(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	cfg := &webhook.Config{
+		Targets:        []*webhook.Target{{Name: "slow", URL: srv.URL, Secret: testSecret}},
+		MaxAttempts:    3,
+		InitialBackoff: webhook.Duration(time.Millisecond),
+		MaxBackoff:     webhook.Duration(time.Millisecond),
+		Timeout:        webhook.Duration(time.Minute),
+	}
+	dead := webhook.NewMemoryStore()
+	d := webhook.NewDispatcher(cfg, dead)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, 1)
+		close(done)
+	}()
+
+	d.Publish(uploaded("a.txt", ""))
+	d.Publish(uploaded("b.txt", ""))
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+
+	letters, err := dead.List()
+	require.NoError(t, err)
+	assert.Len(t, letters, 2)
+}
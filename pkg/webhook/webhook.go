@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderID        = "X-Webhook-ID"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// EventFileUploaded is sent once a file has been stored.
+const EventFileUploaded = "file.uploaded"
+
+var (
+	ErrMissingSignature = errors.New("webhook signature missing")
+	ErrInvalidSignature = errors.New("webhook signature is invalid")
+	ErrStaleTimestamp   = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// Event is the JSON body of a webhook.
+type Event struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant,omitempty"`
+	File   File      `json:"file"`
+}
+
+// File describes the file an event is about.
+type File struct {
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	ContentType string            `json:"contentType,omitempty"`
+	Checksum    string            `json:"checksum,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// Target is an endpoint that receives webhooks.
+type Target struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// SecretFile holds the HMAC key shared with the receiver.
+	SecretFile string `json:"secretFile"`
+	// Tenants, when set, limits the target to events of these tenants.
+	Tenants []string `json:"tenants,omitempty"`
+
+	Secret []byte `json:"-"`
+}
+
+func (t *Target) wants(e *Event) bool {
+	if len(t.Tenants) == 0 {
+		return true
+	}
+	for _, name := range t.Tenants {
+		if name == e.Tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// Config is the on-disk form of the webhook configuration.
+type Config struct {
+	Targets []*Target `json:"targets"`
+	// MaxAttempts is how often a delivery is tried before it is moved to
+	// the dead-letter store. Defaults to 8.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// InitialBackoff is the wait before the first retry, doubled for each
+	// further one up to MaxBackoff. Default 1s and 5m.
+	InitialBackoff Duration `json:"initialBackoff,omitempty"`
+	MaxBackoff     Duration `json:"maxBackoff,omitempty"`
+	// Timeout bounds each delivery attempt. Defaults to 10s.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads the webhook configuration and each target's secret.
+func LoadConfig(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("webhook config %s: %w", path, err)
+	}
+	for _, t := range cfg.Targets {
+		if t.SecretFile == "" {
+			return nil, fmt.Errorf("webhook target %q has no secretFile", t.Name)
+		}
+		secret, err := os.ReadFile(t.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("webhook target %q: %w", t.Name, err)
+		}
+		t.Secret = []byte(strings.TrimSpace(string(secret)))
+	}
+	if err := cfg.normalize(); err != nil {
+		return nil, fmt.Errorf("webhook config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) normalize() error {
+	names := map[string]bool{}
+	for _, t := range cfg.Targets {
+		if t.Name == "" {
+			return errors.New("every target needs a name")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target %q", t.Name)
+		}
+		names[t.Name] = true
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("target %q has an invalid url", t.Name)
+		}
+		if len(t.Secret) < 16 {
+			return fmt.Errorf("target %q secret must be at least 16 bytes", t.Name)
+		}
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = Duration(time.Second)
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = Duration(5 * time.Minute)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = Duration(10 * time.Second)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for a body sent at timestamp.
+// The timestamp is signed too, so a captured delivery can't be replayed
+// later with a fresh one.
+func Sign(secret []byte, timestamp time.Time, body []byte) string {
+	return "sha256=" + hex.EncodeToString(mac(secret, timestamp.Unix(), body))
+}
+
+func mac(secret []byte, timestamp int64, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%d.", timestamp)
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Verify checks the signature of a received webhook and that it was sent
+// within tolerance of now. Receivers written in Go can use it as-is.
+func Verify(secret []byte, header http.Header, body []byte, tolerance time.Duration) error {
+	sig, ok := strings.CutPrefix(header.Get(HeaderSignature), "sha256=")
+	if !ok {
+		return ErrMissingSignature
+	}
+	ts, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	want, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(want, mac(secret, ts, body)) {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > tolerance || age < -tolerance {
+		return ErrStaleTimestamp
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
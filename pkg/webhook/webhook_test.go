@@ -0,0 +1,68 @@
+package webhook_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"stream-upload-file/pkg/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	body := []byte(`{"id":"1"}`)
+	now := time.Now()
+
+	h := http.Header{}
+	h.Set(webhook.HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	h.Set(webhook.HeaderSignature, webhook.Sign(secret, now, body))
+	require.NoError(t, webhook.Verify(secret, h, body, time.Minute))
+
+	assert.ErrorIs(t, webhook.Verify(secret, h, []byte(`{"id":"2"}`), time.Minute), webhook.ErrInvalidSignature)
+	assert.ErrorIs(t, webhook.Verify([]byte("another-secret!!"), h, body, time.Minute), webhook.ErrInvalidSignature)
+
+	// Replaying with a new timestamp breaks the signature.
+	h.Set(webhook.HeaderTimestamp, strconv.FormatInt(now.Unix()+1, 10))
+	assert.ErrorIs(t, webhook.Verify(secret, h, body, time.Minute), webhook.ErrInvalidSignature)
+
+	old := now.Add(-time.Hour)
+	h.Set(webhook.HeaderTimestamp, strconv.FormatInt(old.Unix(), 10))
+	h.Set(webhook.HeaderSignature, webhook.Sign(secret, old, body))
+	assert.ErrorIs(t, webhook.Verify(secret, h, body, time.Minute), webhook.ErrStaleTimestamp)
+
+	assert.ErrorIs(t, webhook.Verify(secret, http.Header{}, body, time.Minute), webhook.ErrMissingSignature)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("0123456789abcdef\n"), 0o600))
+	write := func(body string) string {
+		path := filepath.Join(dir, "webhooks.json")
+		require.NoError(t, os.WriteFile(path, []byte(body), 0o600))
+		return path
+	}
+
+	cfg, err := webhook.LoadConfig(write(`{"targets":[{"name":"etl","url":"https://etl.example.com/hook","secretFile":"` + secretFile + `"}],"initialBackoff":"2s"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("0123456789abcdef"), cfg.Targets[0].Secret)
+	assert.Equal(t, webhook.Duration(2*time.Second), cfg.InitialBackoff)
+	assert.Equal(t, 8, cfg.MaxAttempts)
+	assert.Equal(t, webhook.Duration(10*time.Second), cfg.Timeout)
+
+	for name, body := range map[string]string{
+		"no secret":   `{"targets":[{"name":"a","url":"https://a.example.com"}]}`,
+		"bad url":     `{"targets":[{"name":"a","url":"ftp://a","secretFile":"` + secretFile + `"}]}`,
+		"duplicate":   `{"targets":[{"name":"a","url":"https://a.example.com","secretFile":"` + secretFile + `"},{"name":"a","url":"https://b.example.com","secretFile":"` + secretFile + `"}]}`,
+		"bad backoff": `{"targets":[],"initialBackoff":"soon"}`,
+	} {
+		_, err := webhook.LoadConfig(write(body))
+		assert.Error(t, err, name)
+	}
+}